@@ -80,6 +80,37 @@ func TestAnyString(t *testing.T) {
 			input: pickled([]byte("\x80\x04\x95\x07\x00\x00\x00\x00\x00\x00\x00\x8c\x03bar\x94.")),
 			want:  `"bar"`,
 		},
+		{
+			// $ cat mymod.py
+			// from dataclasses import dataclass
+			// @dataclass
+			// class Pet:
+			//     name: str
+			//     age: int
+			// $ python3 -c 'import pickle, mymod; print(pickle.dumps(mymod.Pet("fido", 3)))'
+			input: pickled([]byte("\x80\x04\x95/\x00\x00\x00\x00\x00\x00\x00\x8c\x05mymod\x94\x8c\x03Pet\x94\x93\x94)\x81\x94}\x94(\x8c\x04name\x94\x8c\x04fido\x94\x8c\x03age\x94K\x03ub.")),
+			want:  `mymod.Pet(name="fido", age=3)`,
+		},
+		{
+			// $ python3 -c 'import pickle; print(pickle.dumps({"data": b"\x00\x01hello world", "arr": bytearray(b"xyz")}))'
+			input: pickled([]byte("\x80\x04\x95E\x00\x00\x00\x00\x00\x00\x00}\x94(\x8c\x04data\x94C\r\x00\x01hello world\x94\x8c\x03arr\x94\x8c\x08builtins\x94\x8c\tbytearray\x94\x93\x94C\x03xyz\x94\x85\x94R\x94u.")),
+			want:  `{"data": b'\x00\x01hello world', "arr": bytearray(b'xyz')}`,
+		},
+		{
+			// $ python3 -c 'import pickle; print(pickle.dumps(b"0123456789abcdefghijklmnopqrstuv"))'
+			input: pickled([]byte("\x80\x04\x95$\x00\x00\x00\x00\x00\x00\x00C 0123456789abcdefghijklmnopqrstuv\x94.")),
+			want:  `b'0123456789abcdefghijklmnopqrstuv'`,
+		},
+		{
+			// $ python3 -c 'import pickle; print(pickle.dumps(b"0123456789"*4))'
+			input: pickled([]byte("\x80\x04\x95,\x00\x00\x00\x00\x00\x00\x00C(0123456789012345678901234567890123456789\x94.")),
+			want:  `b'01234567890123456789012345678901'...`,
+		},
+		{
+			// $ python3 -c 'import pickle; print(pickle.dumps([1, frozenset({2}), "a"], protocol=4))'
+			input: pickled([]byte("\x80\x04\x95\x10\x00\x00\x00\x00\x00\x00\x00]\x94(K\x01(K\x02\x91\x94\x8c\x01a\x94e.")),
+			want:  `[1, <unsupported: *types.FrozenSet>, "a"]`,
+		},
 		{
 			input: pickled([]byte("!!!invalid!!!")),
 			want:  "buf.build/stealthrocket/dispatch-proto/dispatch.sdk.python.v1.Pickled(?)",
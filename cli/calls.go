@@ -0,0 +1,369 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+)
+
+// callStore keeps track of the function call hierarchies of a session. It's
+// the FunctionCallObserver of the session, and the TUI, the state server
+// (see --state-addr), the session export (see --export) and the dump of the
+// in-flight calls (see notifyDumpSignal) all read from it.
+type callStore struct {
+	roots        map[DispatchID]struct{}
+	orderedRoots []DispatchID
+	calls        map[DispatchID]functionCall
+
+	// The function call that failed most recently, if any.
+	lastFailed DispatchID
+
+	// Maximum number of function call hierarchies that are retained.
+	// When exceeded, the oldest hierarchies in which all the calls are
+	// done are evicted. If zero, all the hierarchies are retained.
+	maxRoots int
+
+	// Whether the hierarchies that are evicted or cleared are kept, so
+	// that the session export is complete.
+	keepRemoved  bool
+	removedRoots []DispatchID
+	removedCalls map[DispatchID]functionCall
+
+	// Number of attempts past which a function call is highlighted and
+	// a warning is logged. If zero, function calls aren't highlighted.
+	attemptWarning int
+
+	// Incremented when the function calls change, so that views can tell
+	// whether they are up to date.
+	version uint64
+
+	mu sync.Mutex
+}
+
+func (s *callStore) ObserveRequest(now time.Time, req *sdkv1.RunRequest) {
+	// ObserveRequest is part of the FunctionCallObserver interface.
+	// It's called after a request has been received from the Dispatch API,
+	// and before the request has been sent to the local application.
+	if attempt := s.observeRequest(now, req); attempt > 0 {
+		// The TUI may be the log writer, so this is logged after
+		// releasing the lock.
+		slog.Warn("function call exceeded the attempt threshold",
+			"function", req.Function,
+			"dispatch_id", req.DispatchId,
+			"attempt", attempt)
+	}
+}
+
+// observeRequest records the request. It returns the attempt number if the
+// function call exceeded the attempt threshold for the first time, or zero
+// otherwise.
+func (s *callStore) observeRequest(now time.Time, req *sdkv1.RunRequest) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.version++
+
+	if s.roots == nil {
+		s.roots = map[DispatchID]struct{}{}
+	}
+	if s.calls == nil {
+		s.calls = map[DispatchID]functionCall{}
+	}
+
+	rootID := DispatchID(req.RootDispatchId)
+	parentID := DispatchID(req.ParentDispatchId)
+	id := DispatchID(req.DispatchId)
+
+	// Upsert the root.
+	if _, ok := s.roots[rootID]; !ok {
+		s.roots[rootID] = struct{}{}
+		s.orderedRoots = append(s.orderedRoots, rootID)
+		s.evictFinishedRoots()
+	}
+	root, ok := s.calls[rootID]
+	if !ok {
+		root = functionCall{}
+	}
+	s.calls[rootID] = root
+
+	// Upsert the function call.
+	n, ok := s.calls[id]
+	if !ok {
+		n = functionCall{}
+	}
+	n.lastFunction = req.Function
+	n.running = true
+	n.suspended = false
+	if req.CreationTime != nil {
+		n.creationTime = req.CreationTime.AsTime()
+	}
+	if n.creationTime.IsZero() {
+		n.creationTime = now
+	}
+	if req.ExpirationTime != nil {
+		n.expirationTime = req.ExpirationTime.AsTime()
+	}
+	n.timeline = append(n.timeline, &roundtrip{request: runRequest{ts: now, proto: req}})
+	var warnAttempt int
+	if attempt := n.attempt(); !n.attemptWarned && s.exceedsAttemptWarning(attempt) {
+		n.attemptWarned = true
+		warnAttempt = attempt
+	}
+	s.calls[id] = n
+
+	// Upsert the parent and link its child, if applicable.
+	if parentID != "" {
+		parent, ok := s.calls[parentID]
+		if !ok {
+			parent = functionCall{}
+			if parentID != rootID {
+				panic("not implemented")
+			}
+		}
+		if parent.children == nil {
+			parent.children = map[DispatchID]struct{}{}
+		}
+		if _, ok := parent.children[id]; !ok {
+			parent.children[id] = struct{}{}
+			parent.orderedChildren = append(parent.orderedChildren, id)
+		}
+		s.calls[parentID] = parent
+	}
+	return warnAttempt
+}
+
+func (s *callStore) ObserveResponse(now time.Time, req *sdkv1.RunRequest, err error, httpRes *http.Response, res *sdkv1.RunResponse) {
+	// ObserveResponse is part of the FunctionCallObserver interface.
+	// It's called after a response has been received from the local
+	// application, and before the response has been sent to Dispatch.
+	if !s.observeResponse(now, req, err, httpRes, res) {
+		// The request was not observed, e.g. because the response of a
+		// redelivered request raced with the original. The TUI may be
+		// the log writer, so this is logged after releasing the lock.
+		slog.Debug("ignoring response without a matching request", "dispatch_id", req.DispatchId)
+	}
+}
+
+func (s *callStore) observeResponse(now time.Time, req *sdkv1.RunRequest, err error, httpRes *http.Response, res *sdkv1.RunResponse) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := DispatchID(req.DispatchId)
+	n, ok := s.calls[id]
+	if !ok || len(n.timeline) == 0 {
+		return false
+	}
+	s.version++
+
+	rt := n.timeline[len(n.timeline)-1]
+	if !rt.response.ts.IsZero() {
+		// A response was already observed for this request, e.g. because
+		// it was delivered twice. Ignore it so that the counters and the
+		// status of the call aren't updated twice.
+		return true
+	}
+	rt.response.ts = now
+	rt.response.proto = res
+	rt.response.err = err
+	if res == nil && httpRes != nil {
+		rt.response.httpStatus = httpRes.StatusCode
+	}
+
+	n.lastError = nil
+	n.lastStatus = 0
+	n.running = false
+
+	if res != nil {
+		switch res.Status {
+		case sdkv1.Status_STATUS_OK:
+			// noop
+		case sdkv1.Status_STATUS_INCOMPATIBLE_STATE:
+			n = functionCall{
+				lastFunction:     n.lastFunction,
+				tailCalls:        n.tailCalls,
+				previousTimeline: append(n.previousTimeline, n.timeline...),
+			} // reset
+		default:
+			n.failures++
+		}
+
+		switch d := res.Directive.(type) {
+		case *sdkv1.RunResponse_Exit:
+			n.lastStatus = res.Status
+			n.done = terminalStatus(res.Status)
+			if d.Exit.TailCall != nil {
+				n = functionCall{lastFunction: d.Exit.TailCall.Function, tailCalls: append(n.tailCalls, n.lastFunction)} // reset
+			} else if res.Status != sdkv1.Status_STATUS_OK && d.Exit.Result != nil {
+				if e := d.Exit.Result.Error; e != nil && e.Type != "" {
+					if e.Message == "" {
+						n.lastError = fmt.Errorf("%s", e.Type)
+					} else {
+						n.lastError = fmt.Errorf("%s: %s", e.Type, e.Message)
+					}
+				}
+			}
+		case *sdkv1.RunResponse_Poll:
+			n.suspended = true
+			n.polls++
+			n.spawnedCalls += len(d.Poll.Calls)
+		}
+	} else if httpRes != nil {
+		n.failures++
+		n.lastError = fmt.Errorf("unexpected HTTP status code %d", httpRes.StatusCode)
+		n.done = terminalHTTPStatusCode(httpRes.StatusCode)
+	} else if err != nil {
+		n.failures++
+		n.lastError = err
+	}
+
+	if n.done && n.doneTime.IsZero() {
+		n.doneTime = now
+	}
+	if n.done && n.lastStatus != sdkv1.Status_STATUS_OK {
+		s.lastFailed = id
+	}
+
+	s.calls[id] = n
+	return true
+}
+
+// clearFinishedCalls removes the function call hierarchies in which all
+// the calls are done, and returns the number of calls that were removed.
+// Hierarchies with in-flight calls are kept as is.
+func (s *callStore) clearFinishedCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int
+	var orderedRoots []DispatchID
+	for _, rootID := range s.orderedRoots {
+		if !s.hierarchyDone(rootID) {
+			orderedRoots = append(orderedRoots, rootID)
+			continue
+		}
+		removed += s.removeRoot(rootID)
+	}
+	s.orderedRoots = orderedRoots
+
+	if removed > 0 {
+		s.version++
+	}
+	return removed
+}
+
+// evictFinishedRoots removes the oldest function call hierarchies in which
+// all the calls are done, until at most maxRoots hierarchies are retained.
+// Hierarchies with in-flight calls are kept, even if the limit is exceeded.
+func (s *callStore) evictFinishedRoots() {
+	excess := len(s.orderedRoots) - s.maxRoots
+	if s.maxRoots <= 0 || excess <= 0 {
+		return
+	}
+	orderedRoots := s.orderedRoots[:0]
+	for _, rootID := range s.orderedRoots {
+		if excess > 0 && s.hierarchyDone(rootID) {
+			s.removeRoot(rootID)
+			excess--
+			continue
+		}
+		orderedRoots = append(orderedRoots, rootID)
+	}
+	s.orderedRoots = orderedRoots
+}
+
+func (s *callStore) hierarchyDone(id DispatchID) bool {
+	n := s.calls[id]
+	if !n.done {
+		return false
+	}
+	for _, child := range n.orderedChildren {
+		if !s.hierarchyDone(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// removeRoot removes the function call hierarchy, except from orderedRoots,
+// and returns the number of calls that were removed.
+func (s *callStore) removeRoot(rootID DispatchID) int {
+	removed := s.removeHierarchy(rootID)
+	delete(s.roots, rootID)
+	if s.keepRemoved {
+		s.removedRoots = append(s.removedRoots, rootID)
+	}
+	if _, ok := s.calls[s.lastFailed]; !ok {
+		s.lastFailed = ""
+	}
+	return removed
+}
+
+func (s *callStore) removeHierarchy(id DispatchID) int {
+	removed := 1
+	for _, child := range s.calls[id].orderedChildren {
+		removed += s.removeHierarchy(child)
+	}
+	if s.keepRemoved {
+		if s.removedCalls == nil {
+			s.removedCalls = map[DispatchID]functionCall{}
+		}
+		s.removedCalls[id] = s.calls[id]
+	}
+	delete(s.calls, id)
+	return removed
+}
+
+// outstandingCalls returns the number of calls made by the function call
+// that haven't completed yet. The calls aren't identified until they're
+// observed as children of the function call, so this is the number of calls
+// spawned minus the number of children that are done.
+func (s *callStore) outstandingCalls(n functionCall) int {
+	outstanding := n.spawnedCalls
+	for _, id := range n.orderedChildren {
+		if s.calls[id].done {
+			outstanding--
+		}
+	}
+	return max(0, outstanding)
+}
+
+// exceedsAttemptWarning returns true if the attempt exceeds the threshold
+// past which function calls are highlighted.
+func (s *callStore) exceedsAttemptWarning(attempt int) bool {
+	return s.attemptWarning > 0 && attempt > s.attemptWarning
+}
+
+// count returns the number of function calls.
+func (s *callStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.calls)
+}
+
+// contains returns true if the function call hasn't been removed.
+func (s *callStore) contains(id DispatchID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.calls[id]
+	return ok
+}
+
+func (s *callStore) running(id DispatchID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[id].running
+}
+
+func (s *callStore) lastFailedCall() (DispatchID, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastFailed, s.lastFailed != ""
+}
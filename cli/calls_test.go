@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallStoreDuplicateResponse(t *testing.T) {
+	now := time.Now()
+
+	calls := &callStore{}
+
+	req := &sdkv1.RunRequest{
+		Function:       "my_function",
+		DispatchId:     "1",
+		RootDispatchId: "1",
+	}
+	failed := &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_TEMPORARY_ERROR,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	}
+	poll := &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Poll{Poll: &sdkv1.Poll{}},
+	}
+
+	calls.ObserveRequest(now, req)
+	calls.ObserveResponse(now, req, nil, nil, failed)
+	calls.ObserveResponse(now, req, nil, nil, failed)
+
+	calls.ObserveRequest(now, req)
+	calls.ObserveResponse(now, req, nil, nil, poll)
+	calls.ObserveResponse(now, req, nil, nil, poll)
+
+	n := calls.calls["1"]
+	assert.Equal(t, 1, n.failures)
+	assert.Equal(t, 1, n.polls)
+	assert.Len(t, n.timeline, 2)
+	assert.True(t, n.suspended)
+
+	// A response without a matching request is ignored.
+	calls.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "2"}, nil, nil, poll)
+	assert.NotContains(t, calls.calls, DispatchID("2"))
+}
+
+func TestCallStoreMaxRoots(t *testing.T) {
+	now := time.Now()
+
+	calls := &callStore{maxRoots: 2}
+
+	exit := &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	}
+
+	// The oldest hierarchy is still running, so it's kept.
+	running := &sdkv1.RunRequest{Function: "running", DispatchId: "1", RootDispatchId: "1"}
+	calls.ObserveRequest(now, running)
+
+	done := &sdkv1.RunRequest{Function: "done", DispatchId: "2", RootDispatchId: "2"}
+	doneChild := &sdkv1.RunRequest{Function: "done_child", DispatchId: "3", RootDispatchId: "2", ParentDispatchId: "2"}
+	calls.ObserveRequest(now, done)
+	calls.ObserveRequest(now, doneChild)
+	calls.ObserveResponse(now, doneChild, nil, nil, exit)
+	calls.ObserveResponse(now, done, nil, nil, exit)
+
+	other := &sdkv1.RunRequest{Function: "other", DispatchId: "4", RootDispatchId: "4"}
+	calls.ObserveRequest(now, other)
+
+	assert.Equal(t, []DispatchID{"1", "4"}, calls.orderedRoots)
+	assert.Equal(t, map[DispatchID]struct{}{"1": {}, "4": {}}, calls.roots)
+	assert.Len(t, calls.calls, 2)
+
+	// The limit can be exceeded when all the hierarchies are running.
+	another := &sdkv1.RunRequest{Function: "another", DispatchId: "5", RootDispatchId: "5"}
+	calls.ObserveRequest(now, another)
+	assert.Equal(t, []DispatchID{"1", "4", "5"}, calls.orderedRoots)
+}
+
+func TestCallStoreOrphanResponse(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the default logger!
+	now := time.Now()
+
+	calls := &callStore{}
+	tui := &TUI{store: calls}
+
+	// The TUI is the log writer when it's displayed, so logging the orphan
+	// response must not deadlock.
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(tui, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	res := &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	}
+
+	// Unknown dispatch ID.
+	assert.NotPanics(t, func() {
+		calls.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "1"}, nil, nil, res)
+	})
+	assert.NotContains(t, calls.calls, DispatchID("1"))
+
+	// Known dispatch ID with an empty timeline, e.g. a root that was only
+	// created as the parent of an observed child call.
+	calls.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "3", RootDispatchId: "2", ParentDispatchId: "2", Function: "child"})
+	assert.NotPanics(t, func() {
+		calls.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "2"}, nil, nil, res)
+	})
+	assert.Empty(t, calls.calls["2"].timeline)
+	assert.False(t, calls.calls["2"].done)
+
+	assert.Equal(t, 2, strings.Count(tui.logs.String(), "ignoring response without a matching request"))
+}
+
+func TestCallStoreObserverTimestamps(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	calls := &callStore{}
+	var observer FunctionCallObserver = calls
+
+	req := &sdkv1.RunRequest{Function: "my_function", DispatchId: "1", RootDispatchId: "1"}
+	observer.ObserveRequest(start, req)
+	observer.ObserveResponse(start.Add(1500*time.Millisecond), req, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+
+	n := calls.calls["1"]
+	assert.Equal(t, start, n.creationTime)
+	assert.Equal(t, start.Add(1500*time.Millisecond), n.doneTime)
+	assert.Equal(t, start, n.timeline[0].request.ts)
+	assert.Equal(t, start.Add(1500*time.Millisecond), n.timeline[0].response.ts)
+	assert.Equal(t, 1500*time.Millisecond, n.duration(start.Add(time.Hour)))
+}
+
+func TestCallStoreKeepRemoved(t *testing.T) {
+	now := time.Now()
+
+	calls := &callStore{maxRoots: 1, keepRemoved: true}
+
+	exit := &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	}
+
+	// The first hierarchy is evicted when the second one is observed.
+	parent := &sdkv1.RunRequest{Function: "parent", DispatchId: "1", RootDispatchId: "1"}
+	child := &sdkv1.RunRequest{Function: "child", DispatchId: "2", RootDispatchId: "1", ParentDispatchId: "1"}
+	calls.ObserveRequest(now, parent)
+	calls.ObserveRequest(now, child)
+	calls.ObserveResponse(now, child, nil, nil, exit)
+	calls.ObserveResponse(now, parent, nil, nil, exit)
+
+	other := &sdkv1.RunRequest{Function: "other", DispatchId: "3", RootDispatchId: "3"}
+	calls.ObserveRequest(now, other)
+	calls.ObserveResponse(now, other, nil, nil, exit)
+	assert.Equal(t, []DispatchID{"3"}, calls.orderedRoots)
+
+	// The second hierarchy is cleared.
+	assert.Equal(t, 1, calls.clearFinishedCalls())
+	assert.Empty(t, calls.orderedRoots)
+	assert.Empty(t, calls.calls)
+
+	// The removed hierarchies are still exported.
+	export, err := calls.export("session", now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []DispatchID{"1", "3"}, export.Roots)
+	assert.Len(t, export.Calls, 3)
+	assert.Equal(t, []DispatchID{"2"}, export.Calls["1"].Children)
+}
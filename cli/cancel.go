@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errCallCanceled is the cause of the cancellation of the function calls
+// that are canceled from the TUI.
+var errCallCanceled = errors.New("function call was canceled")
+
+// callCanceler tracks the function calls that are in flight, so that they
+// can be canceled from the TUI. Canceling a function call aborts the
+// request to the local application. The request is then cleaned up like
+// other requests that the local application could not respond to.
+type callCanceler struct {
+	mu    sync.Mutex
+	calls map[DispatchID]*context.CancelCauseFunc
+}
+
+// inFlightCalls are the function calls that are in flight in the session.
+var inFlightCalls callCanceler
+
+// track registers the function for canceling the function call. The
+// returned function must be called once the function call is done.
+func (c *callCanceler) track(id DispatchID, cancel context.CancelCauseFunc) (untrack func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.calls == nil {
+		c.calls = map[DispatchID]*context.CancelCauseFunc{}
+	}
+	entry := &cancel
+	c.calls[id] = entry
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		// The function call may have been redelivered in the meantime,
+		// in which case the entry belongs to the other request.
+		if c.calls[id] == entry {
+			delete(c.calls, id)
+		}
+	}
+}
+
+// cancel cancels the function call. It returns false if the function call
+// isn't in flight.
+func (c *callCanceler) cancel(id DispatchID) bool {
+	c.mu.Lock()
+	entry, ok := c.calls[id]
+	c.mu.Unlock()
+
+	if ok {
+		(*entry)(errCallCanceled)
+	}
+	return ok
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCancelFunctionCall(t *testing.T) {
+	// The local application doesn't respond until the request is aborted.
+	unblock := make(chan struct{})
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-unblock:
+		}
+	}))
+	defer endpoint.Close()
+	defer close(unblock)
+
+	deleted := make(chan string, 1)
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			deleted <- r.Header.Get("X-Request-ID")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer bridge.Close()
+
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	localEndpoint := LocalEndpoint
+	t.Cleanup(func() { LocalEndpoint = localEndpoint })
+	LocalEndpoint = endpoint.Listener.Addr().String()
+
+	b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: "1", RootDispatchId: "1"})
+	req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+	var body bytes.Buffer
+	req.Write(&body)
+	res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+
+	calls := &callStore{}
+	tui := &TUI{store: calls, cancelCall: inFlightCalls.cancel}
+	tui.Init()
+
+	cleaner := newRequestCleaner(http.DefaultClient, bridge.URL, 5*time.Second, 1)
+	defer cleaner.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		handleRequest(context.Background(), http.DefaultClient, bridge.URL, "request-1", res, calls, cleaner)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return calls.running("1")
+	}, 5*time.Second, time.Millisecond)
+
+	// Cancel the selected function call from the detail tab.
+	id := DispatchID("1")
+	tui.selected = &id
+	tui.activeTab = detailTab
+	tui.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	assert.Equal(t, "Canceled the function call", tui.flashMessage)
+
+	select {
+	case requestID := <-deleted:
+		assert.Equal(t, "request-1", requestID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cleaned up")
+	}
+	<-done
+
+	n := calls.calls["1"]
+	assert.False(t, n.running)
+	assert.ErrorIs(t, n.lastError, errCallCanceled)
+
+	// The function call can't be canceled once it's no longer running.
+	tui.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	assert.Equal(t, "The function call isn't running", tui.flashMessage)
+	assert.False(t, inFlightCalls.cancel("1"))
+}
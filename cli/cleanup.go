@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// requestCleaner deletes the requests that the local application could
+// not respond to, so that Dispatch doesn't wait for a response that will
+// never come.
+//
+// At most concurrency requests are deleted at a time, and each deletion
+// is bounded by the timeout. Pending and in-flight deletions are aborted
+// when the cleaner is canceled, so that shutdown doesn't hang on them.
+type requestCleaner struct {
+	client  *http.Client
+	url     string
+	timeout time.Duration
+	sem     chan struct{}
+
+	// forget is called before deleting a request, since Dispatch then
+	// delivers it again, e.g. to stop skipping it as a duplicate.
+	forget func(requestID string)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newRequestCleaner(client *http.Client, url string, timeout time.Duration, concurrency int) *requestCleaner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &requestCleaner{
+		client:  client,
+		url:     url,
+		timeout: timeout,
+		sem:     make(chan struct{}, concurrency),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// cleanup deletes the request, blocking until it's done, has timed out or
+// the cleaner has been canceled.
+func (c *requestCleaner) cleanup(requestID string) error {
+	if c.forget != nil {
+		c.forget(requestID)
+	}
+
+	select {
+	case c.sem <- struct{}{}:
+		defer func() { <-c.sem }()
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	return deleteRequest(ctx, c.client, c.url, requestID)
+}
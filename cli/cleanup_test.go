@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestCleaner(t *testing.T) {
+	var active, maxActive int64
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&active, 1)
+		defer atomic.AddInt64(&active, -1)
+		for {
+			m := atomic.LoadInt64(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt64(&maxActive, m, n) {
+				break
+			}
+		}
+		// Hang until the client gives up.
+		<-r.Context().Done()
+	}))
+	defer bridge.Close()
+
+	t.Run("Cleanup times out", func(t *testing.T) {
+		cleaner := newRequestCleaner(http.DefaultClient, bridge.URL, 50*time.Millisecond, 1)
+		defer cleaner.cancel()
+
+		start := time.Now()
+		assert.Error(t, cleaner.cleanup("request"))
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("Abandoned requests are canceled at the deadline", func(t *testing.T) {
+		cleaner := newRequestCleaner(http.DefaultClient, bridge.URL, time.Minute, 2)
+		defer cleaner.cancel()
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Error(t, cleaner.cleanup("request"))
+			}()
+		}
+
+		deadline := time.AfterFunc(100*time.Millisecond, cleaner.cancel)
+		defer deadline.Stop()
+		wg.Wait()
+
+		assert.Less(t, time.Since(start), 2*time.Second)
+		assert.LessOrEqual(t, atomic.LoadInt64(&maxActive), int64(2))
+	})
+}
@@ -2,16 +2,21 @@ package cli
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
 
 	"github.com/joho/godotenv"
 	"github.com/pelletier/go-toml/v2"
-	"golang.org/x/term"
 )
 
 var (
@@ -20,13 +25,18 @@ var (
 	DispatchApiKeyLocation string
 
 	DispatchApiUrl           string
+	DispatchApiUrlCli        string
 	DispatchBridgeUrl        string
+	DispatchBridgeUrlCli     string
 	DispatchBridgeHostHeader string
 	DispatchConsoleUrl       string
+	DispatchConsoleUrlCli    string
 
 	DispatchConfigPath string
+	DispatchProfileCli string
 
 	DotEnvFilePath string
+	DotEnvStrict   bool
 )
 
 func init() {
@@ -49,6 +59,19 @@ func setVariables() {
 		DispatchConsoleUrl = "https://console.dispatch.run"
 	}
 
+	// URLs passed on the command line take precedence over the
+	// environment. The flags are only parsed after init() has run,
+	// so this is re-applied when the command is executed.
+	if DispatchApiUrlCli != "" {
+		DispatchApiUrl = DispatchApiUrlCli
+	}
+	if DispatchBridgeUrlCli != "" {
+		DispatchBridgeUrl = DispatchBridgeUrlCli
+	}
+	if DispatchConsoleUrlCli != "" {
+		DispatchConsoleUrl = DispatchConsoleUrlCli
+	}
+
 	if configPath := os.Getenv("DISPATCH_CONFIG_PATH"); configPath != "" {
 		DispatchConfigPath = configPath
 	} else {
@@ -61,23 +84,75 @@ func setVariables() {
 	}
 }
 
-func isTerminal(f *os.File) bool {
-	return term.IsTerminal(int(f.Fd()))
-}
-
 type Config struct {
 	// Warning is printed as a comment at the beginning of the configuration file.
 	Warning string `toml:",commented"`
 
+	// Version is the version of the configuration file format. Files
+	// written before the field was introduced have version 0.
+	Version int `toml:"version"`
+
 	// Active is the active organization.
 	Active string `toml:"active,omitempty"`
 
 	// Organization is the set of organizations and their API keys.
 	Organization map[string]Organization `toml:"Organizations"`
+
+	// ActiveProfile is the profile used when none is selected with the
+	// --profile flag or the DISPATCH_PROFILE environment variable.
+	ActiveProfile string `toml:"active_profile,omitempty"`
+
+	// Profiles is the set of named environments, e.g. production and
+	// staging, that can be selected instead of the active organization.
+	Profiles map[string]Profile `toml:"Profiles,omitempty"`
+}
+
+// Profile bundles an organization with the URLs of the Dispatch services.
+// URLs set in the profile take precedence over the organization's.
+type Profile struct {
+	Organization string `toml:"organization"`
+	APIURL       string `toml:"api_url,omitempty"`
+	BridgeURL    string `toml:"bridge_url,omitempty"`
+	ConsoleURL   string `toml:"console_url,omitempty"`
 }
 
 type Organization struct {
 	APIKey string `toml:"api_key"`
+
+	// Optional URLs of the Dispatch services used by the organization,
+	// e.g. when self-hosting. The defaults are used when they are empty.
+	APIURL     string `toml:"api_url,omitempty"`
+	BridgeURL  string `toml:"bridge_url,omitempty"`
+	ConsoleURL string `toml:"console_url,omitempty"`
+}
+
+// apply returns the organization with the URLs overridden by the profile.
+func (p Profile) apply(org Organization) Organization {
+	if p.APIURL != "" {
+		org.APIURL = p.APIURL
+	}
+	if p.BridgeURL != "" {
+		org.BridgeURL = p.BridgeURL
+	}
+	if p.ConsoleURL != "" {
+		org.ConsoleURL = p.ConsoleURL
+	}
+	return org
+}
+
+// applyOrganizationUrls replaces the default URLs with the ones configured
+// for the organization. URLs set in the environment or passed on the
+// command line take precedence.
+func applyOrganizationUrls(org Organization) {
+	if org.APIURL != "" && os.Getenv("DISPATCH_API_URL") == "" && DispatchApiUrlCli == "" {
+		DispatchApiUrl = org.APIURL
+	}
+	if org.BridgeURL != "" && os.Getenv("DISPATCH_BRIDGE_URL") == "" && DispatchBridgeUrlCli == "" {
+		DispatchBridgeUrl = org.BridgeURL
+	}
+	if org.ConsoleURL != "" && os.Getenv("DISPATCH_CONSOLE_URL") == "" && DispatchConsoleUrlCli == "" {
+		DispatchConsoleUrl = org.ConsoleURL
+	}
 }
 
 func CreateConfig(path string, config *Config) error {
@@ -85,27 +160,124 @@ func CreateConfig(path string, config *Config) error {
 	if err := os.MkdirAll(pathdir, 0755); err != nil {
 		return fmt.Errorf("failed to create config directory %v: %w", pathdir, err)
 	}
-	fh, err := os.Create(path)
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return writeConfig(w, config)
+	})
+}
+
+// writeFileAtomic writes a file by writing to a temporary file in the same
+// directory and renaming it into place, so that the file at path is never
+// left partially written. The file is only readable by the current user.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	fh, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
 	if err != nil {
-		return fmt.Errorf("failed to create config file %v: %w", path, err)
+		return fmt.Errorf("failed to create %v: %w", path, err)
 	}
-	defer fh.Close()
-	return writeConfig(fh, config)
+	defer os.Remove(fh.Name()) // no-op after the rename
+
+	if err := fh.Chmod(0600); err != nil {
+		fh.Close()
+		return fmt.Errorf("failed to create %v: %w", path, err)
+	}
+	if err := write(fh); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		return fmt.Errorf("failed to write %v: %w", path, err)
+	}
+	if err := fh.Close(); err != nil {
+		return fmt.Errorf("failed to write %v: %w", path, err)
+	}
+	if err := os.Rename(fh.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %v: %w", path, err)
+	}
+	return nil
 }
 
 func writeConfig(w io.Writer, config *Config) error {
+	config.Version = configVersion
 	e := toml.NewEncoder(w)
 	return e.Encode(config)
 }
 
+// configVersion is the current version of the configuration file format.
+const configVersion = 1
+
+// configMigrations upgrade configurations from one version of the format to
+// the next; configMigrations[i] upgrades a configuration from version i.
+var configMigrations = []func(*Config){
+	// Version 0 has the same layout as version 1, which only adds the
+	// version field.
+	func(c *Config) {},
+}
+
 // TODO: validate configuration to ensure only one organization is active.
 func LoadConfig(path string) (*Config, error) {
 	fh, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	defer fh.Close()
-	return loadConfig(bufio.NewReader(fh))
+	if info, err := fh.Stat(); err == nil {
+		checkConfigPermissions(path, info.Mode())
+	}
+	config, err := loadConfig(bufio.NewReader(fh))
+	fh.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.Version > configVersion:
+		return nil, fmt.Errorf("configuration file version %d is not supported by this version of the CLI (latest: %d). Please upgrade the CLI", config.Version, configVersion)
+	case config.Version < configVersion:
+		from := config.Version
+		migrateConfig(config)
+		saveMigratedConfig(path, config, from)
+	}
+	return config, nil
+}
+
+// warnedConfigPaths records the configuration files that have already been
+// reported as having loose permissions, so the warning is only logged once.
+var warnedConfigPaths sync.Map
+
+// checkConfigPermissions warns when the configuration file, which contains
+// API keys, can be accessed by other users.
+func checkConfigPermissions(path string, mode fs.FileMode) {
+	if runtime.GOOS == "windows" || mode.Perm()&0077 == 0 {
+		return
+	}
+	if _, warned := warnedConfigPaths.LoadOrStore(path, true); warned {
+		return
+	}
+	slog.Warn("configuration file is accessible by other users, run `chmod 600` on it to protect the API keys it contains", "path", path, "mode", fmt.Sprintf("%#o", mode.Perm()))
+}
+
+// saveMigratedConfig rewrites the configuration file after it was migrated.
+// A configuration that cannot be rewritten, e.g. because it is read-only or
+// locked by another process, is still usable; it's migrated again next time.
+func saveMigratedConfig(path string, config *Config, from int) {
+	unlock, err := lockConfig(path, 0)
+	if err != nil {
+		slog.Debug("not saving migrated configuration", "path", path, "error", err)
+		return
+	}
+	defer unlock()
+
+	if err := CreateConfig(path, config); err != nil {
+		slog.Warn("failed to save migrated configuration", "path", path, "error", err)
+		return
+	}
+	slog.Info("migrated configuration file", "path", path, "from", from, "to", configVersion)
+}
+
+func migrateConfig(config *Config) {
+	for config.Version < configVersion {
+		configMigrations[config.Version](config)
+		config.Version++
+	}
 }
 
 func loadConfig(r io.Reader) (*Config, error) {
@@ -117,18 +289,47 @@ func loadConfig(r io.Reader) (*Config, error) {
 	return &c, nil
 }
 
+// errEmptyAPIKey is returned when --api-key is passed an empty value.
+var errEmptyAPIKey = errors.New("--api-key is empty: pass a Dispatch API key, or omit --api-key to use DISPATCH_API_KEY or the configuration")
+
 func runConfigFlow() error {
 	config, err := LoadConfig(DispatchConfigPath)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("failed to load configuration from %s: %v", DispatchConfigPath, err)
+			return configInvalidError{path: DispatchConfigPath, err: err}
 		}
 	}
 
-	if config != nil && config.Active != "" {
-		org, ok := config.Organization[config.Active]
+	profileName := DispatchProfileCli
+	if profileName == "" {
+		profileName = os.Getenv("DISPATCH_PROFILE")
+	}
+	if profileName == "" && config != nil {
+		profileName = config.ActiveProfile
+	}
+
+	var org Organization
+	if profileName != "" {
+		var profile Profile
+		var ok bool
+		if config != nil {
+			profile, ok = config.Profiles[profileName]
+		}
 		if !ok {
-			return fmt.Errorf("invalid active organization '%s' found in configuration. Please run `dispatch login` or `dispatch switch`", config.Active)
+			return profileNotFoundError{profile: profileName}
+		}
+		org, ok = config.Organization[profile.Organization]
+		if !ok {
+			return organizationInvalidError{organization: profile.Organization, profile: profileName}
+		}
+		org = profile.apply(org)
+		DispatchApiKey = org.APIKey
+		DispatchApiKeyLocation = "config"
+	} else if config != nil && config.Active != "" {
+		var ok bool
+		org, ok = config.Organization[config.Active]
+		if !ok {
+			return organizationInvalidError{organization: config.Active}
 		}
 		DispatchApiKey = org.APIKey
 		DispatchApiKeyLocation = "config"
@@ -144,26 +345,212 @@ func runConfigFlow() error {
 		DispatchApiKeyLocation = "cli"
 	}
 
+	// The URLs of the active organization only apply when its API key is
+	// used; a key from the environment or the command line may belong to
+	// an organization hosted elsewhere.
+	if DispatchApiKeyLocation == "config" {
+		applyOrganizationUrls(org)
+	}
+
 	if DispatchApiKey == "" {
-		if config != nil && len(config.Organization) > 0 {
-			return fmt.Errorf("No organization selected. Please run `dispatch switch` to select one.")
-		}
-		return fmt.Errorf("Please run `dispatch login` to login to Dispatch. Alternatively, set the DISPATCH_API_KEY environment variable, or provide an --api-key (-k) on the command line.")
+		return configMissingError{hasOrganizations: config != nil && len(config.Organization) > 0}
 	}
 	return nil
 }
 
-func loadEnvFromFile(path string) error {
+func loadEnvFromFile(path string, strict bool) error {
 	if path != "" {
 		absolutePath, err := filepath.Abs(path)
 		if err != nil {
 			return fmt.Errorf("failed to get absolute path for %s: %v", path, err)
 		}
-		if err := godotenv.Load(path); err != nil {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return fmt.Errorf("env file path %s is a directory, not a file", absolutePath)
+		}
+		env, err := readEnvFile(path, strict)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				return fmt.Errorf("permission denied reading env file %s; check the file permissions", absolutePath)
+			}
 			return fmt.Errorf("failed to load env file from %s: %v", absolutePath, err)
 		}
+		// Variables already set in the environment take precedence
+		// over the values from the file.
+		for k, v := range env {
+			if _, ok := os.LookupEnv(k); !ok {
+				os.Setenv(k, v)
+			}
+		}
 		slog.Info("loading environment variables from file", "path", absolutePath)
 	}
 	setVariables()
 	return nil
 }
+
+// readEnvFile parses the env file at path. References to other variables,
+// e.g. ${HOST} or $PORT, are expanded against the current environment and
+// the variables defined earlier in the file. Since variables set in the
+// environment take precedence over the file (see loadEnvFromFile), they
+// also do in references, and the value returned for them is the one from
+// the environment. References to undefined variables expand to an empty
+// string, and \$ escapes a literal dollar.
+//
+// Malformed lines are skipped, unless strict is true in which case the
+// first malformed line is returned as an error.
+func readEnvFile(path string, strict bool) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err = cleanEnvFile(b, strict, nil)
+	if err != nil {
+		return nil, err
+	}
+	file, err := godotenv.UnmarshalBytes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// godotenv only expands variables defined in the file itself, so the
+	// environment is prepended to the file before parsing it again. The
+	// assignments of the variables from the environment are removed from
+	// the file, so that they don't override them.
+	var src bytes.Buffer
+	fromEnv := map[string]bool{}
+	for _, kv := range os.Environ() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !envVarName.MatchString(k) {
+			continue
+		}
+		if q, ok := quoteEnvValue(v); ok {
+			fmt.Fprintf(&src, "%s=%s\n", k, q)
+			fromEnv[k] = true
+		}
+	}
+	b, err = cleanEnvFile(b, false, func(k string) bool { return fromEnv[k] })
+	if err != nil {
+		return nil, err
+	}
+	src.Write(b)
+
+	expanded, err := godotenv.UnmarshalBytes(src.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string, len(file))
+	for k := range file {
+		env[k] = expanded[k]
+	}
+	return env, nil
+}
+
+// envVarName matches the variable names that godotenv expands.
+var envVarName = regexp.MustCompile(`^[A-Z0-9_]+$`)
+
+// quoteEnvValue quotes v so that godotenv parses it back verbatim. It
+// returns false for the few values that godotenv cannot represent, such as
+// values wrapped in quotes or ending with a backslash.
+func quoteEnvValue(v string) (string, bool) {
+	if strings.HasSuffix(v, `\`) {
+		return "", false
+	}
+	if !strings.Contains(v, "'") {
+		return "'" + v + "'", true
+	}
+	if strings.HasPrefix(v, `"`) || strings.HasSuffix(v, `"`) {
+		return "", false
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(v) + `"`, true
+}
+
+var (
+	// envAssignment matches the beginning of a KEY=value (or KEY: value)
+	// statement, after the optional export prefix has been removed.
+	envAssignment = regexp.MustCompile(`^[\pL\pN_.]+[ \t]*[=:][ \t]*`)
+
+	// envKey matches the key of a KEY=value (or KEY: value) statement,
+	// see envAssignment.
+	envKey = regexp.MustCompile(`^[\pL\pN_.]+`)
+
+	// envExport matches shell statements that export a variable without
+	// assigning it, e.g. "export FOO".
+	envExport = regexp.MustCompile(`^export[ \t]+[\pL\pN_.]+$`)
+)
+
+// cleanEnvFile blanks out the lines of an env file that godotenv cannot
+// parse, so that a single malformed line does not prevent the rest of the
+// file from being loaded. Line numbers are preserved.
+//
+// If skip is not nil, the assignments of the variables for which it returns
+// true are blanked out as well.
+func cleanEnvFile(src []byte, strict bool, skip func(key string) bool) ([]byte, error) {
+	lines := strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if envExport.MatchString(line) {
+			// Exporting a variable has no effect on the file.
+			lines[i] = ""
+			continue
+		}
+
+		stmt := line
+		if rest, ok := strings.CutPrefix(stmt, "export"); ok && rest != strings.TrimLeft(rest, " \t") {
+			stmt = strings.TrimLeft(rest, " \t")
+		}
+
+		end := i
+		valid := false
+		if loc := envAssignment.FindStringIndex(stmt); loc != nil {
+			value := stmt[loc[1]:]
+			if value == "" || (value[0] != '"' && value[0] != '\'') {
+				valid = true
+			} else {
+				// Quoted values may span multiple lines.
+				rest := value[1:]
+				for {
+					if j := closingQuote(rest, value[0]); j >= 0 {
+						after := strings.TrimSpace(rest[j+1:])
+						valid = after == "" || strings.HasPrefix(after, "#")
+						break
+					}
+					if end++; end == len(lines) {
+						break
+					}
+					rest = lines[end]
+				}
+			}
+		}
+
+		if valid {
+			if skip != nil && skip(envKey.FindString(stmt)) {
+				for j := i; j <= end; j++ {
+					lines[j] = ""
+				}
+			}
+			i = end
+			continue
+		}
+		if strict {
+			return nil, fmt.Errorf("malformed line %d: %s", i+1, line)
+		}
+		slog.Warn("skipping malformed line in env file", "line", i+1)
+		lines[i] = ""
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// closingQuote returns the index of the first unescaped quote in s, or -1.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == quote && (i == 0 || s[i-1] != '\\') {
+			return i
+		}
+	}
+	return -1
+}
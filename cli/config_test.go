@@ -0,0 +1,465 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLFlags(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the environment!
+	t.Setenv("DISPATCH_API_URL", "https://api.example.com")
+	t.Setenv("DISPATCH_BRIDGE_URL", "https://bridge.example.com")
+	t.Setenv("DISPATCH_CONSOLE_URL", "https://console.example.com")
+	t.Cleanup(func() {
+		DispatchApiUrlCli = ""
+		DispatchBridgeUrlCli = ""
+		DispatchConsoleUrlCli = ""
+		setVariables()
+	})
+
+	t.Run("Environment variables", func(t *testing.T) {
+		cmd := createMainCommand()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs([]string{"version"})
+
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("Received unexpected error: %v", err)
+		}
+
+		assert.Equal(t, "https://api.example.com", DispatchApiUrl)
+		assert.Equal(t, "https://bridge.example.com", DispatchBridgeUrl)
+		assert.Equal(t, "https://console.example.com", DispatchConsoleUrl)
+	})
+
+	t.Run("Flags have priority over environment variables", func(t *testing.T) {
+		cmd := createMainCommand()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs([]string{
+			"--api-url", "http://localhost:4001",
+			"--bridge-url", "http://localhost:4002",
+			"--console-url", "http://localhost:4003",
+			"version",
+		})
+
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("Received unexpected error: %v", err)
+		}
+
+		assert.Equal(t, "http://localhost:4001", DispatchApiUrl)
+		assert.Equal(t, "http://localhost:4002", DispatchBridgeUrl)
+		assert.Equal(t, "http://localhost:4003", DispatchConsoleUrl)
+	})
+}
+
+func TestReadEnvFile(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the environment!
+	t.Setenv("HOST", "example.com")
+	t.Setenv("QUOTED", `it's "quoted" $HOME`)
+
+	tcs := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "Expand variables from the environment",
+			input: "URL=http://${HOST}:$PORT\n",
+			want:  map[string]string{"URL": "http://example.com:"},
+		},
+		{
+			name:  "Expand variables from the file",
+			input: "PORT=8000\nURL=\"http://${HOST}:${PORT}\"\n",
+			want:  map[string]string{"PORT": "8000", "URL": "http://example.com:8000"},
+		},
+		{
+			name:  "Variables from the environment take precedence",
+			input: "HOST=localhost\nURL=http://${HOST}\n",
+			want:  map[string]string{"HOST": "example.com", "URL": "http://example.com"},
+		},
+		{
+			name:  "Multi-line values overridden by the environment",
+			input: "export HOST=\"local\nhost\"\nURL=http://${HOST}\n",
+			want:  map[string]string{"HOST": "example.com", "URL": "http://example.com"},
+		},
+		{
+			name:  "Expand values verbatim",
+			input: "VALUE=${QUOTED}\n",
+			want:  map[string]string{"VALUE": `it's "quoted" $HOME`},
+		},
+		{
+			name:  "Escaped dollar",
+			input: "PRICE=\\$HOST\n",
+			want:  map[string]string{"PRICE": "$HOST"},
+		},
+		{
+			name:  "Undefined variables",
+			input: "VALUE=a${UNDEFINED_VARIABLE}b\n",
+			want:  map[string]string{"VALUE": "ab"},
+		},
+		{
+			name:  "Single quotes",
+			input: "VALUE='${HOST}'\n",
+			want:  map[string]string{"VALUE": "${HOST}"},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(path, []byte(tc.input), 0600); err != nil {
+				t.Fatal(err)
+			}
+			env, err := readEnvFile(path, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.want, env)
+		})
+	}
+}
+
+func TestLoadEnvFromFile(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the environment!
+	t.Setenv("HOST", "example.com")
+	t.Setenv("DISPATCH_API_URL", "https://api.example.com")
+	for _, k := range []string{"PORT", "TEST_DISPATCH_URL"} {
+		t.Setenv(k, "") // restores the variable when the test ends
+		os.Unsetenv(k)
+	}
+	t.Cleanup(setVariables)
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("DISPATCH_API_URL=http://localhost\nPORT=8000\nTEST_DISPATCH_URL=http://${HOST}:${PORT}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadEnvFromFile(path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment takes precedence over the file.
+	assert.Equal(t, "https://api.example.com", DispatchApiUrl)
+	assert.Equal(t, "http://example.com:8000", os.Getenv("TEST_DISPATCH_URL"))
+}
+
+func TestLoadEnvFromFileErrors(t *testing.T) {
+	t.Run("Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		err := loadEnvFromFile(dir, false)
+		assert.EqualError(t, err, "env file path "+dir+" is a directory, not a file")
+	})
+
+	t.Run("Permission denied", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes are not enforced on Windows")
+		}
+		if os.Geteuid() == 0 {
+			t.Skip("file modes are not enforced for root")
+		}
+		path := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(path, []byte("CHARACTER=rick_sanchez"), 0); err != nil {
+			t.Fatal(err)
+		}
+		err := loadEnvFromFile(path, false)
+		assert.EqualError(t, err, "permission denied reading env file "+path+"; check the file permissions")
+	})
+}
+
+func TestReadEnvFileSyntax(t *testing.T) {
+	const input = `# Copied from a shell script
+export CHARACTER=rick_sanchez
+export	QUOTED="Wubba Lubba" # inline comment
+NAME=morty # inline comment
+HASH=a#b
+MULTILINE="first
+second"
+export CHARACTER
+this is not valid
+LAST=1
+`
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(input), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Non-strict mode skips malformed lines", func(t *testing.T) {
+		env, err := readEnvFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, map[string]string{
+			"CHARACTER": "rick_sanchez",
+			"QUOTED":    "Wubba Lubba",
+			"NAME":      "morty",
+			"HASH":      "a#b",
+			"MULTILINE": "first\nsecond",
+			"LAST":      "1",
+		}, env)
+	})
+
+	t.Run("Strict mode fails on malformed lines", func(t *testing.T) {
+		_, err := readEnvFile(path, true)
+		assert.EqualError(t, err, "malformed line 9: this is not valid")
+	})
+
+	t.Run("Strict mode fails on unterminated quotes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(path, []byte("A=1\nB=\"unterminated\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readEnvFile(path, true)
+		assert.EqualError(t, err, `malformed line 2: B="unterminated`)
+
+		env, err := readEnvFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, map[string]string{"A": "1"}, env)
+	})
+}
+
+func TestOrganizationUrls(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the environment!
+	for _, k := range []string{"DISPATCH_API_KEY", "DISPATCH_API_URL", "DISPATCH_BRIDGE_URL", "DISPATCH_CONSOLE_URL"} {
+		t.Setenv(k, "")
+	}
+	configPath, apiKey, location := DispatchConfigPath, DispatchApiKey, DispatchApiKeyLocation
+	t.Cleanup(func() {
+		DispatchConfigPath, DispatchApiKey, DispatchApiKeyLocation = configPath, apiKey, location
+		DispatchApiKeyCli = ""
+		DispatchBridgeUrlCli = ""
+		setVariables()
+	})
+
+	// setVariables resets the configuration path from the environment.
+	t.Setenv("DISPATCH_CONFIG_PATH", filepath.Join(t.TempDir(), "config.toml"))
+	setVariables()
+	if err := CreateConfig(DispatchConfigPath, &Config{
+		Active: "self-hosted",
+		Organization: map[string]Organization{
+			"self-hosted": {
+				APIKey:    "self-hosted-key",
+				APIURL:    "https://api.internal",
+				BridgeURL: "https://bridge.internal",
+			},
+			"default": {APIKey: "default-key"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Active organization", func(t *testing.T) {
+		setVariables()
+		if err := runConfigFlow(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "https://api.internal", DispatchApiUrl)
+		assert.Equal(t, "https://bridge.internal", DispatchBridgeUrl)
+		assert.Equal(t, "https://console.dispatch.run", DispatchConsoleUrl)
+	})
+
+	t.Run("Environment and flags have priority", func(t *testing.T) {
+		t.Setenv("DISPATCH_API_URL", "https://api.example.com")
+		DispatchBridgeUrlCli = "https://bridge.example.com"
+		t.Cleanup(func() { DispatchBridgeUrlCli = "" })
+
+		setVariables()
+		if err := runConfigFlow(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "https://api.example.com", DispatchApiUrl)
+		assert.Equal(t, "https://bridge.example.com", DispatchBridgeUrl)
+	})
+
+	t.Run("API key from the command line", func(t *testing.T) {
+		DispatchApiKeyCli = "cli-key"
+		t.Cleanup(func() { DispatchApiKeyCli = "" })
+
+		setVariables()
+		if err := runConfigFlow(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "https://api.dispatch.run", DispatchApiUrl)
+		assert.Equal(t, "https://bridge.dispatch.run", DispatchBridgeUrl)
+	})
+}
+
+func TestEmptyAPIKeyFlag(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	t.Setenv("DISPATCH_API_KEY", "env-key")
+	t.Cleanup(func() { DispatchApiKeyCli = "" })
+
+	for _, args := range [][]string{
+		{"verification", "get", "--api-key", ""},
+		{"verification", "get", "--api-key="},
+		{"--api-key", " ", "verification", "get"},
+	} {
+		cmd := createMainCommand()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(args)
+		assert.ErrorIs(t, cmd.Execute(), errEmptyAPIKey, "%q", args)
+	}
+}
+
+func TestLoadConfigWithoutUrls(t *testing.T) {
+	config, err := loadConfig(bytes.NewBufferString(`
+active = 'org'
+
+[Organizations.org]
+api_key = 'key'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Organization{APIKey: "key"}, config.Organization["org"])
+
+	var b bytes.Buffer
+	if err := writeConfig(&b, config); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, b.String(), "url")
+}
+
+func TestConfigMigration(t *testing.T) {
+	t.Run("Unversioned configuration", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		legacy := `
+# Warning = 'THIS FILE IS GENERATED. DO NOT EDIT!'
+active = 'org2'
+
+[Organizations]
+[Organizations.org1]
+api_key = 'key1'
+
+[Organizations.org2]
+api_key = 'key2'
+`
+		if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &Config{
+			Version: configVersion,
+			Active:  "org2",
+			Organization: map[string]Organization{
+				"org1": {APIKey: "key1"},
+				"org2": {APIKey: "key2"},
+			},
+		}
+		assert.Equal(t, want, config)
+
+		// The file is rewritten in the current format.
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Contains(t, string(b), "version = 1")
+
+		config, err = LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, config)
+	})
+
+	t.Run("Configuration from a newer version", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte("version = 1000\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfig(path)
+		assert.EqualError(t, err, "configuration file version 1000 is not supported by this version of the CLI (latest: 1). Please upgrade the CLI")
+	})
+}
+
+func TestCreateConfigAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+
+	original := &Config{Active: "org", Organization: map[string]Organization{"org": {APIKey: "key"}}}
+	if err := CreateConfig(path, original); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a failure after part of the file was written.
+	writeErr := errors.New("disk full")
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "active = 'tr")
+		return writeErr
+	})
+	assert.ErrorIs(t, err, writeErr)
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(before), string(after))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, entries, 1, "temporary file was not removed")
+
+	// Errors name the file, which isn't necessarily a config file.
+	exportPath := filepath.Join(dir, "missing", "export.json")
+	err = writeFileAtomic(exportPath, func(w io.Writer) error { return nil })
+	assert.ErrorContains(t, err, "failed to create "+exportPath+":")
+}
+
+func TestConfigPermissions(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the default logger!
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on Windows")
+	}
+
+	var logs bytes.Buffer
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := CreateConfig(path, &Config{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	if _, err := LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, logs.String())
+
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := LoadConfig(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, 1, strings.Count(logs.String(), "level=WARN"), "warning should only be logged once")
+	assert.Contains(t, logs.String(), "mode=0644")
+}
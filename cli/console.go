@@ -20,6 +20,7 @@ func (c *console) Login(token string) error {
 			return err
 		}
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent())
 
 		values := req.URL.Query()
 		values.Add("token", token)
@@ -27,7 +28,7 @@ func (c *console) Login(token string) error {
 
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
-			return err
+			return apiUnreachableError{DispatchConsoleUrl, "--console-url", tidyErr(err)}
 		}
 		defer resp.Body.Close()
 
@@ -63,6 +64,12 @@ func (c *console) Login(token string) error {
 		}
 	}
 
+	unlock, err := lockConfig(DispatchConfigPath, configLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if err := CreateConfig(DispatchConfigPath, &config); err != nil {
 		return fmt.Errorf("failed to create config: %w", err)
 	}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requestDeduplicator keeps track of the IDs of recently handled requests,
+// so that requests redelivered by the Dispatch API (e.g. after a transient
+// network error) aren't forwarded to the local application twice.
+//
+// At most size request IDs are retained, and the least recently seen are
+// evicted first. IDs are forgotten after the window has elapsed since they
+// were first seen, or when the request is cleaned up, since Dispatch then
+// delivers it again.
+type requestDeduplicator struct {
+	size   int
+	window time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   list.List // of *dedupEntry, most recently seen first
+}
+
+type dedupEntry struct {
+	id   string
+	time time.Time
+}
+
+func newRequestDeduplicator(size int, window time.Duration) *requestDeduplicator {
+	return &requestDeduplicator{
+		size:    size,
+		window:  window,
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// seen records the request ID and returns true if it was already seen
+// within the window.
+func (d *requestDeduplicator) seen(id string, now time.Time) bool {
+	if d == nil || d.size <= 0 || d.window <= 0 || id == "" {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.entries[id]; ok {
+		// The window starts when the request is first seen, so that
+		// repeated deliveries don't extend it.
+		entry := e.Value.(*dedupEntry)
+		d.order.MoveToFront(e)
+		if now.Sub(entry.time) < d.window {
+			return true
+		}
+		entry.time = now
+		return false
+	}
+
+	d.entries[id] = d.order.PushFront(&dedupEntry{id: id, time: now})
+	for d.order.Len() > d.size {
+		e := d.order.Back()
+		d.order.Remove(e)
+		delete(d.entries, e.Value.(*dedupEntry).id)
+	}
+	return false
+}
+
+// forget removes the request ID, so that the next delivery of the request
+// isn't considered a duplicate.
+func (d *requestDeduplicator) forget(id string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.entries[id]; ok {
+		d.order.Remove(e)
+		delete(d.entries, id)
+	}
+}
+
+// dedupRequests wraps a request handler so that duplicate requests are
+// passed to onDuplicate instead of onRequest.
+func dedupRequests(d *requestDeduplicator, onRequest, onDuplicate func(string, *http.Response)) func(string, *http.Response) {
+	return func(requestID string, res *http.Response) {
+		if d.seen(requestID, time.Now()) {
+			onDuplicate(requestID, res)
+			return
+		}
+		onRequest(requestID, res)
+	}
+}
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRequestDeduplicator(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Duplicate within the window", func(t *testing.T) {
+		d := newRequestDeduplicator(10, time.Minute)
+		assert.False(t, d.seen("a", now))
+		assert.True(t, d.seen("a", now.Add(time.Second)))
+		assert.False(t, d.seen("b", now.Add(time.Second)))
+	})
+
+	t.Run("Duplicate after the window", func(t *testing.T) {
+		d := newRequestDeduplicator(10, time.Minute)
+		assert.False(t, d.seen("a", now))
+		assert.False(t, d.seen("a", now.Add(2*time.Minute)))
+	})
+
+	t.Run("Window starts when first seen", func(t *testing.T) {
+		d := newRequestDeduplicator(10, time.Minute)
+		assert.False(t, d.seen("a", now))
+		assert.True(t, d.seen("a", now.Add(50*time.Second)))
+		assert.False(t, d.seen("a", now.Add(70*time.Second)))
+	})
+
+	t.Run("Forgotten after cleanup", func(t *testing.T) {
+		bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "DELETE", r.Method)
+		}))
+		defer bridge.Close()
+
+		d := newRequestDeduplicator(10, time.Minute)
+		cleaner := newRequestCleaner(http.DefaultClient, bridge.URL, 5*time.Second, 1)
+		cleaner.forget = d.forget
+		defer cleaner.cancel()
+
+		assert.False(t, d.seen("a", now))
+		assert.NoError(t, cleaner.cleanup("a"))
+		assert.False(t, d.seen("a", now))
+		assert.True(t, d.seen("a", now))
+	})
+
+	t.Run("Least recently seen is evicted", func(t *testing.T) {
+		d := newRequestDeduplicator(2, time.Minute)
+		assert.False(t, d.seen("a", now))
+		assert.False(t, d.seen("b", now))
+		assert.True(t, d.seen("a", now))
+		assert.False(t, d.seen("c", now)) // evicts b
+		assert.False(t, d.seen("b", now))
+		assert.True(t, d.seen("c", now))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		d := newRequestDeduplicator(0, time.Minute)
+		assert.False(t, d.seen("a", now))
+		assert.False(t, d.seen("a", now))
+	})
+}
+
+func TestDedupRequests(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the local endpoint!
+	var endpointHits int64
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&endpointHits, 1)
+		b, _ := proto.Marshal(&sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+		w.Header().Set("Content-Type", "application/proto")
+		w.Write(b)
+	}))
+	defer endpoint.Close()
+
+	localEndpoint := LocalEndpoint
+	LocalEndpoint = strings.TrimPrefix(endpoint.URL, "http://")
+	t.Cleanup(func() { LocalEndpoint = localEndpoint })
+
+	// The bridge delivers the same request twice.
+	var deliveries, cleanups int64
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			if atomic.AddInt64(&deliveries, 1) > 2 {
+				<-r.Context().Done()
+				return
+			}
+			b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: "1"})
+			req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+			w.Header().Set("X-Request-Id", "request-1")
+			req.Write(w)
+		case "POST":
+			w.WriteHeader(http.StatusAccepted)
+		case "DELETE":
+			atomic.AddInt64(&cleanups, 1)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer bridge.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	onRequest := dedupRequests(newRequestDeduplicator(10, time.Minute), func(requestID string, res *http.Response) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer res.Body.Close()
+			if err := invoke(ctx, http.DefaultClient, bridge.URL, requestID, res, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}, func(requestID string, res *http.Response) {
+		res.Body.Close()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pollLoop(ctx, http.DefaultClient, bridge.URL, nil, func(err error) { t.Error(err) }, onRequest)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&deliveries) > 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&endpointHits))
+	// The duplicate isn't deleted, as that would also delete the original
+	// request.
+	assert.Equal(t, int64(0), atomic.LoadInt64(&cleanups))
+}
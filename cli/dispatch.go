@@ -24,9 +24,10 @@ func (d *dispatchApi) ListSigningKeys() (*ListSigningKeys, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+d.apiKey)
+	req.Header.Set("User-Agent", userAgent())
 	resp, err := d.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, apiUnreachableError{DispatchApiUrl, "--api-url", tidyErr(err)}
 	}
 	switch resp.StatusCode {
 	case http.StatusUnauthorized:
@@ -59,9 +60,10 @@ func (d *dispatchApi) CreateSigningKey() (*SigningKey, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+d.apiKey)
+	req.Header.Set("User-Agent", userAgent())
 	resp, err := d.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, apiUnreachableError{DispatchApiUrl, "--api-url", tidyErr(err)}
 	}
 	switch resp.StatusCode {
 	case http.StatusUnauthorized:
@@ -82,3 +84,36 @@ func (d *dispatchApi) CreateSigningKey() (*SigningKey, error) {
 	}
 	return skey, nil
 }
+
+func (d *dispatchApi) DeleteSigningKey(signingKeyID string) error {
+	body, err := json.Marshal(struct {
+		SigningKeyID string `json:"signingKeyId"`
+	}{signingKeyID})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(
+		"POST",
+		DispatchApiUrl+"/dispatch.v1.SigningKeyService/DeleteSigningKey",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+d.apiKey)
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return apiUnreachableError{DispatchApiUrl, "--api-url", tidyErr(err)}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return authError{}
+	case http.StatusOK:
+		return nil
+	default:
+		return errors.New("failed to delete signing key, status: " + resp.Status)
+	}
+}
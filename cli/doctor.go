@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+)
+
+// checkResult is the outcome of one of the checks of the doctor command.
+type checkResult struct {
+	name    string
+	status  checkStatus
+	message string
+	hint    string
+}
+
+// doctor runs the checks of the doctor command. The dependencies of the
+// checks are fields, so that tests can stub them.
+type doctor struct {
+	endpoint string
+
+	loadConfig    func() error
+	api           func() signingKeyAPI
+	probeBridge   func(ctx context.Context) error
+	checkEndpoint func(addr string) bool
+	probeEndpoint func(addr string) error
+}
+
+func newDoctor() *doctor {
+	return &doctor{
+		endpoint:   defaultEndpoint,
+		loadConfig: runConfigFlow,
+		api: func() signingKeyAPI {
+			return &dispatchApi{client: httpClient, apiKey: DispatchApiKey}
+		},
+		probeBridge: func(ctx context.Context) error {
+			// The session doesn't need to exist: a HEAD request is
+			// enough to check that the bridge accepts the API key,
+			// and doesn't consume function calls.
+			return probeBridge(ctx, httpClient, DispatchBridgeUrl+"/sessions/dispatch-doctor")
+		},
+		checkEndpoint: func(addr string) bool { return checkEndpoint(addr, time.Second) },
+		probeEndpoint: func(addr string) error { return probeEndpoint(addr, time.Second) },
+	}
+}
+
+// run runs the checks and returns their results, in order. The checks that
+// require an API key are skipped if the configuration check fails.
+func (d *doctor) run(ctx context.Context) []checkResult {
+	results := []checkResult{d.checkConfig()}
+	if results[0].status != checkFail {
+		results = append(results, d.checkAPI(), d.checkBridge(ctx))
+	}
+	return append(results, d.checkEndpointPort())
+}
+
+func (d *doctor) checkConfig() checkResult {
+	result := checkResult{name: "Configuration"}
+	if err := d.loadConfig(); err != nil {
+		result.status = checkFail
+		result.message = err.Error()
+		if !errors.As(err, new(configMissingError)) {
+			result.hint = fmt.Sprintf("Fix or remove the configuration file at %s, then run `dispatch login`", DispatchConfigPath)
+		}
+		return result
+	}
+	switch DispatchApiKeyLocation {
+	case "env":
+		result.message = "Using the API key from the DISPATCH_API_KEY environment variable"
+	case "cli":
+		result.message = "Using the API key from the -k,--api-key command-line option"
+	default:
+		result.message = "Using the API key from " + DispatchConfigPath
+	}
+	return result
+}
+
+func (d *doctor) checkAPI() checkResult {
+	result := checkResult{name: "API authentication"}
+	if _, err := d.api().ListSigningKeys(); err != nil {
+		result.status = checkFail
+		result.message = err.Error()
+		if !errors.As(err, new(authError)) {
+			result.hint = "Check your network connection, and the --api-url option or DISPATCH_API_URL environment variable"
+		}
+		return result
+	}
+	result.message = "The API key is valid"
+	return result
+}
+
+func (d *doctor) checkBridge(ctx context.Context) checkResult {
+	result := checkResult{name: "Dispatch bridge"}
+	err := d.probeBridge(ctx)
+	if errors.As(err, new(bridgeProbeUnknownError)) {
+		result.status = checkWarn
+		result.message = err.Error()
+		result.hint = "The connection is checked again when running `dispatch run`"
+		return result
+	}
+	if err != nil && !errors.As(err, new(sessionNotFoundError)) {
+		result.status = checkFail
+		result.message = err.Error()
+		if errors.As(err, new(bridgeUnreachableError)) {
+			result.hint = "Check your network connection, and that no proxy or firewall blocks the connection"
+		}
+		return result
+	}
+	result.message = "The bridge is reachable at " + DispatchBridgeUrl
+	return result
+}
+
+func (d *doctor) checkEndpointPort() checkResult {
+	result := checkResult{name: "Local endpoint"}
+	if !d.checkEndpoint(d.endpoint) {
+		result.message = fmt.Sprintf("%s is available for the local application", d.endpoint)
+		return result
+	}
+	result.status = checkWarn
+	if err := d.probeEndpoint(d.endpoint); err != nil {
+		result.message = fmt.Sprintf("%s is already in use, and %v", d.endpoint, err)
+		result.hint = "Stop the process listening on this address, or pass another address to `dispatch run --endpoint`"
+	} else {
+		result.message = fmt.Sprintf("%s is already in use by what looks like a Dispatch application", d.endpoint)
+		result.hint = "Stop the application if it's left over from a previous session, or pass another address to `dispatch run --endpoint`"
+	}
+	return result
+}
+
+// writeCheckResults prints the results of the checks, one per line,
+// followed by their remediation hint, if any.
+func writeCheckResults(w io.Writer, results []checkResult) {
+	for _, r := range results {
+		var icon string
+		switch r.status {
+		case checkPass:
+			icon = successStyle.Render(successIcon)
+		case checkWarn:
+			icon = warningStyle.Render("!")
+		case checkFail:
+			icon = failureStyle.Render(failureIcon)
+		}
+		fmt.Fprintf(w, "%s %s: %s\n", icon, r.name, r.message)
+		if r.hint != "" {
+			fmt.Fprintf(w, "  %s\n", r.hint)
+		}
+	}
+}
+
+func doctorCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the Dispatch configuration and connectivity",
+		Long: fmt.Sprintf(`Check the Dispatch configuration and connectivity.
+
+The doctor command checks that an API key is configured and accepted by
+the Dispatch API, that the Dispatch bridge can be contacted, and that the
+default endpoint of the local application (%s) is available.
+Each failed check is reported with a hint to fix it.`, defaultEndpoint),
+		GroupID:      "management",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			results := newDoctor().run(cmd.Context())
+			writeCheckResults(cmd.OutOrStdout(), results)
+
+			var failed int
+			for _, r := range results {
+				if r.status == checkFail {
+					failed++
+				}
+			}
+			switch failed {
+			case 0:
+				return nil
+			case 1:
+				return errors.New("1 check failed")
+			default:
+				return fmt.Errorf("%d checks failed", failed)
+			}
+		},
+	}
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingSigningKeyAPI struct {
+	stubSigningKeyAPI
+	err error
+}
+
+func (f *failingSigningKeyAPI) ListSigningKeys() (*ListSigningKeys, error) {
+	return nil, f.err
+}
+
+func TestDoctor(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	location, bridgeURL := DispatchApiKeyLocation, DispatchBridgeUrl
+	t.Cleanup(func() { DispatchApiKeyLocation, DispatchBridgeUrl = location, bridgeURL })
+	DispatchBridgeUrl = "https://bridge.example.com"
+
+	// newDoctor returns a doctor for which all the checks pass, so that
+	// each test case only needs to stub the dependency it breaks.
+	newDoctor := func() *doctor {
+		return &doctor{
+			endpoint: "127.0.0.1:8000",
+			loadConfig: func() error {
+				DispatchApiKeyLocation = "env"
+				return nil
+			},
+			api:           func() signingKeyAPI { return &stubSigningKeyAPI{} },
+			probeBridge:   func(context.Context) error { return sessionNotFoundError{} },
+			checkEndpoint: func(string) bool { return false },
+			probeEndpoint: func(string) error { return nil },
+		}
+	}
+
+	tcs := []struct {
+		name   string
+		setup  func(*doctor)
+		output string
+	}{
+		{
+			name:  "All checks pass",
+			setup: func(*doctor) {},
+			output: `✔ Configuration: Using the API key from the DISPATCH_API_KEY environment variable
+✔ API authentication: The API key is valid
+✔ Dispatch bridge: The bridge is reachable at https://bridge.example.com
+✔ Local endpoint: 127.0.0.1:8000 is available for the local application
+`,
+		},
+		{
+			name: "Not logged in",
+			setup: func(d *doctor) {
+				d.loadConfig = func() error { return configMissingError{} }
+			},
+			output: "✗ Configuration: " + configMissingError{}.Error() + `
+✔ Local endpoint: 127.0.0.1:8000 is available for the local application
+`,
+		},
+		{
+			name: "API key rejected",
+			setup: func(d *doctor) {
+				d.api = func() signingKeyAPI { return &failingSigningKeyAPI{err: authError{}} }
+				d.probeBridge = func(context.Context) error { return authError{} }
+			},
+			output: `✔ Configuration: Using the API key from the DISPATCH_API_KEY environment variable
+✗ API authentication: Authentication error (check DISPATCH_API_KEY environment variable)
+✗ Dispatch bridge: Authentication error (check DISPATCH_API_KEY environment variable)
+✔ Local endpoint: 127.0.0.1:8000 is available for the local application
+`,
+		},
+		{
+			name: "Bridge unreachable",
+			setup: func(d *doctor) {
+				d.probeBridge = func(context.Context) error {
+					return bridgeUnreachableError{errors.New("connection refused")}
+				}
+			},
+			output: `✔ Configuration: Using the API key from the DISPATCH_API_KEY environment variable
+✔ API authentication: The API key is valid
+✗ Dispatch bridge: cannot contact Dispatch API (https://bridge.example.com): connection refused (check the --bridge-url option or DISPATCH_BRIDGE_URL environment variable)
+  Check your network connection, and that no proxy or firewall blocks the connection
+✔ Local endpoint: 127.0.0.1:8000 is available for the local application
+`,
+		},
+		{
+			name: "Bridge response unknown",
+			setup: func(d *doctor) {
+				d.probeBridge = func(context.Context) error {
+					return bridgeProbeUnknownError{"405 Method Not Allowed"}
+				}
+			},
+			output: `✔ Configuration: Using the API key from the DISPATCH_API_KEY environment variable
+✔ API authentication: The API key is valid
+! Dispatch bridge: cannot check the connection to the Dispatch bridge (https://bridge.example.com): unexpected response status 405 Method Not Allowed
+  The connection is checked again when running ` + "`dispatch run`" + `
+✔ Local endpoint: 127.0.0.1:8000 is available for the local application
+`,
+		},
+		{
+			name: "Endpoint in use by another service",
+			setup: func(d *doctor) {
+				d.checkEndpoint = func(string) bool { return true }
+				d.probeEndpoint = func(string) error { return errors.New("it does not serve the Dispatch function service") }
+			},
+			output: `✔ Configuration: Using the API key from the DISPATCH_API_KEY environment variable
+✔ API authentication: The API key is valid
+✔ Dispatch bridge: The bridge is reachable at https://bridge.example.com
+! Local endpoint: 127.0.0.1:8000 is already in use, and it does not serve the Dispatch function service
+  Stop the process listening on this address, or pass another address to ` + "`dispatch run --endpoint`" + `
+`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newDoctor()
+			tc.setup(d)
+
+			var b bytes.Buffer
+			writeCheckResults(&b, d.run(context.Background()))
+			assert.Equal(t, tc.output, b.String())
+		})
+	}
+}
+
+func TestDoctorConfigHint(t *testing.T) {
+	d := &doctor{loadConfig: func() error { return errors.New("failed to load configuration") }}
+	result := d.checkConfig()
+	assert.Equal(t, checkFail, result.status)
+	assert.True(t, strings.HasPrefix(result.hint, "Fix or remove the configuration file"))
+}
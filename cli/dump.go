@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// writeInFlightCalls writes a summary of the function calls that haven't
+// completed yet, from oldest to newest. It's used to diagnose stuck
+// sessions, see notifyDumpSignal.
+func writeInFlightCalls(w io.Writer, calls *callStore, now time.Time) {
+	calls.mu.Lock()
+	var ids []DispatchID
+	for id, n := range calls.calls {
+		if !n.done && len(n.timeline) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b DispatchID) int {
+		if c := calls.calls[a].creationTime.Compare(calls.calls[b].creationTime); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a), string(b))
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d in-flight function call(s)\n", len(ids))
+	for _, id := range ids {
+		n := calls.calls[id]
+		_, _, status := n.status(now)
+		fmt.Fprintf(&b, "  %s %s (%s, running for %s)\n", id, n.function(), status, n.duration(now))
+	}
+	calls.mu.Unlock()
+
+	_, _ = io.WriteString(w, b.String())
+}
+
+// writeFunctionsTable writes a plain-text snapshot of the function calls
+// table, for sessions where the TUI isn't displayed.
+func writeFunctionsTable(w io.Writer, calls *callStore, now time.Time) {
+	// The TUI isn't displayed, it's only used to render the table.
+	t := &TUI{store: calls}
+
+	calls.mu.Lock()
+	var table string
+	if len(calls.orderedRoots) > 0 {
+		table = clearANSI(t.functionsTable(now, false))
+	}
+	calls.mu.Unlock()
+
+	if table == "" {
+		_, _ = io.WriteString(w, "No function calls yet\n")
+		return
+	}
+
+	// Strip the padding that aligns the TUI columns to the screen.
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(table, "\n"), "\n") {
+		b.WriteString(strings.TrimRight(line, " "))
+		b.WriteByte('\n')
+	}
+	_, _ = io.WriteString(w, b.String())
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteInFlightCalls(t *testing.T) {
+	now := time.Now()
+
+	calls := &callStore{}
+	calls.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "parent"})
+	calls.ObserveRequest(now.Add(time.Second), &sdkv1.RunRequest{DispatchId: "2", RootDispatchId: "1", ParentDispatchId: "1", Function: "child"})
+	calls.ObserveRequest(now.Add(time.Second), &sdkv1.RunRequest{DispatchId: "3", RootDispatchId: "1", ParentDispatchId: "1", Function: "done"})
+	calls.ObserveResponse(now.Add(2*time.Second), &sdkv1.RunRequest{DispatchId: "3"}, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+
+	var b bytes.Buffer
+	writeInFlightCalls(&b, calls, now.Add(3*time.Second))
+
+	assert.Equal(t, "2 in-flight function call(s)\n"+
+		"  1 parent (Running, running for 3s)\n"+
+		"  2 child (Running, running for 2s)\n", b.String())
+}
+
+func TestWriteFunctionsTable(t *testing.T) {
+	now := time.Now()
+
+	calls := &callStore{}
+
+	var b bytes.Buffer
+	writeFunctionsTable(&b, calls, now)
+	assert.Equal(t, "No function calls yet\n", b.String())
+
+	calls.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "parent"})
+	calls.ObserveRequest(now.Add(time.Second), &sdkv1.RunRequest{DispatchId: "2", RootDispatchId: "1", ParentDispatchId: "1", Function: "child"})
+	calls.ObserveResponse(now.Add(2*time.Second), &sdkv1.RunRequest{DispatchId: "2"}, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+
+	b.Reset()
+	writeFunctionsTable(&b, calls, now.Add(3*time.Second))
+	assert.Equal(t, ""+
+		"Function   Attempt   Duration • Status\n"+
+		"parent           1         3s • Running\n"+
+		"└─ child         1         1s ✔ OK\n", b.String())
+}
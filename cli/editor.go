@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func configCommand(configPath string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "config",
+		Short:   "Manage the configuration file",
+		GroupID: "management",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "edit",
+		Short: "Edit the configuration file",
+		Long: `Edit the configuration file.
+
+The edit command opens the configuration file in $EDITOR. When the editor
+exits, the configuration is validated and only saved if it is valid.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return editConfig(cmd, configPath)
+		},
+	})
+	return cmd
+}
+
+// Validate checks that the configuration can be used by the CLI.
+func (c *Config) Validate() error {
+	if c.Version > configVersion {
+		return fmt.Errorf("unsupported version %d (latest: %d)", c.Version, configVersion)
+	}
+	if c.Active != "" {
+		if _, ok := c.Organization[c.Active]; !ok {
+			return fmt.Errorf("active organization '%s' does not exist", c.Active)
+		}
+	}
+	if c.ActiveProfile != "" {
+		if _, ok := c.Profiles[c.ActiveProfile]; !ok {
+			return fmt.Errorf("active profile '%s' does not exist", c.ActiveProfile)
+		}
+	}
+	for name, profile := range c.Profiles {
+		if _, ok := c.Organization[profile.Organization]; !ok {
+			return fmt.Errorf("profile '%s' uses organization '%s', which does not exist", name, profile.Organization)
+		}
+	}
+	for name, org := range c.Organization {
+		if org.APIKey == "" {
+			return fmt.Errorf("organization '%s' has no API key", name)
+		}
+		for _, u := range []string{org.APIURL, org.BridgeURL, org.ConsoleURL} {
+			if u == "" {
+				continue
+			}
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+				return fmt.Errorf("organization '%s' has an invalid URL: %s", name, u)
+			}
+		}
+	}
+	return nil
+}
+
+// editConfig opens a copy of the configuration file in the user's editor,
+// and replaces the configuration file with it if it is valid. The original
+// file is left untouched when the edited configuration is invalid.
+func editConfig(cmd *cobra.Command, path string) error {
+	original, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			simple(cmd, "Please run `dispatch login` to login to Dispatch.")
+			return nil
+		}
+		return err
+	}
+
+	// The copy is created next to the configuration file so it can be
+	// renamed over it atomically.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "config-*.toml")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(original)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create temporary config file: %w", err)
+	}
+
+	editor := editorCommand(tmp.Name())
+	editor.Stdin = os.Stdin
+	editor.Stdout = cmd.OutOrStdout()
+	editor.Stderr = cmd.ErrOrStderr()
+	if err := editor.Run(); err != nil {
+		return fmt.Errorf("failed to run editor %s: %w", editor.Path, err)
+	}
+
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, original) {
+		simple(cmd, "No changes made to the configuration.")
+		return nil
+	}
+
+	config, err := loadConfig(bytes.NewReader(edited))
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration, changes were discarded: %w", err)
+	}
+
+	// The file is not locked while the editor is open, so check that no
+	// other command changed it in the meantime before replacing it.
+	unlock, err := lockConfig(path, configLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if current, err := os.ReadFile(path); err != nil || !bytes.Equal(current, original) {
+		return fmt.Errorf("the configuration was modified by another command while editing, changes were discarded")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+	simple(cmd, "Configuration saved to "+path)
+	return nil
+}
+
+// editorCommand returns the command opening path in the user's editor.
+// $EDITOR may include arguments, e.g. "code --wait".
+func editorCommand(path string) *exec.Cmd {
+	args := strings.Fields(os.Getenv("EDITOR"))
+	if len(args) == 0 {
+		switch runtime.GOOS {
+		case "windows":
+			args = []string{"notepad"}
+		default: // "linux", "darwin", "freebsd", "openbsd", "netbsd"
+			args = []string{"vi"}
+		}
+	}
+	args = append(args, path)
+	return exec.Command(args[0], args[1:]...)
+}
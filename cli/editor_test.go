@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigEdit(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the environment!
+	if runtime.GOOS == "windows" {
+		t.Skip("the scripted editor requires a POSIX shell")
+	}
+
+	const original = `version = 1
+active = 'org'
+
+[Organizations.org]
+api_key = 'key'
+`
+
+	tcs := []struct {
+		name   string
+		edited string
+		err    string
+		saved  bool
+	}{
+		{
+			name: "Valid configuration",
+			edited: `version = 1
+active = 'org2'
+
+[Organizations.org]
+api_key = 'key'
+
+[Organizations.org2]
+api_key = 'key2'
+`,
+			saved: true,
+		},
+		{
+			name:   "Invalid TOML",
+			edited: "active = \n",
+			err:    "invalid configuration, changes were discarded: toml:",
+		},
+		{
+			name: "Unknown active organization",
+			edited: `active = 'missing'
+
+[Organizations.org]
+api_key = 'key'
+`,
+			err: "invalid configuration, changes were discarded: active organization 'missing' does not exist",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "config.toml")
+			if err := os.WriteFile(configPath, []byte(original), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			// The editor replaces the file it is given with the edited content.
+			edited := filepath.Join(dir, "edited.toml")
+			if err := os.WriteFile(edited, []byte(tc.edited), 0600); err != nil {
+				t.Fatal(err)
+			}
+			editor := filepath.Join(dir, "editor.sh")
+			if err := os.WriteFile(editor, []byte("#!/bin/sh\ncp "+edited+" \"$1\"\n"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("EDITOR", editor)
+
+			out := &bytes.Buffer{}
+			cmd := configCommand(configPath)
+			cmd.SetOut(out)
+			cmd.SetErr(out)
+			cmd.SetArgs([]string{"edit"})
+
+			err := cmd.Execute()
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			b, err := os.ReadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.saved {
+				assert.Equal(t, tc.edited, string(b))
+			} else {
+				assert.Equal(t, original, string(b))
+			}
+
+			// The temporary copy is always removed.
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Len(t, entries, 3)
+		})
+	}
+}
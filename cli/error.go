@@ -1,6 +1,24 @@
 package cli
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrorFormat is the format of the error printed when a command fails,
+// either "text" or "json".
+var ErrorFormat string
+
+func validateErrorFormat(format string) error {
+	switch format {
+	case "text", "json":
+		return nil
+	}
+	return fmt.Errorf("invalid error format: %q (must be text or json)", format)
+}
 
 type authError struct{}
 
@@ -17,3 +35,198 @@ func (authError) Error() string {
 	}
 	return fmt.Sprintf("%s (%s)", message, detail)
 }
+
+type bridgeUnreachableError struct{ err error }
+
+func (e bridgeUnreachableError) Error() string {
+	return fmt.Sprintf("cannot contact Dispatch API (%s): %v (check the --bridge-url option or DISPATCH_BRIDGE_URL environment variable)", DispatchBridgeUrl, e.err)
+}
+
+func (e bridgeUnreachableError) Unwrap() error {
+	return e.err
+}
+
+// bridgeProbeUnknownError is returned when the response of the Dispatch
+// bridge to a probe doesn't tell whether it can be used, e.g. because the
+// method of the probe isn't supported. The first poll then reports the
+// problem, if any.
+type bridgeProbeUnknownError struct{ status string }
+
+func (e bridgeProbeUnknownError) Error() string {
+	return fmt.Sprintf("cannot check the connection to the Dispatch bridge (%s): unexpected response status %s", DispatchBridgeUrl, e.status)
+}
+
+// apiUnreachableError is returned when the Dispatch API or console can't
+// be contacted, e.g. because of a network error.
+type apiUnreachableError struct {
+	url    string
+	option string // the command-line option configuring the URL
+	err    error
+}
+
+func (e apiUnreachableError) Error() string {
+	return fmt.Sprintf("cannot contact %s: %v (check your network connection and the %s option)", e.url, e.err, e.option)
+}
+
+func (e apiUnreachableError) Unwrap() error {
+	return e.err
+}
+
+// pollError is returned when polling the Dispatch bridge fails for reasons
+// other than authentication or throttling, e.g. a network error or an
+// unexpected response code.
+type pollError struct{ err error }
+
+func (e pollError) Error() string {
+	return fmt.Sprintf("failed to contact Dispatch API (%s): %v", DispatchBridgeUrl, e.err)
+}
+
+func (e pollError) Unwrap() error {
+	return e.err
+}
+
+type sessionNotFoundError struct{}
+
+func (sessionNotFoundError) Error() string {
+	return fmt.Sprintf("session %s no longer exists (run without --session to start a new session, or use --new-on-missing)", BridgeSession)
+}
+
+// throttledError is returned when the Dispatch bridge is throttling
+// requests (429 Too Many Requests).
+type throttledError struct{ retryAfter time.Duration }
+
+func (e throttledError) Error() string {
+	if e.retryAfter <= 0 {
+		return "Dispatch API is throttling requests, backing off"
+	}
+	return fmt.Sprintf("Dispatch API is throttling requests, backing off for %v", e.retryAfter)
+}
+
+// configMissingError is returned when no API key is configured.
+type configMissingError struct {
+	// Whether the configuration has organizations, none of which is
+	// selected.
+	hasOrganizations bool
+}
+
+func (e configMissingError) Error() string {
+	if e.hasOrganizations {
+		return "No organization selected. Please run `dispatch switch` to select one."
+	}
+	return "Please run `dispatch login` to login to Dispatch. Alternatively, set the DISPATCH_API_KEY environment variable, or provide an --api-key (-k) on the command line."
+}
+
+// configInvalidError is returned when the configuration file can't be
+// loaded.
+type configInvalidError struct {
+	path string
+	err  error
+}
+
+func (e configInvalidError) Error() string {
+	return fmt.Sprintf("failed to load configuration from %s: %v", e.path, e.err)
+}
+
+func (e configInvalidError) Unwrap() error {
+	return e.err
+}
+
+// profileNotFoundError is returned when the selected profile doesn't exist
+// in the configuration.
+type profileNotFoundError struct{ profile string }
+
+func (e profileNotFoundError) Error() string {
+	return fmt.Sprintf("profile '%s' not found in configuration. Run `dispatch profile` to list the available profiles", e.profile)
+}
+
+// organizationInvalidError is returned when the organization of the
+// selected profile, or the active organization if no profile is selected,
+// doesn't exist in the configuration.
+type organizationInvalidError struct {
+	organization string
+	profile      string
+}
+
+func (e organizationInvalidError) Error() string {
+	if e.profile != "" {
+		return fmt.Sprintf("invalid organization '%s' found in profile '%s'. Please run `dispatch login` or edit the profile", e.organization, e.profile)
+	}
+	return fmt.Sprintf("invalid active organization '%s' found in configuration. Please run `dispatch login` or `dispatch switch`", e.organization)
+}
+
+type endpointInUseError struct{ endpoint string }
+
+func (e endpointInUseError) Error() string {
+	return fmt.Sprintf("cannot start local application on address that's already in use: %v", e.endpoint)
+}
+
+// errorCode returns a stable code identifying the kind of error, for tools
+// wrapping the CLI (see --error-format).
+func errorCode(err error) string {
+	switch {
+	case errors.As(err, new(authError)):
+		return "auth"
+	case errors.As(err, new(configMissingError)):
+		return "config_missing"
+	case errors.As(err, new(configInvalidError)):
+		return "config_invalid"
+	case errors.As(err, new(profileNotFoundError)):
+		return "profile_not_found"
+	case errors.As(err, new(organizationInvalidError)):
+		return "organization_invalid"
+	case errors.As(err, new(endpointInUseError)):
+		return "endpoint_in_use"
+	case errors.As(err, new(bridgeUnreachableError)):
+		return "bridge_unreachable"
+	case errors.As(err, new(pollError)):
+		return "bridge_error"
+	case errors.As(err, new(apiUnreachableError)):
+		return "api_unreachable"
+	case errors.As(err, new(sessionNotFoundError)):
+		return "session_not_found"
+	default:
+		return "error"
+	}
+}
+
+// Exit codes of the dispatch command, see ExitCode.
+const (
+	exitCodeError         = 1
+	exitCodeAuth          = 2
+	exitCodeConfig        = 3
+	exitCodeEndpointInUse = 4
+	exitCodeNetwork       = 5
+)
+
+// ExitCode returns the code that the dispatch command exits with when it
+// fails with err. The codes are documented in the README, and must remain
+// stable since scripts may depend on them.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	switch errorCode(err) {
+	case "auth":
+		return exitCodeAuth
+	case "config_missing", "config_invalid", "profile_not_found", "organization_invalid":
+		return exitCodeConfig
+	case "endpoint_in_use":
+		return exitCodeEndpointInUse
+	case "bridge_unreachable", "bridge_error", "api_unreachable":
+		return exitCodeNetwork
+	default:
+		return exitCodeError
+	}
+}
+
+// writeErrorJSON writes the error as a JSON object with the error message
+// and its code.
+func writeErrorJSON(w io.Writer, err error) error {
+	return json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}{
+		Error: err.Error(),
+		Code:  errorCode(err),
+	})
+}
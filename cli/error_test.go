@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "auth", errorCode(authError{}))
+	assert.Equal(t, "config_missing", errorCode(configMissingError{}))
+	assert.Equal(t, "endpoint_in_use", errorCode(endpointInUseError{"127.0.0.1:8000"}))
+	assert.Equal(t, "session_not_found", errorCode(fmt.Errorf("resume: %w", sessionNotFoundError{})))
+	assert.Equal(t, "error", errorCode(fmt.Errorf("oops")))
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"success", nil, 0},
+		{"auth", authError{}, 2},
+		{"config missing", configMissingError{hasOrganizations: true}, 3},
+		{"config invalid", configInvalidError{path: "config.toml", err: fmt.Errorf("malformed")}, 3},
+		{"profile not found", profileNotFoundError{profile: "staging"}, 3},
+		{"profile organization invalid", organizationInvalidError{organization: "acme", profile: "staging"}, 3},
+		{"active organization invalid", organizationInvalidError{organization: "acme"}, 3},
+		{"endpoint in use", endpointInUseError{"127.0.0.1:8000"}, 4},
+		{"bridge unreachable", bridgeUnreachableError{fmt.Errorf("connection refused")}, 5},
+		{"poll error", pollError{fmt.Errorf("response code 502")}, 5},
+		{"api unreachable", apiUnreachableError{"https://api.dispatch.run", "--api-url", errConnectionRefused}, 5},
+		{"wrapped poll error", fmt.Errorf("session: %w", pollError{fmt.Errorf("connection reset")}), 5},
+		{"session not found", sessionNotFoundError{}, 1},
+		{"other", fmt.Errorf("oops"), 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.code, ExitCode(test.err))
+		})
+	}
+}
+
+func TestCommandExitCode(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	t.Setenv("DISPATCH_API_KEY", "")
+	t.Setenv("DISPATCH_PROFILE", "")
+	configPath, profile := DispatchConfigPath, DispatchProfileCli
+	apiURL, apiURLCli := DispatchApiUrl, DispatchApiUrlCli
+	apiKey, apiKeyCli, apiKeyLocation := DispatchApiKey, DispatchApiKeyCli, DispatchApiKeyLocation
+	t.Cleanup(func() {
+		DispatchConfigPath, DispatchProfileCli = configPath, profile
+		DispatchApiUrl, DispatchApiUrlCli = apiURL, apiURLCli
+		DispatchApiKey, DispatchApiKeyCli, DispatchApiKeyLocation = apiKey, apiKeyCli, apiKeyLocation
+	})
+	DispatchConfigPath = filepath.Join(t.TempDir(), "config.toml")
+
+	exec := func(args ...string) int {
+		cmd := createMainCommand()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(args)
+		return ExitCode(execute(context.Background(), cmd))
+	}
+
+	// Not logged in.
+	assert.Equal(t, 3, exec("verification", "get"))
+
+	// Unknown profile.
+	assert.Equal(t, 3, exec("--profile", "missing", "verification", "get"))
+
+	// The Dispatch API refuses connections.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedURL := "http://" + l.Addr().String()
+	l.Close()
+	assert.Equal(t, 5, exec("--api-key", "00000000", "--api-url", refusedURL, "verification", "get"))
+
+	// Invalid option.
+	assert.Equal(t, 1, exec("run", "--poll-concurrency", "0", "--", "true"))
+}
+
+func TestErrorFormatJSON(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	t.Setenv("DISPATCH_API_KEY", "")
+	t.Setenv("DISPATCH_PROFILE", "")
+	configPath, errorFormat := DispatchConfigPath, ErrorFormat
+	t.Cleanup(func() { DispatchConfigPath, ErrorFormat = configPath, errorFormat })
+	DispatchConfigPath = filepath.Join(t.TempDir(), "config.toml")
+
+	execJSON := func(args ...string) map[string]string {
+		cmd := createMainCommand()
+		var stderr bytes.Buffer
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&stderr)
+		cmd.SetArgs(append([]string{"--error-format", "json"}, args...))
+		assert.Error(t, execute(context.Background(), cmd))
+
+		var result map[string]string
+		if err := json.Unmarshal(stderr.Bytes(), &result); err != nil {
+			t.Fatalf("invalid JSON error: %v: %q", err, stderr.String())
+		}
+		return result
+	}
+
+	result := execJSON("verification", "get")
+	assert.Equal(t, "config_missing", result["code"])
+	assert.Contains(t, result["error"], "Please run `dispatch login`")
+
+	result = execJSON("view", filepath.Join(t.TempDir(), "missing.json"))
+	assert.Equal(t, "error", result["code"])
+	assert.Contains(t, result["error"], "missing.json")
+}
@@ -0,0 +1,354 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// sessionExportVersion is the version of the session export format.
+const sessionExportVersion = 1
+
+// redactedValue replaces the inputs and outputs of redacted exports.
+const redactedValue = "<redacted>"
+
+// sessionExport is a bundle of the function calls of a session, written by
+// --export when the session ends. It contains everything needed to render
+// the function calls in the TUI again.
+type sessionExport struct {
+	Version    int                         `json:"version"`
+	SessionID  string                      `json:"session_id,omitempty"`
+	ExportedAt time.Time                   `json:"exported_at"`
+	Roots      []DispatchID                `json:"roots"`
+	Calls      map[DispatchID]exportedCall `json:"calls"`
+	LastFailed DispatchID                  `json:"last_failed,omitempty"`
+}
+
+type exportedCall struct {
+	Function       string              `json:"function"`
+	TailCalls      []string            `json:"tail_calls,omitempty"`
+	Status         string              `json:"status,omitempty"`
+	Error          string              `json:"error,omitempty"`
+	Failures       int                 `json:"failures"`
+	Polls          int                 `json:"polls"`
+	SpawnedCalls   int                 `json:"spawned_calls,omitempty"`
+	Running        bool                `json:"running"`
+	Suspended      bool                `json:"suspended"`
+	Done           bool                `json:"done"`
+	CreationTime   time.Time           `json:"creation_time"`
+	ExpirationTime time.Time           `json:"expiration_time"`
+	DoneTime       time.Time           `json:"done_time"`
+	Children       []DispatchID        `json:"children,omitempty"`
+	Timeline       []exportedRoundtrip `json:"timeline"`
+
+	// Roundtrips before an incompatible state reset.
+	PreviousTimeline []exportedRoundtrip `json:"previous_timeline,omitempty"`
+}
+
+// exportedRoundtrip is a request to the local application and its response.
+// The protos are serialized in their binary form, since the inputs and
+// outputs may contain types that can't be represented in JSON. Inputs and
+// outputs are also included in their rendered form.
+type exportedRoundtrip struct {
+	RequestTime  time.Time `json:"request_time"`
+	Request      []byte    `json:"request"`
+	Input        string    `json:"input,omitempty"`
+	ResponseTime time.Time `json:"response_time"`
+	Response     []byte    `json:"response,omitempty"`
+	Output       string    `json:"output,omitempty"`
+	HTTPStatus   int       `json:"http_status,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// export returns a bundle of the function calls, including the ones that
+// were evicted or cleared if the store keeps them. If redact is true, the
+// inputs and outputs of the function calls are masked.
+func (s *callStore) export(sessionID string, now time.Time, redact bool) (*sessionExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	export := &sessionExport{
+		Version:    sessionExportVersion,
+		SessionID:  sessionID,
+		ExportedAt: now,
+		Roots:      make([]DispatchID, 0, len(s.removedRoots)+len(s.orderedRoots)),
+		Calls:      make(map[DispatchID]exportedCall, len(s.removedCalls)+len(s.calls)),
+		LastFailed: s.lastFailed,
+	}
+
+	// The removed hierarchies are the oldest or finished ones, so they're
+	// listed first. A removed hierarchy may have been observed again, in
+	// which case the function calls that are retained take precedence.
+	listed := make(map[DispatchID]struct{}, len(s.removedRoots))
+	for _, rootID := range s.removedRoots {
+		if _, ok := s.roots[rootID]; ok {
+			continue
+		}
+		if _, ok := listed[rootID]; !ok {
+			listed[rootID] = struct{}{}
+			export.Roots = append(export.Roots, rootID)
+		}
+	}
+	export.Roots = append(export.Roots, s.orderedRoots...)
+
+	calls := make(map[DispatchID]functionCall, len(s.removedCalls)+len(s.calls))
+	maps.Copy(calls, s.removedCalls)
+	maps.Copy(calls, s.calls)
+
+	for id, n := range calls {
+		call := exportedCall{
+			Function:       n.lastFunction,
+			TailCalls:      n.tailCalls,
+			Failures:       n.failures,
+			Polls:          n.polls,
+			SpawnedCalls:   n.spawnedCalls,
+			Running:        n.running,
+			Suspended:      n.suspended,
+			Done:           n.done,
+			CreationTime:   n.creationTime,
+			ExpirationTime: n.expirationTime,
+			DoneTime:       n.doneTime,
+			Children:       n.orderedChildren,
+			Timeline:       make([]exportedRoundtrip, 0, len(n.timeline)),
+		}
+		if n.lastStatus != 0 {
+			call.Status = n.lastStatus.String()
+		}
+		if n.lastError != nil {
+			call.Error = n.lastError.Error()
+		}
+		for _, rt := range n.timeline {
+			exported, err := exportRoundtrip(rt, redact)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export function call %s: %w", id, err)
+			}
+			call.Timeline = append(call.Timeline, exported)
+		}
+		for _, rt := range n.previousTimeline {
+			exported, err := exportRoundtrip(rt, redact)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export function call %s: %w", id, err)
+			}
+			call.PreviousTimeline = append(call.PreviousTimeline, exported)
+		}
+		export.Calls[id] = call
+	}
+	return export, nil
+}
+
+func exportRoundtrip(rt *roundtrip, redact bool) (exportedRoundtrip, error) {
+	req, res := rt.request.proto, rt.response.proto
+	if redact {
+		req, res = redactRequest(req), redactResponse(res)
+	}
+
+	exported := exportedRoundtrip{
+		RequestTime:  rt.request.ts,
+		ResponseTime: rt.response.ts,
+		HTTPStatus:   rt.response.httpStatus,
+	}
+	if rt.response.err != nil {
+		exported.Error = rt.response.err.Error()
+	}
+
+	var err error
+	if exported.Request, err = proto.Marshal(req); err != nil {
+		return exported, err
+	}
+	if d, ok := req.GetDirective().(*sdkv1.RunRequest_Input); ok {
+		exported.Input = redactedValue
+		if !redact {
+			exported.Input = anyString(d.Input)
+		}
+	}
+	if res != nil {
+		if exported.Response, err = proto.Marshal(res); err != nil {
+			return exported, err
+		}
+		if result := res.GetExit().GetResult(); result != nil {
+			exported.Output = redactedValue
+			if !redact {
+				exported.Output = anyString(result.Output)
+			}
+		}
+	}
+	return exported, nil
+}
+
+// redactRequest returns a copy of the request without the function input
+// and the coroutine state.
+func redactRequest(req *sdkv1.RunRequest) *sdkv1.RunRequest {
+	req = proto.Clone(req).(*sdkv1.RunRequest)
+	switch d := req.Directive.(type) {
+	case *sdkv1.RunRequest_Input:
+		d.Input = nil
+	case *sdkv1.RunRequest_PollResult:
+		d.PollResult.State = nil
+		for _, result := range d.PollResult.Results {
+			result.Output = nil
+		}
+	}
+	return req
+}
+
+// redactResponse returns a copy of the response without the function output,
+// the coroutine state and the inputs of the calls it makes.
+func redactResponse(res *sdkv1.RunResponse) *sdkv1.RunResponse {
+	if res == nil {
+		return nil
+	}
+	res = proto.Clone(res).(*sdkv1.RunResponse)
+	switch d := res.Directive.(type) {
+	case *sdkv1.RunResponse_Exit:
+		if d.Exit.Result != nil {
+			d.Exit.Result.Output = nil
+		}
+		if d.Exit.TailCall != nil {
+			d.Exit.TailCall.Input = nil
+		}
+	case *sdkv1.RunResponse_Poll:
+		d.Poll.State = nil
+		for _, call := range d.Poll.Calls {
+			call.Input = nil
+		}
+	}
+	return res
+}
+
+// writeSessionExport writes the function calls of the session to a JSON
+// file at path.
+func writeSessionExport(path string, calls *callStore, sessionID string, now time.Time, redact bool) error {
+	export, err := calls.export(sessionID, now, redact)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(export)
+	})
+}
+
+// readSessionExport reads a session bundle written by writeSessionExport.
+func readSessionExport(path string) (*sessionExport, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var export sessionExport
+	if err := json.Unmarshal(b, &export); err != nil {
+		return nil, fmt.Errorf("invalid session export %s: %w", path, err)
+	}
+	if export.Version > sessionExportVersion {
+		return nil, fmt.Errorf("unsupported session export version %d (latest: %d)", export.Version, sessionExportVersion)
+	}
+	return &export, nil
+}
+
+// calls returns the function calls of the session bundle, in a form that
+// the TUI can render.
+func (e *sessionExport) calls() (*callStore, error) {
+	s := &callStore{
+		roots:        make(map[DispatchID]struct{}, len(e.Roots)),
+		orderedRoots: e.Roots,
+		calls:        make(map[DispatchID]functionCall, len(e.Calls)),
+		lastFailed:   e.LastFailed,
+	}
+	for _, id := range e.Roots {
+		s.roots[id] = struct{}{}
+	}
+	for id, call := range e.Calls {
+		n := functionCall{
+			lastFunction:    call.Function,
+			tailCalls:       call.TailCalls,
+			failures:        call.Failures,
+			polls:           call.Polls,
+			spawnedCalls:    call.SpawnedCalls,
+			running:         call.Running,
+			suspended:       call.Suspended,
+			done:            call.Done,
+			creationTime:    call.CreationTime,
+			expirationTime:  call.ExpirationTime,
+			doneTime:        call.DoneTime,
+			orderedChildren: call.Children,
+		}
+		if call.Status != "" {
+			status, ok := sdkv1.Status_value[call.Status]
+			if !ok {
+				return nil, fmt.Errorf("function call %s has an invalid status: %s", id, call.Status)
+			}
+			n.lastStatus = sdkv1.Status(status)
+		}
+		if call.Error != "" {
+			n.lastError = errors.New(call.Error)
+		}
+		if len(call.Children) > 0 {
+			n.children = make(map[DispatchID]struct{}, len(call.Children))
+			for _, child := range call.Children {
+				n.children[child] = struct{}{}
+			}
+		}
+		for _, exported := range call.Timeline {
+			rt, err := exported.roundtrip()
+			if err != nil {
+				return nil, fmt.Errorf("function call %s has %w", id, err)
+			}
+			n.timeline = append(n.timeline, rt)
+		}
+		for _, exported := range call.PreviousTimeline {
+			rt, err := exported.roundtrip()
+			if err != nil {
+				return nil, fmt.Errorf("function call %s has %w", id, err)
+			}
+			n.previousTimeline = append(n.previousTimeline, rt)
+		}
+		s.calls[id] = n
+	}
+	return s, nil
+}
+
+// tui returns a read-only TUI that displays the function calls of the
+// session bundle. The durations of the calls that were in-flight are
+// rendered as of the time of the export.
+func (e *sessionExport) tui() (*TUI, error) {
+	calls, err := e.calls()
+	if err != nil {
+		return nil, err
+	}
+	t := &TUI{store: calls, readOnly: true}
+	if exportedAt := e.ExportedAt; !exportedAt.IsZero() {
+		t.clock = func() time.Time { return exportedAt }
+	}
+	return t, nil
+}
+
+func (exported *exportedRoundtrip) roundtrip() (*roundtrip, error) {
+	rt := &roundtrip{
+		request: runRequest{ts: exported.RequestTime, proto: &sdkv1.RunRequest{}, input: exported.Input},
+		response: runResponse{
+			ts:         exported.ResponseTime,
+			httpStatus: exported.HTTPStatus,
+			output:     exported.Output,
+		},
+	}
+	if err := proto.Unmarshal(exported.Request, rt.request.proto); err != nil {
+		return nil, fmt.Errorf("an invalid request: %w", err)
+	}
+	if exported.Response != nil {
+		rt.response.proto = &sdkv1.RunResponse{}
+		if err := proto.Unmarshal(exported.Response, rt.response.proto); err != nil {
+			return nil, fmt.Errorf("an invalid response: %w", err)
+		}
+	}
+	if exported.Error != "" {
+		rt.response.err = errors.New(exported.Error)
+	}
+	return rt, nil
+}
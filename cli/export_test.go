@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func exportTestSession(now time.Time) *callStore {
+	calls := &callStore{}
+
+	parent := &sdkv1.RunRequest{
+		Function:       "parent",
+		DispatchId:     "1",
+		RootDispatchId: "1",
+		Directive:      &sdkv1.RunRequest_Input{Input: asAny(wrapperspb.String("secret input"))},
+	}
+	child := &sdkv1.RunRequest{
+		Function:         "child",
+		DispatchId:       "2",
+		RootDispatchId:   "1",
+		ParentDispatchId: "1",
+		Directive:        &sdkv1.RunRequest_Input{Input: asAny(wrapperspb.Int64(42))},
+	}
+	calls.ObserveRequest(now, parent)
+	calls.ObserveRequest(now.Add(time.Second), child)
+	calls.ObserveResponse(now.Add(2*time.Second), child, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_TEMPORARY_ERROR,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{Result: &sdkv1.CallResult{Error: &sdkv1.Error{Type: "ValueError", Message: "oops"}}}},
+	})
+	calls.ObserveRequest(now.Add(3*time.Second), child)
+	calls.ObserveResponse(now.Add(4*time.Second), child, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{Result: &sdkv1.CallResult{Output: asAny(wrapperspb.String("secret output"))}}},
+	})
+	return calls
+}
+
+func TestSessionExport(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	calls := exportTestSession(now)
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := writeSessionExport(path, calls, "session", now, false); err != nil {
+		t.Fatal(err)
+	}
+	export, err := readSessionExport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sessionExportVersion, export.Version)
+	assert.Equal(t, "session", export.SessionID)
+
+	imported, err := export.calls()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The imported function calls render the same way as the originals.
+	later := now.Add(time.Minute)
+	original, restored := &TUI{store: calls}, &TUI{store: imported}
+	assert.Equal(t, original.functionsTable(later, false), restored.functionsTable(later, false))
+	for _, id := range []DispatchID{"1", "2"} {
+		assert.Equal(t, renderDetail(id, calls.calls[id], later), renderDetail(id, imported.calls[id], later))
+		assert.Equal(t, renderRawDetail(calls.calls[id]), renderRawDetail(imported.calls[id]))
+	}
+	assert.Equal(t, calls.orderedRoots, imported.orderedRoots)
+	assert.Equal(t, calls.roots, imported.roots)
+	assert.Equal(t, calls.calls["1"].children, imported.calls["1"].children)
+	assert.Equal(t, 1, imported.calls["2"].failures)
+	assert.True(t, imported.calls["2"].done)
+}
+
+func TestSessionExportRedact(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	calls := exportTestSession(now)
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := writeSessionExport(path, calls, "session", now, true); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(b), "secret")
+
+	export, err := readSessionExport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := export.calls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	detail := renderDetail("2", imported.calls["2"], now)
+	assert.Regexp(t, `Input: <redacted>`, detail)
+	assert.Regexp(t, `Output: <redacted>`, detail)
+	assert.Regexp(t, `Error: ValueError: oops`, detail)
+
+	// The original function calls are left untouched.
+	assert.Contains(t, renderDetail("2", calls.calls["2"], now), "Output: \"secret output\"")
+}
+
+func TestReadSessionExportErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := readSessionExport(filepath.Join(dir, "missing.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	path := filepath.Join(dir, "invalid.json")
+	os.WriteFile(path, []byte("{"), 0600)
+	_, err = readSessionExport(path)
+	assert.ErrorContains(t, err, "invalid session export")
+
+	path = filepath.Join(dir, "future.json")
+	os.WriteFile(path, []byte(`{"version": 2}`), 0600)
+	_, err = readSessionExport(path)
+	assert.EqualError(t, err, "unsupported session export version 2 (latest: 1)")
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeBridge is an in-process implementation of the bridge protocol, used
+// with --fake-bridge to exercise `dispatch run` without a Dispatch backend.
+// It serves a scripted sequence of requests, each once, and records the
+// responses of the local application.
+type fakeBridge struct {
+	// idleDelay is the time a poll waits for a request when the script
+	// is exhausted, like a long poll to the bridge would.
+	idleDelay time.Duration
+
+	mu        sync.Mutex
+	requests  []*sdkv1.RunRequest
+	next      int
+	responses map[string]*sdkv1.RunResponse
+	cleanups  []string
+
+	wg sync.WaitGroup
+}
+
+func newFakeBridge(requests []*sdkv1.RunRequest) *fakeBridge {
+	return &fakeBridge{
+		idleDelay: time.Second,
+		requests:  requests,
+		responses: map[string]*sdkv1.RunResponse{},
+	}
+}
+
+// readFakeBridgeScript reads the requests served by the fake bridge from a
+// file with one RunRequest per line, in the JSON encoding of protobuf.
+// Empty lines and lines starting with # are ignored.
+func readFakeBridgeScript(path string) ([]*sdkv1.RunRequest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --fake-bridge script: %w", err)
+	}
+	var requests []*sdkv1.RunRequest
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		req := &sdkv1.RunRequest{}
+		if err := protojson.Unmarshal(line, req); err != nil {
+			return nil, fmt.Errorf("invalid --fake-bridge script %s, line %d: %v", path, i+1, err)
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// start serves the fake bridge on a local address until the context is
+// canceled. It returns the URL of the fake bridge.
+func (b *fakeBridge) start(ctx context.Context) (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start the fake bridge: %v", err)
+	}
+	// Requests are canceled with the context, so that shutting down
+	// doesn't wait for pending polls.
+	server := &http.Server{
+		Handler:     b,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go server.Serve(l)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	return "http://" + l.Addr().String(), nil
+}
+
+// wait blocks until the fake bridge has stopped, after the context passed
+// to start was canceled, and its requests have been served.
+func (b *fakeBridge) wait() {
+	b.wg.Wait()
+}
+
+func (b *fakeBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "HEAD":
+		// Sessions are created on demand.
+	case "GET":
+		b.serveRequest(w, r)
+	case "POST":
+		b.receiveResponse(w, r)
+	case "DELETE":
+		requestID := r.Header.Get("X-Request-Id")
+		slog.Info("fake bridge: request was cleaned up", "request_id", requestID)
+		b.mu.Lock()
+		b.cleanups = append(b.cleanups, requestID)
+		b.mu.Unlock()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (b *fakeBridge) serveRequest(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	var req *sdkv1.RunRequest
+	var requestID string
+	if b.next < len(b.requests) {
+		req = b.requests[b.next]
+		b.next++
+		requestID = "fake-" + strconv.Itoa(b.next)
+	}
+	b.mu.Unlock()
+
+	if req == nil {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(b.idleDelay):
+		}
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+
+	body, err := proto.Marshal(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	endpointReq, err := http.NewRequest("POST", "http://localhost/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	endpointReq.Header.Set("Content-Type", "application/proto")
+
+	w.Header().Set("X-Request-Id", requestID)
+	endpointReq.Write(w)
+}
+
+func (b *fakeBridge) receiveResponse(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get("X-Request-Id")
+	res, err := b.readResponse(r.Body)
+	if err != nil {
+		slog.Warn("fake bridge: invalid response", "request_id", requestID, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if res == nil {
+		// The local application failed to handle the request, e.g.
+		// because the function doesn't exist.
+		slog.Info("fake bridge: received error response", "request_id", requestID)
+	} else {
+		slog.Info("fake bridge: received response", "request_id", requestID, "status", statusString(res.Status))
+	}
+
+	b.mu.Lock()
+	b.responses[requestID] = res
+	b.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// readResponse reads the response of the local application, which invoke
+// forwards in its HTTP/1.1 wire format. It returns nil if the response
+// isn't a RunResponse.
+func (b *fakeBridge) readResponse(r io.Reader) (*sdkv1.RunResponse, error) {
+	endpointRes, err := http.ReadResponse(bufio.NewReader(r), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer endpointRes.Body.Close()
+
+	body, err := io.ReadAll(endpointRes.Body)
+	if err != nil {
+		return nil, err
+	}
+	if endpointRes.StatusCode != http.StatusOK || endpointRes.Header.Get("Content-Type") != "application/proto" {
+		return nil, nil
+	}
+	body, err = decodeBody(endpointRes.Header, body)
+	if err != nil {
+		return nil, err
+	}
+	res := &sdkv1.RunResponse{}
+	if err := proto.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newEchoEndpoint creates a local application which echoes the input of
+// the function calls.
+func newEchoEndpoint() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var req sdkv1.RunRequest
+		if err := proto.Unmarshal(b, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, _ = proto.Marshal(&sdkv1.RunResponse{
+			Status: sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{
+				Result: &sdkv1.CallResult{Output: req.GetInput()},
+			}},
+		})
+		w.Header().Set("Content-Type", "application/proto")
+		w.Write(b)
+	}))
+}
+
+// writeFakeBridgeScript writes a script with a call to the echo function
+// for each input, and returns its path.
+func writeFakeBridgeScript(t *testing.T, inputs ...string) string {
+	var script strings.Builder
+	script.WriteString("# Function calls served by the fake bridge.\n")
+	for _, input := range inputs {
+		in, _ := anypb.New(wrapperspb.String(input))
+		b, err := protojson.Marshal(&sdkv1.RunRequest{
+			Function:       "echo",
+			DispatchId:     input,
+			RootDispatchId: input,
+			Directive:      &sdkv1.RunRequest_Input{Input: in},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		script.Write(b)
+		script.WriteString("\n\n")
+	}
+	path := filepath.Join(t.TempDir(), "script.jsonl")
+	if err := os.WriteFile(path, []byte(script.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFakeBridge(t *testing.T) {
+	endpoint := newEchoEndpoint()
+	defer endpoint.Close()
+
+	// Do not use t.Parallel() here as we are manipulating the local endpoint!
+	localEndpoint := LocalEndpoint
+	t.Cleanup(func() { LocalEndpoint = localEndpoint })
+	LocalEndpoint = endpoint.Listener.Addr().String()
+
+	path := writeFakeBridgeScript(t, "hello", "world")
+
+	requests, err := readFakeBridgeScript(path)
+	if !assert.NoError(t, err) || !assert.Len(t, requests, 2) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bridge := newFakeBridge(requests)
+	bridge.idleDelay = 10 * time.Millisecond
+	url, err := bridge.start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionURL := url + "/sessions/test"
+	assert.NoError(t, probeBridge(ctx, http.DefaultClient, sessionURL))
+
+	cleaner := newRequestCleaner(http.DefaultClient, sessionURL, 5*time.Second, 1)
+	defer cleaner.cancel()
+
+	calls := &callStore{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pollLoop(ctx, http.DefaultClient, sessionURL, nil, func(error) {}, func(requestID string, res *http.Response) {
+			handleRequest(ctx, http.DefaultClient, sessionURL, requestID, res, calls, cleaner)
+		})
+	}()
+
+	assert.Eventually(t, func() bool {
+		bridge.mu.Lock()
+		defer bridge.mu.Unlock()
+		return len(bridge.responses) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	bridge.wait()
+
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	for requestID, output := range map[string]string{"fake-1": `"hello"`, "fake-2": `"world"`} {
+		res := bridge.responses[requestID]
+		if assert.NotNil(t, res, requestID) {
+			assert.Equal(t, sdkv1.Status_STATUS_OK, res.Status)
+			assert.Equal(t, output, anyString(res.GetExit().GetResult().GetOutput()))
+		}
+	}
+	assert.Empty(t, bridge.cleanups)
+}
+
+func TestRunCommandWithFakeBridge(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep is not available on Windows")
+	}
+	t.Parallel()
+
+	// Nothing listens on the endpoint, so the request served by the fake
+	// bridge is cleaned up.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := l.Addr().String()
+	l.Close()
+
+	path := writeFakeBridgeScript(t, "hello")
+	buff, err := execRunCommand(&[]string{}, "run", "--fake-bridge", path, "--endpoint", endpoint, "--", "sleep", "2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Contains(t, buff.String(), "serving requests from the fake bridge")
+	assert.Regexp(t, `fake bridge: request was cleaned up.*request_id=fake-1`, buff.String())
+}
+
+func TestReadFakeBridgeScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.jsonl")
+	if err := os.WriteFile(path, []byte("{\"function\": \"echo\"}\nnot json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readFakeBridgeScript(path)
+	assert.ErrorContains(t, err, "line 2")
+}
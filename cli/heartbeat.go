@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// sessionStats tracks the activity of a session. When the TUI is disabled,
+// the stats are logged periodically so that there is an indication that the
+// session is healthy, even when no function is called.
+type sessionStats struct {
+	// Number of polls that returned a function call.
+	successfulPolls atomic.Int64
+	lastPoll        atomic.Int64 // unix nanoseconds
+	inFlight        atomic.Int64
+}
+
+// polled records a successful poll, which may or may not have returned a
+// function call.
+func (s *sessionStats) polled(now time.Time) {
+	s.lastPoll.Store(now.UnixNano())
+}
+
+func (s *sessionStats) logHeartbeat(logger *slog.Logger, now time.Time) {
+	lastPoll := "never"
+	if t := s.lastPoll.Load(); t != 0 {
+		lastPoll = now.Sub(time.Unix(0, t)).Round(time.Second).String() + " ago"
+	}
+	logger.Info("session is healthy",
+		"successful_polls", s.successfulPolls.Load(),
+		"in_flight", s.inFlight.Load(),
+		"last_poll", lastPoll)
+}
+
+// logHeartbeats logs the session stats at each interval until the context
+// is canceled.
+func logHeartbeats(ctx context.Context, logger *slog.Logger, interval time.Duration, stats *sessionStats) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stats.logHeartbeat(logger, now)
+		}
+	}
+}
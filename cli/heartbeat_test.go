@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogHeartbeats(t *testing.T) {
+	var stats sessionStats
+	stats.polled(time.Now())
+	stats.successfulPolls.Add(2)
+	stats.inFlight.Add(1)
+
+	logs := &syncBuffer{}
+	logger := slog.New(slog.NewTextHandler(logs, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		logHeartbeats(ctx, logger, 10*time.Millisecond, &stats)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return strings.Count(logs.String(), "session is healthy") >= 2
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	line, _, _ := strings.Cut(logs.String(), "\n")
+	assert.Contains(t, line, "level=INFO")
+	assert.Contains(t, line, "successful_polls=2")
+	assert.Contains(t, line, "in_flight=1")
+	assert.Regexp(t, `last_poll="\d+s ago"`, line)
+}
+
+func TestLogHeartbeatNeverPolled(t *testing.T) {
+	var logs bytes.Buffer
+	var stats sessionStats
+	stats.logHeartbeat(slog.New(slog.NewTextHandler(&logs, nil)), time.Now())
+	assert.Contains(t, logs.String(), "successful_polls=0 in_flight=0 last_poll=never")
+}
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
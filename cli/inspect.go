@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+)
+
+// callInspector is a FunctionCallObserver that prints the details of a
+// single function call once it completes. It's used instead of the TUI
+// when running headless, e.g. in CI.
+type callInspector struct {
+	id  DispatchID
+	out io.Writer
+
+	// The function calls of the session, from which the details of the
+	// inspected function call are rendered.
+	calls *callStore
+
+	mu      sync.Mutex
+	printed bool
+}
+
+func (c *callInspector) ObserveRequest(now time.Time, req *sdkv1.RunRequest) {
+	c.calls.ObserveRequest(now, req)
+}
+
+func (c *callInspector) ObserveResponse(now time.Time, req *sdkv1.RunRequest, err error, httpRes *http.Response, res *sdkv1.RunResponse) {
+	c.calls.ObserveResponse(now, req, err, httpRes, res)
+
+	if DispatchID(req.DispatchId) != c.id {
+		return
+	}
+
+	c.calls.mu.Lock()
+	n := c.calls.calls[c.id]
+	c.calls.mu.Unlock()
+	if !n.done {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.printed {
+		c.printed = true
+		_, _ = io.WriteString(c.out, renderDetail(c.id, n, now))
+	}
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// configLockTimeout is how long commands wait for another dispatch
+	// process to release the configuration lock.
+	configLockTimeout = 5 * time.Second
+
+	// configLockStaleAge is the age after which a lock file is assumed to
+	// have been left behind by a process that crashed, and is removed.
+	configLockStaleAge = time.Minute
+
+	configLockRetryInterval = 10 * time.Millisecond
+)
+
+// lockConfig acquires an advisory lock on the configuration file at path,
+// so that concurrent dispatch processes don't clobber each other's changes
+// when reading, modifying and writing the file. The lock is a .lock file
+// created next to the configuration file.
+//
+// If the lock cannot be acquired within the timeout, an error is returned.
+// The returned function releases the lock.
+func lockConfig(path string, timeout time.Duration) (unlock func(), err error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(timeout)
+
+	for {
+		fh, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fmt.Fprintf(fh, "%d\n", os.Getpid())
+			fh.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			if errors.Is(err, os.ErrNotExist) {
+				// The configuration directory doesn't exist yet, so
+				// there is nothing to protect.
+				return func() {}, nil
+			}
+			return nil, fmt.Errorf("failed to lock configuration file %s: %w", path, err)
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > configLockStaleAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the configuration lock %s. If no other dispatch command is running, remove the file and try again", lockPath)
+		}
+		time.Sleep(configLockRetryInterval)
+	}
+}
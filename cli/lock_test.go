@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockConfigConcurrentWriters(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := CreateConfig(path, &Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			unlock, err := lockConfig(path, configLockTimeout)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer unlock()
+
+			config, err := LoadConfig(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if config.Organization == nil {
+				config.Organization = map[string]Organization{}
+			}
+			name := fmt.Sprintf("org%d", i)
+			config.Organization[name] = Organization{APIKey: name}
+			config.Active = name
+			errs <- CreateConfig(path, config)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, config.Organization, writers, "updates were lost")
+	assert.NoError(t, config.Validate())
+
+	_, err = os.Stat(path + ".lock")
+	assert.ErrorIs(t, err, os.ErrNotExist, "lock was not released")
+}
+
+func TestLockConfigTimeout(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	unlock, err := lockConfig(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lockConfig(path, 50*time.Millisecond)
+	assert.ErrorContains(t, err, "timed out waiting for the configuration lock")
+
+	unlock()
+	unlock, err = lockConfig(path, 0)
+	if assert.NoError(t, err) {
+		unlock()
+	}
+}
+
+func TestLockConfigStale(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path+".lock", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * configLockStaleAge)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockConfig(path, 0)
+	if assert.NoError(t, err) {
+		unlock()
+	}
+}
@@ -3,9 +3,11 @@ package cli
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"slices"
+	"strings"
 	"sync"
 
 	"github.com/charmbracelet/lipgloss"
@@ -22,19 +24,44 @@ var (
 	logErrorStyle = lipgloss.NewStyle().Foreground(redColor)
 )
 
+// logTimeFormat is the format of the timestamps of log lines.
+const logTimeFormat = "2006-01-02 15:04:05.000"
+
+// logLevel is the minimum level of the records logged by the CLI. It's
+// configured by the --log-level and --verbose options, and can be lowered
+// at runtime from the TUI.
+var logLevel slog.LevelVar
+
+func parseLogLevel(s string) (slog.Level, error) {
+	switch s {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid log level: %q (must be one of debug, info, warn or error)", s)
+	}
+}
+
 type slogHandler struct {
 	mu     sync.Mutex
 	stream io.Writer
+	level  slog.Leveler
 
 	parent *slogHandler
 	attrs  []slog.Attr
 }
 
 func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
-	if Verbose {
-		return level >= slog.LevelDebug
+	minLevel := slog.LevelInfo
+	if h.level != nil {
+		minLevel = h.level.Level()
 	}
-	return level >= slog.LevelInfo
+	return level >= minLevel
 }
 
 func (h *slogHandler) Handle(ctx context.Context, record slog.Record) error {
@@ -42,7 +69,7 @@ func (h *slogHandler) Handle(ctx context.Context, record slog.Record) error {
 	defer h.mu.Unlock()
 
 	var b bytes.Buffer
-	b.WriteString(logTimeStyle.Render(record.Time.Format("2006-01-02 15:04:05.000")))
+	b.WriteString(logTimeStyle.Render(record.Time.Format(logTimeFormat)))
 	if record.Level >= slog.LevelWarn {
 		b.WriteByte(' ')
 		b.WriteString(levelString(record.Level))
@@ -79,9 +106,65 @@ func levelString(level slog.Level) string {
 	}
 }
 
+// Attribute keys whose values are redacted when logged, in lowercase.
+var (
+	redactedAttrKeysMu sync.RWMutex
+	redactedAttrKeys   = map[string]struct{}{
+		"password": {},
+		"secret":   {},
+		"token":    {},
+		"api_key":  {},
+	}
+)
+
+// redactAttrKeys registers additional attribute keys whose values must be
+// redacted. Keys are case-insensitive.
+func redactAttrKeys(keys ...string) {
+	redactedAttrKeysMu.Lock()
+	defer redactedAttrKeysMu.Unlock()
+
+	for _, key := range keys {
+		redactedAttrKeys[strings.ToLower(key)] = struct{}{}
+	}
+}
+
+func isRedactedAttrKey(key string) bool {
+	redactedAttrKeysMu.RLock()
+	defer redactedAttrKeysMu.RUnlock()
+
+	_, ok := redactedAttrKeys[strings.ToLower(key)]
+	return ok
+}
+
 func writeAttr(b *bytes.Buffer, attr slog.Attr) {
-	b.WriteString(logAttrKeyStyle.Render(attr.Key + "="))
-	b.WriteString(logAttrValStyle.Render(attr.Value.String()))
+	writeAttrWithPrefix(b, "", attr)
+}
+
+// writeAttrWithPrefix writes the attribute, qualifying its key with the
+// prefix. The attributes of groups are written one by one with the group
+// key as prefix, e.g. "request.token", so that their values are redacted
+// like top-level attributes.
+func writeAttrWithPrefix(b *bytes.Buffer, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for i, groupAttr := range attr.Value.Group() {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeAttrWithPrefix(b, prefix, groupAttr)
+		}
+		return
+	}
+
+	b.WriteString(logAttrKeyStyle.Render(prefix + attr.Key + "="))
+	if isRedactedAttrKey(attr.Key) {
+		b.WriteString(logAttrValStyle.Render("***"))
+	} else {
+		b.WriteString(logAttrValStyle.Render(attr.Value.String()))
+	}
 }
 
 func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
@@ -91,6 +174,7 @@ func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	}
 	return &slogHandler{
 		stream: h.stream,
+		level:  h.level,
 		parent: parent,
 		attrs:  append(slices.Clip(parent.attrs), attrs...),
 	}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for s, level := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		got, err := parseLogLevel(s)
+		assert.NoError(t, err)
+		assert.Equal(t, level, got)
+	}
+
+	_, err := parseLogLevel("verbose")
+	assert.EqualError(t, err, `invalid log level: "verbose" (must be one of debug, info, warn or error)`)
+}
+
+func TestSlogHandlerLevel(t *testing.T) {
+	var b bytes.Buffer
+	logger := slog.New(&slogHandler{stream: &b, level: slog.LevelWarn})
+
+	logger.Info("info message")
+	logger.With("key", "value").Info("info message with attrs")
+	logger.Warn("warn message")
+
+	assert.NotContains(t, b.String(), "info message")
+	assert.Contains(t, b.String(), "warn message")
+}
+
+func TestSlogHandlerEnabled(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the Verbose global!
+	verbose := Verbose
+	Verbose = true
+	t.Cleanup(func() { Verbose = verbose })
+
+	ctx := context.Background()
+
+	h := &slogHandler{level: slog.LevelWarn}
+	assert.False(t, h.Enabled(ctx, slog.LevelDebug))
+	assert.False(t, h.Enabled(ctx, slog.LevelInfo))
+	assert.True(t, h.Enabled(ctx, slog.LevelWarn))
+	assert.True(t, h.Enabled(ctx, slog.LevelError))
+
+	child := h.WithAttrs([]slog.Attr{slog.String("key", "value")})
+	assert.False(t, child.Enabled(ctx, slog.LevelInfo))
+	assert.True(t, child.Enabled(ctx, slog.LevelWarn))
+
+	// Handlers without a level default to info.
+	h = &slogHandler{}
+	assert.False(t, h.Enabled(ctx, slog.LevelDebug))
+	assert.True(t, h.Enabled(ctx, slog.LevelInfo))
+}
+
+func TestSlogHandlerRedaction(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+
+	var b bytes.Buffer
+	logger := slog.New(&slogHandler{stream: &b})
+
+	logger.Info("default keys", "password", "hunter2", "API_KEY", "00000000", "user", "morty")
+	assert.Contains(t, b.String(), "password=*** API_KEY=*** user=morty\n")
+
+	redactAttrKeys("session_cookie")
+	logger.With("session_cookie", "chocolate-chip").Info("registered keys")
+	assert.Contains(t, b.String(), "registered keys session_cookie=***\n")
+	assert.NotContains(t, b.String(), "hunter2")
+	assert.NotContains(t, b.String(), "00000000")
+	assert.NotContains(t, b.String(), "chocolate-chip")
+
+	// Keys nested in groups are redacted as well.
+	logger.Info("grouped keys", slog.Group("auth", "user", "morty", slog.Group("oauth", "token", "portal-gun")))
+	assert.Contains(t, b.String(), "grouped keys auth.user=morty auth.oauth.token=***\n")
+	assert.NotContains(t, b.String(), "portal-gun")
+}
@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/muesli/ansi"
+)
+
+// plainTextWriter is an io.Writer that removes the ANSI escape sequences
+// (colors and text styles) from the text written to the underlying writer,
+// e.g. so that log files are readable without a terminal. Escape sequences
+// must not be split across writes.
+type plainTextWriter struct{ w io.Writer }
+
+func (p plainTextWriter) Write(b []byte) (int, error) {
+	if bytes.IndexByte(b, ansi.Marker) < 0 {
+		return p.w.Write(b)
+	}
+	if _, err := io.WriteString(p.w, clearANSI(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// rotatingFile is an io.Writer that writes to a file, rolling it over
+// once it reaches a maximum size. Rotated files are renamed with a numeric
+// suffix (path.1 being the most recent), and only the most recent ones are
+// kept.
+//
+// Writes are never split across files, and it's safe to write to the file
+// concurrently. Files are only readable by the current user, since logs
+// may contain sensitive information.
+type rotatingFile struct {
+	path     string
+	maxSize  int64 // 0 for no rotation
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return 0, err
+			}
+			// The file could not be rotated, but it could be reopened, so
+			// the write goes to the current file past its maximum size.
+			// The rotation is attempted again on the next write. The error
+			// isn't logged, since the logs are written to this file.
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate rolls the file over. If the rotation fails, the writes keep going
+// to the current file, so that the log file isn't disabled for the rest of
+// the session.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if err := f.shift(); err != nil {
+		if reopenErr := f.open(os.O_APPEND); reopenErr != nil {
+			return reopenErr
+		}
+		return err
+	}
+	return f.open(os.O_TRUNC)
+}
+
+// shift renames path.N-1 to path.N, ..., path to path.1. The oldest file
+// is overwritten by the rename.
+func (f *rotatingFile) shift() error {
+	for i := f.maxFiles; i > 0; i-- {
+		src := f.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", f.path, i-1)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", f.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *rotatingFile) open(flag int) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|flag, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
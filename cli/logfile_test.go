@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("Rotate past the size limit", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dispatch.log")
+		f, err := openRotatingFile(path, 10, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+			if _, err := f.Write([]byte(line)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		assertFileContent(t, path, "line 4\n")
+		assertFileContent(t, path+".1", "line 3\n")
+		assertFileContent(t, path+".2", "line 2\n")
+		assert.NoFileExists(t, path+".3")
+
+		if runtime.GOOS != "windows" {
+			for _, p := range []string{path, path + ".1"} {
+				info, err := os.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), p)
+			}
+		}
+	})
+
+	t.Run("Append to an existing file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dispatch.log")
+		if err := os.WriteFile(path, []byte("line 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := openRotatingFile(path, 10, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if _, err := f.Write([]byte("line 2\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		assertFileContent(t, path, "line 2\n")
+		assertFileContent(t, path+".1", "line 1\n")
+	})
+
+	t.Run("Keep writing when the rotation fails", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dispatch.log")
+		f, err := openRotatingFile(path, 10, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		// The file can't be renamed over a non-empty directory.
+		if err := os.MkdirAll(filepath.Join(path+".1", "dir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.Write([]byte("line 1\n")); err != nil {
+			t.Fatal(err)
+		}
+		assert.Error(t, f.rotate())
+		for _, line := range []string{"line 2\n", "line 3\n"} {
+			if _, err := f.Write([]byte(line)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assertFileContent(t, path, "line 1\nline 2\nline 3\n")
+
+		// The file is rotated once the rename succeeds.
+		if err := os.RemoveAll(path + ".1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("line 4\n")); err != nil {
+			t.Fatal(err)
+		}
+		assertFileContent(t, path, "line 4\n")
+		assertFileContent(t, path+".1", "line 1\nline 2\nline 3\n")
+	})
+
+	t.Run("Concurrent writes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dispatch.log")
+		f, err := openRotatingFile(path, 100, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					_, _ = fmt.Fprintf(f, "goroutine %d line %d\n", i, j)
+				}
+			}(i)
+		}
+		wg.Wait()
+		f.Close()
+
+		// Each line must have been written whole to one of the files.
+		matches, err := filepath.Glob(path + "*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Greater(t, len(matches), 1)
+
+		var lines int
+		for _, match := range matches {
+			b, err := os.ReadFile(match)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.LessOrEqual(t, len(b), 100)
+			for _, line := range strings.SplitAfter(string(b), "\n") {
+				if line != "" {
+					assert.Regexp(t, "^goroutine [0-9] line [0-9]\n$", line)
+					lines++
+				}
+			}
+		}
+		assert.Equal(t, 100, lines)
+	})
+}
+
+func TestPlainTextWriter(t *testing.T) {
+	var b strings.Builder
+	w := plainTextWriter{&b}
+
+	line := "\x1b[38;5;105mdispatch\x1b[0m | \x1b[1mhello\x1b[0m\n"
+	n, err := w.Write([]byte(line))
+	assert.NoError(t, err)
+	assert.Equal(t, len(line), n)
+
+	n, err = w.Write([]byte("plain\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	assert.Equal(t, "dispatch | hello\nplain\n", b.String())
+}
+
+func assertFileContent(t *testing.T, path, content string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, content, string(b))
+}
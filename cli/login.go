@@ -1,9 +1,12 @@
 package cli
 
 import (
+	"bufio"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"runtime"
 
@@ -20,53 +23,118 @@ func loginCommand() *cobra.Command {
 The login command will open a browser window where you can create a Dispatch
 account or login to an existing account.
 
-After authenticating with Dispatch, the API key will be persisted locally.`,
+After authenticating with Dispatch, the API key will be persisted locally.
+
+In environments where a browser cannot be opened, such as CI, a login token
+provisioned by an external system can be passed with --token or the
+DISPATCH_LOGIN_TOKEN environment variable. The command then skips opening
+the browser and waits for the token to be confirmed.`,
 		GroupID: "management",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			token, err := generateToken()
-			if err != nil {
-				return err
-			}
+			_, err := runLoginFlow(cmd)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVarP(&LoginToken, "token", "", "", "Login token to use instead of opening the browser (env: DISPATCH_LOGIN_TOKEN)")
+	return cmd
+}
+
+// LoginToken is a login token provisioned outside of the CLI. When set, the
+// login flow polls for this token rather than generating one and opening
+// the browser.
+var LoginToken string
+
+// loginTokenLength is the length of the hex-encoded tokens created by
+// generateToken.
+const loginTokenLength = 64
+
+func validateLoginToken(token string) error {
+	if len(token) != loginTokenLength {
+		return fmt.Errorf("invalid login token: expected %d hexadecimal characters, got %d", loginTokenLength, len(token))
+	}
+	if _, err := hex.DecodeString(token); err != nil {
+		return fmt.Errorf("invalid login token: must only contain hexadecimal characters")
+	}
+	return nil
+}
+
+// runLoginFlow opens the browser for the user to sign in to Dispatch and
+// waits for the API keys to be persisted locally. It returns true if the
+// user logged in successfully.
+func runLoginFlow(cmd *cobra.Command) (bool, error) {
+	token := LoginToken
+	if token == "" {
+		token = os.Getenv("DISPATCH_LOGIN_TOKEN")
+	}
 
-			_ = open(fmt.Sprintf("%s/cli-login?token=%s", DispatchConsoleUrl, token))
+	if token != "" {
+		if err := validateLoginToken(token); err != nil {
+			return false, err
+		}
+	} else {
+		var err error
+		if token, err = generateToken(); err != nil {
+			return false, err
+		}
 
-			dialog(`Opening the browser for you to sign in to Dispatch.
+		_ = open(fmt.Sprintf("%s/cli-login?token=%s", DispatchConsoleUrl, token))
+
+		dialog(`Opening the browser for you to sign in to Dispatch.
 
 If the browser does not open, please visit the following URL:
 
 %s`, DispatchConsoleUrl+"/cli-login?token="+token)
+	}
 
-			console := &console{}
-
-			var loginErr error
-			var loggedIn bool
-
-			p := tea.NewProgram(newSpinnerModel("Logging in...", func() (tea.Msg, error) {
-				if err := console.Login(token); err != nil {
-					loginErr = err
-					return nil, err
-				}
-				loggedIn = true
-				return nil, nil
-			}))
-			if _, err = p.Run(); err != nil {
-				return err
-			}
-
-			if loginErr != nil {
-				failure(cmd, "Authentication failed. Please contact support at support@dispatch.run")
-				fmt.Printf("Error: %s\n", loginErr)
-			} else if loggedIn {
-				success("Authentication successful")
-				fmt.Printf(
-					"Configuration file created at %s\n",
-					DispatchConfigPath,
-				)
-			}
-			return nil
-		},
+	console := &console{}
+
+	var loginErr error
+	var loggedIn bool
+
+	if err := withSpinner(cmd.OutOrStdout(), "Logging in", func() (tea.Msg, error) {
+		if err := console.Login(token); err != nil {
+			loginErr = err
+			return nil, err
+		}
+		loggedIn = true
+		return nil, nil
+	}); err != nil {
+		return false, err
 	}
-	return cmd
+
+	if loginErr != nil {
+		failure(cmd, "Authentication failed. Please contact support at support@dispatch.run")
+		fmt.Printf("Error: %s\n", loginErr)
+	} else if loggedIn {
+		success("Authentication successful")
+		fmt.Printf(
+			"Configuration file created at %s\n",
+			DispatchConfigPath,
+		)
+	}
+	return loggedIn, nil
+}
+
+// relogin offers to run the login flow again when the API key read from
+// the configuration file is rejected, e.g. because it was revoked. It
+// returns true if the user logged in again and the operation can be
+// retried. When the session is not interactive, a hint is printed instead.
+func relogin(cmd *cobra.Command, err error) bool {
+	if !errors.As(err, &authError{}) || DispatchApiKeyLocation != "config" {
+		return false
+	}
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		simple(cmd, "Hint: the API key may have been revoked. Run `dispatch login` to login again.")
+		return false
+	}
+	if !confirm(bufio.NewReader(os.Stdin), cmd.OutOrStdout(), "The API key was rejected. Login to Dispatch again?") {
+		return false
+	}
+	if loggedIn, err := runLoginFlow(cmd); err != nil || !loggedIn {
+		return false
+	}
+	return runConfigFlow() == nil
 }
 
 func generateToken() (string, error) {
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelogin(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the API key location!
+	location := DispatchApiKeyLocation
+	t.Cleanup(func() { DispatchApiKeyLocation = location })
+
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer bridge.Close()
+
+	err := probeBridge(context.Background(), http.DefaultClient, bridge.URL+"/sessions/test")
+
+	t.Run("API key from configuration file", func(t *testing.T) {
+		DispatchApiKeyLocation = "config"
+
+		out := &bytes.Buffer{}
+		cmd := &cobra.Command{}
+		cmd.SetOut(out)
+
+		assert.False(t, relogin(cmd, err))
+		assert.Equal(t, "Hint: the API key may have been revoked. Run `dispatch login` to login again.\n", out.String())
+	})
+
+	t.Run("API key from environment variable", func(t *testing.T) {
+		DispatchApiKeyLocation = "env"
+
+		out := &bytes.Buffer{}
+		cmd := &cobra.Command{}
+		cmd.SetOut(out)
+
+		assert.False(t, relogin(cmd, err))
+		assert.Empty(t, out.String())
+	})
+}
+
+func TestLoginWithToken(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the console URL!
+	consoleUrl, configPath := DispatchConsoleUrl, DispatchConfigPath
+	t.Cleanup(func() {
+		DispatchConsoleUrl, DispatchConfigPath = consoleUrl, configPath
+		LoginToken = ""
+	})
+	t.Setenv("DISPATCH_LOGIN_TOKEN", "")
+
+	token := strings.Repeat("ab", 32)
+
+	var tokens []string
+	console := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/cli-login/token", r.URL.Path)
+		tokens = append(tokens, r.URL.Query().Get("token"))
+		w.Write([]byte(`{"organizations":[{"slug":"test","api_key":"test-key"}]}`))
+	}))
+	defer console.Close()
+
+	DispatchConsoleUrl = console.URL
+	DispatchConfigPath = filepath.Join(t.TempDir(), "config.toml")
+
+	cmd := loginCommand()
+	cmd.SetArgs([]string{"--token", token})
+	cmd.SetOut(&bytes.Buffer{})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{token}, tokens)
+
+	config, err := os.ReadFile(DispatchConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(config), "test-key")
+}
+
+func TestValidateLoginToken(t *testing.T) {
+	token, err := generateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, validateLoginToken(token))
+	assert.EqualError(t, validateLoginToken("abc"), "invalid login token: expected 64 hexadecimal characters, got 3")
+	assert.EqualError(t, validateLoginToken(strings.Repeat("z", 64)), "invalid login token: must only contain hexadecimal characters")
+}
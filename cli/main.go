@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -23,7 +24,25 @@ func createMainCommand() *cobra.Command {
 		Use:     "dispatch",
 		Long:    DispatchCmdLong,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			return loadEnvFromFile(DotEnvFilePath)
+			if err := validateErrorFormat(ErrorFormat); err != nil {
+				return err
+			}
+			if ErrorFormat == "json" {
+				// Errors are printed by execute instead, and the usage
+				// would get in the way of tools parsing the error.
+				cmd.Root().SilenceErrors = true
+				cmd.Root().SilenceUsage = true
+			}
+			if err := validateSpinnerStyle(SpinnerStyle); err != nil {
+				return err
+			}
+			// An empty key would otherwise be ignored in favor of the
+			// environment or the configuration, which is likely not what
+			// the user intended.
+			if cmd.Flags().Changed("api-key") && strings.TrimSpace(DispatchApiKeyCli) == "" {
+				return errEmptyAPIKey
+			}
+			return loadEnvFromFile(DotEnvFilePath, DotEnvStrict)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return cmd.Help()
@@ -31,7 +50,16 @@ func createMainCommand() *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVarP(&DispatchApiKeyCli, "api-key", "k", "", "Dispatch API key (env: DISPATCH_API_KEY)")
+	cmd.PersistentFlags().StringVarP(&DispatchProfileCli, "profile", "", "", "Configuration profile to use (env: DISPATCH_PROFILE)")
 	cmd.PersistentFlags().StringVarP(&DotEnvFilePath, "env-file", "", "", "Path to .env file")
+	cmd.PersistentFlags().BoolVarP(&DotEnvStrict, "env-strict", "", false, "Fail on malformed lines in the .env file instead of skipping them")
+	cmd.PersistentFlags().StringVarP(&DispatchApiUrlCli, "api-url", "", "", "Dispatch API URL (env: DISPATCH_API_URL)")
+	cmd.PersistentFlags().StringVarP(&DispatchBridgeUrlCli, "bridge-url", "", "", "Dispatch bridge URL (env: DISPATCH_BRIDGE_URL)")
+	cmd.PersistentFlags().StringVarP(&DispatchConsoleUrlCli, "console-url", "", "", "Dispatch console URL (env: DISPATCH_CONSOLE_URL)")
+	cmd.PersistentFlags().BoolVarP(&PlainDialogs, "plain-dialogs", "", false, "Print messages as plain text rather than in a box")
+	cmd.PersistentFlags().StringVarP(&ErrorFormat, "error-format", "", "text", "Format of the error printed when a command fails (text or json)")
+	cmd.PersistentFlags().StringVarP(&SpinnerStyle, "spinner-style", "", "dot", "Style of the spinner displayed while waiting (dot, line, minidot, points or none)")
+	cmd.PersistentFlags().StringVarP(&UserAgent, "user-agent", "", "", "User-Agent header of the requests sent by the CLI (default: dispatch-cli/<version> (<os>/<arch>))")
 
 	cmd.AddGroup(&cobra.Group{
 		ID:    "management",
@@ -45,8 +73,12 @@ func createMainCommand() *cobra.Command {
 	// Passing the global variables to the commands make testing in parallel possible.
 	cmd.AddCommand(loginCommand())
 	cmd.AddCommand(switchCommand(DispatchConfigPath))
+	cmd.AddCommand(profileCommand(DispatchConfigPath))
+	cmd.AddCommand(configCommand(DispatchConfigPath))
 	cmd.AddCommand(verificationCommand())
+	cmd.AddCommand(doctorCommand())
 	cmd.AddCommand(runCommand())
+	cmd.AddCommand(viewCommand())
 	cmd.AddCommand(versionCommand())
 
 	return cmd
@@ -54,5 +86,15 @@ func createMainCommand() *cobra.Command {
 
 // Main is the entry point of the command line.
 func Main() error {
-	return createMainCommand().ExecuteContext(context.Background())
+	return execute(context.Background(), createMainCommand())
+}
+
+// execute runs the command. With --error-format json, errors are printed
+// as JSON objects rather than by the command itself.
+func execute(ctx context.Context, cmd *cobra.Command) error {
+	c, err := cmd.ExecuteContextC(ctx)
+	if err != nil && cmd.SilenceErrors {
+		writeErrorJSON(c.ErrOrStderr(), err)
+	}
+	return err
 }
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	ProfileCmdLong = `Select the configuration profile.
+
+Profiles bundle an organization with the URLs of the Dispatch services,
+making it possible to switch between environments such as production and
+staging. They are defined in the [Profiles] table of the configuration file:
+
+  [Profiles.staging]
+  organization = 'my-org'
+  api_url = 'https://api.staging.example.com'
+
+Without arguments, the command lists the available profiles. The profile
+can also be selected for a single command with --profile or the
+DISPATCH_PROFILE environment variable. Use --none to deselect the profile
+and use the active organization instead.`
+)
+
+func profileCommand(configPath string) *cobra.Command {
+	var none bool
+	cmd := &cobra.Command{
+		Use:     "profile [name]",
+		Short:   "List or select configuration profiles",
+		Long:    ProfileCmdLong,
+		GroupID: "management",
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			unlock, err := lockConfig(configPath, configLockTimeout)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
+			cfg, err := LoadConfig(configPath)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					failure(cmd, fmt.Sprintf("Failed to load Dispatch configuration: %v", err))
+				}
+
+				// User must login to create a configuration file.
+				simple(cmd, "Please run `dispatch login` to login to Dispatch.")
+				return nil
+			}
+
+			if none {
+				if len(args) > 0 {
+					return fmt.Errorf("--none cannot be used with a profile name")
+				}
+				if cfg.ActiveProfile == "" {
+					simple(cmd, "No profile is selected")
+					return nil
+				}
+				simple(cmd, fmt.Sprintf("Deselected profile: %v", cfg.ActiveProfile))
+				cfg.ActiveProfile = ""
+				return CreateConfig(configPath, cfg)
+			}
+
+			if len(args) == 0 {
+				listProfiles(cmd, cfg)
+				return nil
+			}
+
+			name := args[0]
+			if _, ok := cfg.Profiles[name]; !ok {
+				failure(cmd, fmt.Sprintf("Profile '%s' not found", name))
+				listProfiles(cmd, cfg)
+				return nil
+			}
+
+			simple(cmd, fmt.Sprintf("Switched to profile: %v", name))
+			cfg.ActiveProfile = name
+			return CreateConfig(configPath, cfg)
+		},
+	}
+	cmd.Flags().BoolVarP(&none, "none", "", false, "Deselect the active profile")
+	return cmd
+}
+
+func listProfiles(cmd *cobra.Command, cfg *Config) {
+	if len(cfg.Profiles) == 0 {
+		simple(cmd, "No profiles defined. Run `dispatch config edit` to add profiles to the configuration.")
+		return
+	}
+
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	simple(cmd, "Available profiles:")
+	for _, name := range names {
+		line := fmt.Sprintf("- %s (%s)", name, cfg.Profiles[name].Organization)
+		if name == cfg.ActiveProfile {
+			line += " [active]"
+		}
+		simple(cmd, line)
+	}
+}
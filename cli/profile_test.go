@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeProfilesConfig(t *testing.T, path string) {
+	if err := CreateConfig(path, &Config{
+		Active: "prod-org",
+		Organization: map[string]Organization{
+			"prod-org":    {APIKey: "prod-key"},
+			"staging-org": {APIKey: "staging-key", APIURL: "https://api.staging-org.example.com"},
+		},
+		Profiles: map[string]Profile{
+			"staging": {
+				Organization: "staging-org",
+				APIURL:       "https://api.staging.example.com",
+				BridgeURL:    "https://bridge.staging.example.com",
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfileResolution(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the environment!
+	for _, k := range []string{"DISPATCH_API_KEY", "DISPATCH_API_URL", "DISPATCH_BRIDGE_URL", "DISPATCH_CONSOLE_URL", "DISPATCH_PROFILE"} {
+		t.Setenv(k, "")
+	}
+	apiKey, location := DispatchApiKey, DispatchApiKeyLocation
+	t.Cleanup(func() {
+		DispatchApiKey, DispatchApiKeyLocation = apiKey, location
+		DispatchProfileCli = ""
+		DispatchApiUrlCli = ""
+		setVariables()
+	})
+
+	t.Setenv("DISPATCH_CONFIG_PATH", filepath.Join(t.TempDir(), "config.toml"))
+	setVariables()
+	writeProfilesConfig(t, DispatchConfigPath)
+
+	t.Run("No profile", func(t *testing.T) {
+		setVariables()
+		if err := runConfigFlow(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "prod-key", DispatchApiKey)
+		assert.Equal(t, "https://api.dispatch.run", DispatchApiUrl)
+	})
+
+	t.Run("Profile from the environment", func(t *testing.T) {
+		t.Setenv("DISPATCH_PROFILE", "staging")
+
+		setVariables()
+		if err := runConfigFlow(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "staging-key", DispatchApiKey)
+		assert.Equal(t, "https://api.staging.example.com", DispatchApiUrl)
+		assert.Equal(t, "https://bridge.staging.example.com", DispatchBridgeUrl)
+		assert.Equal(t, "https://console.dispatch.run", DispatchConsoleUrl)
+	})
+
+	t.Run("Flags have priority over the profile", func(t *testing.T) {
+		DispatchProfileCli = "staging"
+		DispatchApiUrlCli = "http://localhost:4001"
+		t.Cleanup(func() {
+			DispatchProfileCli = ""
+			DispatchApiUrlCli = ""
+		})
+
+		setVariables()
+		if err := runConfigFlow(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "staging-key", DispatchApiKey)
+		assert.Equal(t, "http://localhost:4001", DispatchApiUrl)
+		assert.Equal(t, "https://bridge.staging.example.com", DispatchBridgeUrl)
+	})
+
+	t.Run("API key from the environment has priority over the profile", func(t *testing.T) {
+		t.Setenv("DISPATCH_PROFILE", "staging")
+		t.Setenv("DISPATCH_API_KEY", "env-key")
+
+		setVariables()
+		if err := runConfigFlow(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "env-key", DispatchApiKey)
+		assert.Equal(t, "https://api.dispatch.run", DispatchApiUrl)
+	})
+
+	t.Run("Unknown profile", func(t *testing.T) {
+		t.Setenv("DISPATCH_PROFILE", "missing")
+
+		setVariables()
+		err := runConfigFlow()
+		assert.EqualError(t, err, "profile 'missing' not found in configuration. Run `dispatch profile` to list the available profiles")
+	})
+}
+
+func TestProfileCommand(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	writeProfilesConfig(t, configPath)
+
+	run := func(args ...string) string {
+		out := &bytes.Buffer{}
+		cmd := profileCommand(configPath)
+		cmd.SetOut(out)
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	assert.Equal(t, "Available profiles:\n- staging (staging-org)\n", run())
+	assert.Equal(t, "Switched to profile: staging\n", run("staging"))
+	assert.Equal(t, "Available profiles:\n- staging (staging-org) [active]\n", run())
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "staging", config.ActiveProfile)
+}
+
+func TestProfileNone(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	writeProfilesConfig(t, configPath)
+
+	run := func(cmd *cobra.Command, args ...string) string {
+		out := &bytes.Buffer{}
+		cmd.SetOut(out)
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	assert.Equal(t, "No profile is selected\n", run(profileCommand(configPath), "--none"))
+	assert.Equal(t, "Switched to profile: staging\n", run(profileCommand(configPath), "staging"))
+	assert.Equal(t, "Deselected profile: staging\n", run(profileCommand(configPath), "--none"))
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", config.ActiveProfile)
+	assert.Equal(t, "prod-org", config.Active)
+}
+
+func TestSwitchAfterProfile(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	writeProfilesConfig(t, configPath)
+
+	run := func(cmd *cobra.Command, args ...string) string {
+		out := &bytes.Buffer{}
+		cmd.SetOut(out)
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	run(profileCommand(configPath), "staging")
+
+	// Switching organizations deselects the profile, which would otherwise
+	// take precedence over the active organization.
+	assert.Equal(t, "Switched to organization: prod-org\nDeselected profile: staging\n", run(switchCommand(configPath), "prod-org"))
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "prod-org", config.Active)
+	assert.Equal(t, "", config.ActiveProfile)
+}
@@ -37,86 +37,127 @@ func pythonValueString(value interface{}) (string, error) {
 		return "False", nil
 	case string:
 		return fmt.Sprintf("%q", v), nil
+	case []byte:
+		return pythonBytesString(v), nil
+	case *types.ByteArray:
+		return "bytearray(" + pythonBytesString(*v) + ")", nil
 	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, float32, float64:
 		return fmt.Sprintf("%v", v), nil
 	case *types.List:
-		return pythonListString(v)
+		return pythonListString(v), nil
 	case *types.Tuple:
-		return pythonTupleString(v)
+		return pythonTupleString(v), nil
 	case *types.Dict:
-		return pythonDictString(v)
+		return pythonDictString(v), nil
 	case *types.Set:
-		return pythonSetString(v)
+		return pythonSetString(v), nil
 	case *pythonArgumentsObject:
-		return pythonArgumentsString(v)
+		return pythonArgumentsString(v), nil
 	case *genericClass:
 		return fmt.Sprintf("%s.%s", v.Module, v.Name), nil
 	case *genericObject:
-		return pythonGenericObjectString(v)
+		return pythonGenericObjectString(v), nil
 	default:
 		return "", fmt.Errorf("unsupported Python value: %T", value)
 	}
 }
 
-func pythonListString(list *types.List) (string, error) {
+func pythonBytesString(b []byte) string {
+	const maxBytes = 32
+
+	truncated := len(b) > maxBytes
+	if truncated {
+		b = b[:maxBytes]
+	}
+
+	var s strings.Builder
+	s.WriteString("b'")
+	for _, c := range b {
+		switch {
+		case c == '\\' || c == '\'':
+			s.WriteByte('\\')
+			s.WriteByte(c)
+		case c == '\t':
+			s.WriteString(`\t`)
+		case c == '\n':
+			s.WriteString(`\n`)
+		case c == '\r':
+			s.WriteString(`\r`)
+		case c >= 0x20 && c < 0x7f:
+			s.WriteByte(c)
+		default:
+			fmt.Fprintf(&s, `\x%02x`, c)
+		}
+	}
+	s.WriteByte('\'')
+	if truncated {
+		s.WriteString("...")
+	}
+	return s.String()
+}
+
+// pythonElementString formats a value nested within a structure. Values
+// that cannot be formatted are rendered as a placeholder, so that the rest
+// of the structure can still be displayed.
+func pythonElementString(value interface{}) string {
+	s, err := pythonValueString(value)
+	if err != nil {
+		slog.Debug("cannot format Python value", "error", err)
+		return fmt.Sprintf("<unsupported: %T>", value)
+	}
+	return s
+}
+
+// pythonKeyString formats the name of a keyword argument or field.
+func pythonKeyString(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	return pythonElementString(key)
+}
+
+func pythonListString(list *types.List) string {
 	var b strings.Builder
 	b.WriteByte('[')
 	for i, entry := range *list {
 		if i > 0 {
 			b.WriteString(", ")
 		}
-		s, err := pythonValueString(entry)
-		if err != nil {
-			return "", err
-		}
-		b.WriteString(s)
+		b.WriteString(pythonElementString(entry))
 	}
 	b.WriteByte(']')
-	return b.String(), nil
+	return b.String()
 }
 
-func pythonTupleString(tuple *types.Tuple) (string, error) {
+func pythonTupleString(tuple *types.Tuple) string {
 	var b strings.Builder
 	b.WriteByte('(')
 	for i, entry := range *tuple {
 		if i > 0 {
 			b.WriteString(", ")
 		}
-		s, err := pythonValueString(entry)
-		if err != nil {
-			return "", err
-		}
-		b.WriteString(s)
+		b.WriteString(pythonElementString(entry))
 	}
 	b.WriteByte(')')
-	return b.String(), nil
+	return b.String()
 }
 
-func pythonDictString(dict *types.Dict) (string, error) {
+func pythonDictString(dict *types.Dict) string {
 	var b strings.Builder
 	b.WriteByte('{')
 	for i, entry := range *dict {
 		if i > 0 {
 			b.WriteString(", ")
 		}
-		keyStr, err := pythonValueString(entry.Key)
-		if err != nil {
-			return "", err
-		}
-		b.WriteString(keyStr)
+		b.WriteString(pythonElementString(entry.Key))
 		b.WriteString(": ")
-
-		valueStr, err := pythonValueString(entry.Value)
-		if err != nil {
-			return "", err
-		}
-		b.WriteString(valueStr)
+		b.WriteString(pythonElementString(entry.Value))
 	}
 	b.WriteByte('}')
-	return b.String(), nil
+	return b.String()
 }
 
-func pythonSetString(set *types.Set) (string, error) {
+func pythonSetString(set *types.Set) string {
 	var b strings.Builder
 	b.WriteByte('{')
 	var i int
@@ -124,18 +165,14 @@ func pythonSetString(set *types.Set) (string, error) {
 		if i > 0 {
 			b.WriteString(", ")
 		}
-		s, err := pythonValueString(entry)
-		if err != nil {
-			return "", err
-		}
-		b.WriteString(s)
+		b.WriteString(pythonElementString(entry))
 		i++
 	}
 	b.WriteByte('}')
-	return b.String(), nil
+	return b.String()
 }
 
-func pythonArgumentsString(a *pythonArgumentsObject) (string, error) {
+func pythonArgumentsString(a *pythonArgumentsObject) string {
 	var b strings.Builder
 	b.WriteByte('(')
 
@@ -146,12 +183,7 @@ func pythonArgumentsString(a *pythonArgumentsObject) (string, error) {
 			if i > 0 {
 				b.WriteString(", ")
 			}
-			arg := a.args.Get(i)
-			s, err := pythonValueString(arg)
-			if err != nil {
-				return "", err
-			}
-			b.WriteString(s)
+			b.WriteString(pythonElementString(a.args.Get(i)))
 		}
 	}
 
@@ -160,32 +192,25 @@ func pythonArgumentsString(a *pythonArgumentsObject) (string, error) {
 			if i > 0 || argsLen > 0 {
 				b.WriteString(", ")
 			}
-			var keyStr string
-			if s, ok := entry.Key.(string); ok {
-				keyStr = s
-			} else {
-				var err error
-				keyStr, err = pythonValueString(entry.Key)
-				if err != nil {
-					return "", err
-				}
-			}
-			b.WriteString(kwargStyle.Render(keyStr + "="))
-
-			valueStr, err := pythonValueString(entry.Value)
-			if err != nil {
-				return "", err
-			}
-			b.WriteString(valueStr)
+			b.WriteString(kwargStyle.Render(pythonKeyString(entry.Key) + "="))
+			b.WriteString(pythonElementString(entry.Value))
 		}
 	}
 
 	b.WriteByte(')')
-	return b.String(), nil
+	return b.String()
 }
 
-func pythonGenericObjectString(o *genericObject) (string, error) {
+func pythonGenericObjectString(o *genericObject) string {
 	var b strings.Builder
+	switch o.class.Module {
+	case "", "__main__", "builtins":
+		// Don't qualify the names of classes defined in the main
+		// script or builtin classes.
+	default:
+		b.WriteString(o.class.Module)
+		b.WriteByte('.')
+	}
 	b.WriteString(o.class.Name)
 	b.WriteByte('(')
 
@@ -195,30 +220,14 @@ func pythonGenericObjectString(o *genericObject) (string, error) {
 		}
 		entry := e.Value.(*types.OrderedDictEntry)
 
-		var keyStr string
-		if s, ok := entry.Key.(string); ok {
-			keyStr = s
-		} else {
-			var err error
-			keyStr, err = pythonValueString(entry.Key)
-			if err != nil {
-				return "", err
-			}
-		}
-		b.WriteString(kwargStyle.Render(keyStr + "="))
-
-		valueStr, err := pythonValueString(entry.Value)
-		if err != nil {
-			return "", err
-		}
-		b.WriteString(valueStr)
+		b.WriteString(kwargStyle.Render(pythonKeyString(entry.Key) + "="))
+		b.WriteString(pythonElementString(entry.Value))
 
 		e = e.Next()
 	}
 
 	b.WriteByte(')')
-	return b.String(), nil
-
+	return b.String()
 }
 
 func findPythonClass(module, name string) (interface{}, error) {
@@ -226,6 +235,11 @@ func findPythonClass(module, name string) (interface{}, error) {
 	if module == "dispatch.proto" && name == "Arguments" {
 		return &pythonArgumentsClass{}, nil
 	}
+	// Pickle protocols < 5 serialize bytearray values as a call
+	// to the bytearray constructor.
+	if module == "builtins" && name == "bytearray" {
+		return &pythonByteArrayClass{}, nil
+	}
 	// If a custom class is encountered, we don't have enough information
 	// to be able to format it. In many cases though (e.g. dataclasses),
 	// it's sufficient to collect and format the module/name of the class,
@@ -234,6 +248,28 @@ func findPythonClass(module, name string) (interface{}, error) {
 	return &genericClass{&types.GenericClass{Module: module, Name: name}}, nil
 }
 
+type pythonByteArrayClass struct{}
+
+func (c *pythonByteArrayClass) Call(args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return types.NewByteArray(), nil
+	}
+	switch v := args[0].(type) {
+	case []byte:
+		return types.NewByteArrayFromSlice(v), nil
+	case string:
+		// Protocols < 3 encode the bytes as a latin-1 string,
+		// i.e. each code point is a byte.
+		b := make([]byte, 0, len(v))
+		for _, r := range v {
+			b = append(b, byte(r))
+		}
+		return types.NewByteArrayFromSlice(b), nil
+	default:
+		return nil, fmt.Errorf("invalid bytearray argument: %T", v)
+	}
+}
+
 type pythonArgumentsClass struct{}
 
 func (a *pythonArgumentsClass) PyNew(args ...interface{}) (interface{}, error) {
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitWait is the maximum time a request waits to be dispatched when
+// --max-rps is set. Requests that would wait longer are handed back to
+// Dispatch, which redelivers them later, rather than being queued in memory.
+const rateLimitWait = 5 * time.Second
+
+// newRateLimiter returns a limiter allowing rps requests per second, or nil
+// if rps is zero (no limit). The burst is one request, so that requests are
+// evenly spaced.
+func newRateLimiter(rps float64) *rate.Limiter {
+	if rps <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(rps), 1)
+}
+
+// rateLimitRequests wraps a request handler so that requests are passed to
+// onRequest at the rate allowed by the limiter. Requests that can't be
+// passed on within maxWait are passed to onReject instead.
+//
+// The handler blocks while waiting, which also slows down the poll loop
+// that calls it.
+func rateLimitRequests(ctx context.Context, limiter *rate.Limiter, maxWait time.Duration, onRequest, onReject func(string, *http.Response)) func(string, *http.Response) {
+	if limiter == nil {
+		return onRequest
+	}
+	return func(requestID string, res *http.Response) {
+		waitCtx, cancel := context.WithTimeout(ctx, maxWait)
+		defer cancel()
+
+		if err := limiter.Wait(waitCtx); err != nil {
+			slog.Debug("request exceeds the rate limit", "request_id", requestID, "error", err)
+			onReject(requestID, res)
+			return
+		}
+		onRequest(requestID, res)
+	}
+}
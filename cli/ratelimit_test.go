@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitRequests(t *testing.T) {
+	newResponse := func() *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}
+	}
+
+	t.Run("Dispatch rate stays under the limit", func(t *testing.T) {
+		const rps = 20
+		var dispatched []time.Time
+		onRequest := rateLimitRequests(context.Background(), newRateLimiter(rps), time.Second,
+			func(string, *http.Response) { dispatched = append(dispatched, time.Now()) },
+			func(string, *http.Response) { t.Error("request was rejected") },
+		)
+		for i := 0; i < 10; i++ {
+			onRequest("request", newResponse())
+		}
+
+		assert.Len(t, dispatched, 10)
+		elapsed := dispatched[len(dispatched)-1].Sub(dispatched[0])
+		observed := float64(len(dispatched)-1) / elapsed.Seconds()
+		assert.LessOrEqual(t, observed, rps*1.05)
+	})
+
+	t.Run("Requests exceeding the wait are rejected", func(t *testing.T) {
+		var dispatched, rejected []string
+		onRequest := rateLimitRequests(context.Background(), newRateLimiter(1), 10*time.Millisecond,
+			func(requestID string, _ *http.Response) { dispatched = append(dispatched, requestID) },
+			func(requestID string, _ *http.Response) { rejected = append(rejected, requestID) },
+		)
+		onRequest("a", newResponse())
+		onRequest("b", newResponse())
+
+		assert.Equal(t, []string{"a"}, dispatched)
+		assert.Equal(t, []string{"b"}, rejected)
+	})
+
+	t.Run("No limit", func(t *testing.T) {
+		assert.Nil(t, newRateLimiter(0))
+
+		var dispatched int
+		onRequest := rateLimitRequests(context.Background(), nil, 0,
+			func(string, *http.Response) { dispatched++ },
+			func(string, *http.Response) { t.Error("request was rejected") },
+		)
+		for i := 0; i < 100; i++ {
+			onRequest("request", newResponse())
+		}
+		assert.Equal(t, 100, dispatched)
+	})
+}
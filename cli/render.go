@@ -0,0 +1,501 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/charmbracelet/lipgloss"
+	"google.golang.org/protobuf/encoding/prototext"
+)
+
+// This file contains the model of function calls observed during a
+// session, and the rendering of their details. It's independent of
+// the TUI so that it can be reused by other outputs.
+
+const (
+	pendingIcon = "•" // U+2022
+	successIcon = "✔" // U+2714
+	failureIcon = "✗" // U+2718
+)
+
+var (
+	// Styles for function names and statuses.
+	pendingStyle   = lipgloss.NewStyle().Foreground(grayColor)
+	suspendedStyle = lipgloss.NewStyle().Foreground(grayColor)
+	retryStyle     = lipgloss.NewStyle().Foreground(yellowColor)
+	errorStyle     = lipgloss.NewStyle().Foreground(redColor)
+	okStyle        = lipgloss.NewStyle().Foreground(greenColor)
+
+	// Styles for the function call details.
+	detailHeaderStyle      = lipgloss.NewStyle().Foreground(grayColor)
+	detailLowPriorityStyle = lipgloss.NewStyle().Foreground(grayColor)
+)
+
+type DispatchID string
+
+type functionCall struct {
+	lastFunction string
+	lastStatus   sdkv1.Status
+	lastError    error
+
+	// Functions that tail-called the current function, in order. A tail
+	// call resets the function call, so the chain is kept separately to
+	// be displayed.
+	tailCalls []string
+
+	failures int
+	polls    int
+
+	// Whether a warning was logged because the function call exceeded
+	// the attempt threshold (see callStore.attemptWarning).
+	attemptWarned bool
+
+	// Number of calls made by the function when suspending, see
+	// (*callStore).outstandingCalls.
+	spawnedCalls int
+
+	running   bool
+	suspended bool
+	done      bool
+
+	creationTime   time.Time
+	expirationTime time.Time
+	doneTime       time.Time
+
+	children        map[DispatchID]struct{}
+	orderedChildren []DispatchID
+
+	timeline []*roundtrip
+
+	// Roundtrips that preceded an incompatible state response, which
+	// restarts the function call from scratch.
+	previousTimeline []*roundtrip
+}
+
+type roundtrip struct {
+	request  runRequest
+	response runResponse
+}
+
+type runRequest struct {
+	ts    time.Time
+	proto *sdkv1.RunRequest
+	input string
+}
+
+type runResponse struct {
+	ts         time.Time
+	proto      *sdkv1.RunResponse
+	httpStatus int
+	err        error
+	output     string
+}
+
+func (n functionCall) function() string {
+	if n.lastFunction != "" {
+		return n.lastFunction
+	}
+	return "(?)"
+}
+
+// expired returns true if the function call expired before it
+// could complete.
+func (n functionCall) expired(now time.Time) bool {
+	return !n.done && !n.expirationTime.IsZero() && n.expirationTime.Before(now)
+}
+
+func (n functionCall) status(now time.Time) (style lipgloss.Style, icon, status string) {
+	icon = pendingIcon
+	if n.running {
+		style = pendingStyle
+	} else if n.suspended {
+		style = suspendedStyle
+	} else if n.done {
+		if isSuccessStatus(n.lastStatus) {
+			style = okStyle
+			icon = successIcon
+		} else {
+			style = errorStyle
+			icon = failureIcon
+		}
+	} else if n.expired(now) {
+		style = errorStyle
+		icon = failureIcon
+	} else if n.failures > 0 {
+		style = retryStyle
+	} else {
+		style = pendingStyle
+	}
+
+	if n.running {
+		status = "Running"
+	} else if n.suspended {
+		status = "Suspended"
+	} else if n.expired(now) {
+		status = "Expired"
+	} else if n.lastError != nil {
+		status = n.lastError.Error()
+	} else if n.lastStatus != sdkv1.Status_STATUS_UNSPECIFIED {
+		status = statusString(n.lastStatus)
+	} else {
+		status = "Pending"
+	}
+
+	return
+}
+
+func (n functionCall) attempt() int {
+	attempt := len(n.timeline) - n.polls
+	if n.suspended {
+		attempt++
+	}
+	return attempt
+}
+
+func (n functionCall) duration(now time.Time) time.Duration {
+	var duration time.Duration
+	if !n.creationTime.IsZero() {
+		var start time.Time
+		if !n.creationTime.IsZero() && n.creationTime.Before(n.timeline[0].request.ts) {
+			start = n.creationTime
+		} else {
+			start = n.timeline[0].request.ts
+		}
+		var end time.Time
+		if n.done {
+			end = n.doneTime
+		} else if n.expired(now) {
+			end = n.expirationTime
+		} else {
+			end = now
+		}
+		duration = end.Sub(start).Truncate(time.Millisecond)
+	}
+	return max(duration, 0)
+}
+
+// renderDetail renders the details of a function call, including the
+// requests and responses in its timeline.
+func renderDetail(id DispatchID, n functionCall, now time.Time) string {
+	style, _, status := n.status(now)
+
+	var view strings.Builder
+
+	add := func(name, value string) {
+		const padding = 16
+		view.WriteString(right(padding, detailHeaderStyle.Render(name+":")))
+		view.WriteByte(' ')
+		view.WriteString(value)
+		view.WriteByte('\n')
+	}
+
+	const timestampFormat = "2006-01-02T15:04:05.000"
+
+	add("ID", detailLowPriorityStyle.Render(string(id)))
+	add("Function", n.function())
+	if len(n.tailCalls) > 0 {
+		add("Tail-called by", strings.Join(n.tailCalls, " → "))
+	}
+	add("Status", style.Render(status))
+	add("Creation time", detailLowPriorityStyle.Render(n.creationTime.Local().Format(timestampFormat)))
+	if !n.expirationTime.IsZero() && !n.done {
+		add("Expiration time", detailLowPriorityStyle.Render(n.expirationTime.Local().Format(timestampFormat)))
+	}
+	add("Duration", n.duration(now).String())
+	add("Attempts", strconv.Itoa(n.attempt()))
+	add("Requests", strconv.Itoa(len(n.timeline)))
+
+	var result strings.Builder
+	result.WriteString(view.String())
+
+	for _, rt := range n.timeline {
+		view.Reset()
+
+		result.WriteByte('\n')
+
+		// TODO: show request # and/or attempt #?
+
+		add("Timestamp", detailLowPriorityStyle.Render(rt.request.ts.Local().Format(timestampFormat)))
+		req := rt.request.proto
+		switch d := req.Directive.(type) {
+		case *sdkv1.RunRequest_Input:
+			if rt.request.input == "" {
+				rt.request.input = anyString(d.Input)
+			}
+			add("Input", rt.request.input)
+
+		case *sdkv1.RunRequest_PollResult:
+			switch s := d.PollResult.State.(type) {
+			case *sdkv1.PollResult_CoroutineState:
+				add("Input", detailLowPriorityStyle.Render(fmt.Sprintf("<%d bytes of opaque state>", len(s.CoroutineState))))
+			case *sdkv1.PollResult_TypedCoroutineState:
+				if any := s.TypedCoroutineState; any != nil {
+					add("Input", detailLowPriorityStyle.Render(fmt.Sprintf("<%d bytes of %s state>", len(any.Value), typeName(any.TypeUrl))))
+				} else {
+					add("Input", detailLowPriorityStyle.Render("<no state>"))
+				}
+			case nil:
+				add("Input", detailLowPriorityStyle.Render("<no state>"))
+			default:
+				add("Input", detailLowPriorityStyle.Render("<unknown state>"))
+			}
+			// TODO: show call results
+			// TODO: show poll error
+		}
+
+		if rt.response.ts.IsZero() {
+			add("Status", "Running")
+		} else {
+			if res := rt.response.proto; res != nil {
+				switch d := res.Directive.(type) {
+				case *sdkv1.RunResponse_Exit:
+					var statusStyle lipgloss.Style
+					if res.Status == sdkv1.Status_STATUS_OK {
+						statusStyle = okStyle
+					} else if terminalStatus(res.Status) {
+						statusStyle = errorStyle
+					} else {
+						statusStyle = retryStyle
+					}
+					add("Status", statusStyle.Render(statusString(res.Status)))
+
+					if result := d.Exit.Result; result != nil {
+						if rt.response.output == "" {
+							rt.response.output = anyString(result.Output)
+						}
+						add("Output", rt.response.output)
+
+						if result.Error != nil {
+							errorMessage := result.Error.Type
+							if result.Error.Message != "" {
+								errorMessage += ": " + result.Error.Message
+							}
+							add("Error", statusStyle.Render(errorMessage))
+						}
+					}
+					if tailCall := d.Exit.TailCall; tailCall != nil {
+						add("Tail call", tailCall.Function)
+					}
+
+				case *sdkv1.RunResponse_Poll:
+					add("Status", suspendedStyle.Render("Suspended"))
+
+					switch s := d.Poll.State.(type) {
+					case *sdkv1.Poll_CoroutineState:
+						add("Output", detailLowPriorityStyle.Render(fmt.Sprintf("<%d bytes of opaque state>", len(s.CoroutineState))))
+					case *sdkv1.Poll_TypedCoroutineState:
+						if any := s.TypedCoroutineState; any != nil {
+							add("Output", detailLowPriorityStyle.Render(fmt.Sprintf("<%d bytes of %s state>", len(any.Value), typeName(any.TypeUrl))))
+						} else {
+							add("Output", detailLowPriorityStyle.Render("<no state>"))
+						}
+					case nil:
+						add("Output", detailLowPriorityStyle.Render("<no state>"))
+					default:
+						add("Output", detailLowPriorityStyle.Render("<unknown state>"))
+					}
+
+					if len(d.Poll.Calls) > 0 {
+						var calls strings.Builder
+						for i, call := range d.Poll.Calls {
+							if i > 0 {
+								calls.WriteString(", ")
+							}
+							calls.WriteString(call.Function)
+						}
+						add("Calls", truncate(50, calls.String()))
+					}
+				}
+			} else if c := rt.response.httpStatus; c != 0 {
+				style := errorStyle
+				if !terminalHTTPStatusCode(c) {
+					style = retryStyle
+				}
+				add("Error", style.Render(fmt.Sprintf("%d %s", c, http.StatusText(c))))
+			} else if rt.response.err != nil {
+				add("Error", retryStyle.Render(rt.response.err.Error()))
+			}
+
+			latency := rt.response.ts.Sub(rt.request.ts)
+			add("Latency", latency.String())
+		}
+		result.WriteString(view.String())
+	}
+
+	if len(n.previousTimeline) > 0 {
+		view.Reset()
+		result.WriteByte('\n')
+
+		// The requests before the reset are collapsed to one line each,
+		// since the function call started over.
+		add("Reset", retryStyle.Render("Incompatible state, the function call restarted"))
+		if len(n.previousTimeline) == 1 {
+			add("Previous", "1 request")
+		} else {
+			add("Previous", strconv.Itoa(len(n.previousTimeline))+" requests")
+		}
+		for _, rt := range n.previousTimeline {
+			view.WriteString(whitespace(17))
+			view.WriteString(detailLowPriorityStyle.Render(rt.request.ts.Local().Format(timestampFormat)))
+			view.WriteByte(' ')
+			view.WriteString(rt.status())
+			view.WriteByte('\n')
+		}
+		result.WriteString(view.String())
+	}
+
+	return result.String()
+}
+
+// status returns a short description of the outcome of the roundtrip.
+func (rt *roundtrip) status() string {
+	switch {
+	case rt.response.ts.IsZero():
+		return "Running"
+	case rt.response.proto != nil:
+		if _, ok := rt.response.proto.Directive.(*sdkv1.RunResponse_Poll); ok {
+			return "Suspended"
+		}
+		return statusString(rt.response.proto.Status)
+	case rt.response.httpStatus != 0:
+		return fmt.Sprintf("%d %s", rt.response.httpStatus, http.StatusText(rt.response.httpStatus))
+	case rt.response.err != nil:
+		return rt.response.err.Error()
+	default:
+		return "?"
+	}
+}
+
+var rawProtoFormat = prototext.MarshalOptions{Multiline: true, Indent: "  "}
+
+// renderRawDetail renders the RunRequest and RunResponse protos
+// exchanged for a function call.
+func renderRawDetail(n functionCall) string {
+	const timestampFormat = "2006-01-02T15:04:05.000"
+
+	var b strings.Builder
+	for i, rt := range n.timeline {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(detailHeaderStyle.Render("RunRequest:"))
+		b.WriteByte(' ')
+		b.WriteString(detailLowPriorityStyle.Render(rt.request.ts.Local().Format(timestampFormat)))
+		b.WriteByte('\n')
+		b.WriteString(rawProtoFormat.Format(rt.request.proto))
+		b.WriteByte('\n')
+
+		if rt.response.ts.IsZero() {
+			continue
+		}
+		b.WriteString(detailHeaderStyle.Render("RunResponse:"))
+		b.WriteByte(' ')
+		b.WriteString(detailLowPriorityStyle.Render(rt.response.ts.Local().Format(timestampFormat)))
+		b.WriteByte('\n')
+		if rt.response.proto != nil {
+			b.WriteString(rawProtoFormat.Format(rt.response.proto))
+		} else {
+			b.WriteString(detailLowPriorityStyle.Render("<no response>"))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// parseStatus parses a status name, either as displayed by statusString
+// (e.g. "Permanent error") or as the protobuf enum name (e.g.
+// STATUS_PERMANENT_ERROR or permanent_error). The comparison is case
+// insensitive, and spaces, dashes and underscores are interchangeable.
+func parseStatus(name string) (sdkv1.Status, bool) {
+	normalize := func(s string) string {
+		s = strings.ToUpper(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(s)))
+		return "STATUS_" + strings.TrimPrefix(s, "STATUS_")
+	}
+	want := normalize(name)
+	for value, enumName := range sdkv1.Status_name {
+		status := sdkv1.Status(value)
+		if status == sdkv1.Status_STATUS_UNSPECIFIED {
+			continue
+		}
+		if want == enumName || want == normalize(statusString(status)) {
+			return status, true
+		}
+	}
+	return sdkv1.Status_STATUS_UNSPECIFIED, false
+}
+
+func statusString(status sdkv1.Status) string {
+	switch status {
+	case sdkv1.Status_STATUS_OK:
+		return "OK"
+	case sdkv1.Status_STATUS_TIMEOUT:
+		return "Timeout"
+	case sdkv1.Status_STATUS_THROTTLED:
+		return "Throttled"
+	case sdkv1.Status_STATUS_INVALID_ARGUMENT:
+		return "Invalid argument"
+	case sdkv1.Status_STATUS_INVALID_RESPONSE:
+		return "Invalid response"
+	case sdkv1.Status_STATUS_TEMPORARY_ERROR:
+		return "Temporary error"
+	case sdkv1.Status_STATUS_PERMANENT_ERROR:
+		return "Permanent error"
+	case sdkv1.Status_STATUS_INCOMPATIBLE_STATE:
+		return "Incompatible state"
+	case sdkv1.Status_STATUS_DNS_ERROR:
+		return "DNS error"
+	case sdkv1.Status_STATUS_TCP_ERROR:
+		return "TCP error"
+	case sdkv1.Status_STATUS_TLS_ERROR:
+		return "TLS error"
+	case sdkv1.Status_STATUS_HTTP_ERROR:
+		return "HTTP error"
+	case sdkv1.Status_STATUS_UNAUTHENTICATED:
+		return "Unauthenticated"
+	case sdkv1.Status_STATUS_PERMISSION_DENIED:
+		return "Permission denied"
+	case sdkv1.Status_STATUS_NOT_FOUND:
+		return "Not found"
+	default:
+		return status.String()
+	}
+}
+
+func terminalStatus(status sdkv1.Status) bool {
+	switch status {
+	case sdkv1.Status_STATUS_TIMEOUT,
+		sdkv1.Status_STATUS_THROTTLED,
+		sdkv1.Status_STATUS_TEMPORARY_ERROR,
+		sdkv1.Status_STATUS_INCOMPATIBLE_STATE,
+		sdkv1.Status_STATUS_DNS_ERROR,
+		sdkv1.Status_STATUS_TCP_ERROR,
+		sdkv1.Status_STATUS_TLS_ERROR,
+		sdkv1.Status_STATUS_HTTP_ERROR:
+		return false
+	default:
+		return true
+	}
+}
+
+func terminalHTTPStatusCode(code int) bool {
+	switch code / 100 {
+	case 4:
+		return code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+	case 5:
+		return code == http.StatusNotImplemented
+	default:
+		return true
+	}
+}
+
+func typeName(typeUrl string) string {
+	i := strings.LastIndexByte(typeUrl, '/')
+	if i < 0 {
+		return typeUrl
+	}
+	return typeUrl[i+1:]
+}
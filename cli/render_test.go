@@ -0,0 +1,199 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestRenderDetail(t *testing.T) {
+	now := time.Now()
+
+	n := functionCall{
+		lastFunction: "my_function",
+		lastStatus:   sdkv1.Status_STATUS_OK,
+		done:         true,
+		creationTime: now,
+		doneTime:     now.Add(2 * time.Second),
+		timeline: []*roundtrip{
+			{
+				request: runRequest{
+					ts: now,
+					proto: &sdkv1.RunRequest{
+						Function:  "my_function",
+						Directive: &sdkv1.RunRequest_Input{Input: asAny(wrapperspb.Int64(42))},
+					},
+				},
+				response: runResponse{
+					ts: now.Add(2 * time.Second),
+					proto: &sdkv1.RunResponse{
+						Status: sdkv1.Status_STATUS_OK,
+						Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{
+							Result: &sdkv1.CallResult{Output: asAny(wrapperspb.Int64(43))},
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	view := renderDetail("1", n, now.Add(time.Minute))
+	for _, section := range []string{
+		"ID: 1",
+		"Function: my_function",
+		"Status: OK",
+		"Duration: 2s",
+		"Attempts: 1",
+		"Requests: 1",
+		"Input: 42",
+		"Output: 43",
+		"Latency: 2s",
+	} {
+		assert.Contains(t, view, section)
+	}
+}
+
+func TestRenderRawDetail(t *testing.T) {
+	now := time.Now()
+
+	n := functionCall{
+		timeline: []*roundtrip{
+			{
+				request: runRequest{
+					ts:    now,
+					proto: &sdkv1.RunRequest{Function: "my_function"},
+				},
+				response: runResponse{
+					ts:  now.Add(time.Second),
+					err: errors.New("connection refused"),
+				},
+			},
+		},
+	}
+
+	view := renderRawDetail(n)
+	assert.Contains(t, view, "RunRequest:")
+	assert.Contains(t, view, "my_function")
+	assert.Contains(t, view, "RunResponse:")
+	assert.Contains(t, view, "<no response>")
+}
+
+func TestFunctionCallStatus(t *testing.T) {
+	now := time.Now()
+
+	newCall := func(n functionCall) functionCall {
+		n.creationTime = now
+		n.timeline = []*roundtrip{{request: runRequest{ts: now}}}
+		return n
+	}
+
+	for _, test := range []struct {
+		name     string
+		call     functionCall
+		icon     string
+		status   string
+		duration time.Duration
+	}{
+		{
+			name:     "Running",
+			call:     newCall(functionCall{running: true}),
+			icon:     pendingIcon,
+			status:   "Running",
+			duration: time.Minute,
+		},
+		{
+			name:     "Suspended",
+			call:     newCall(functionCall{suspended: true}),
+			icon:     pendingIcon,
+			status:   "Suspended",
+			duration: time.Minute,
+		},
+		{
+			name:     "Succeeded",
+			call:     newCall(functionCall{done: true, doneTime: now.Add(time.Second), lastStatus: sdkv1.Status_STATUS_OK}),
+			icon:     successIcon,
+			status:   "OK",
+			duration: time.Second,
+		},
+		{
+			name:     "Retrying",
+			call:     newCall(functionCall{failures: 1, lastStatus: sdkv1.Status_STATUS_TEMPORARY_ERROR}),
+			icon:     pendingIcon,
+			status:   "Temporary error",
+			duration: time.Minute,
+		},
+		{
+			name:     "Expired",
+			call:     newCall(functionCall{expirationTime: now.Add(10 * time.Second)}),
+			icon:     failureIcon,
+			status:   "Expired",
+			duration: 10 * time.Second,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			later := now.Add(time.Minute)
+			_, icon, status := test.call.status(later)
+			assert.Equal(t, test.icon, icon)
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.duration, test.call.duration(later))
+		})
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	for _, name := range []string{"Permanent error", "permanent-error", "PERMANENT_ERROR", "STATUS_PERMANENT_ERROR", " status_permanent_error "} {
+		status, ok := parseStatus(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, sdkv1.Status_STATUS_PERMANENT_ERROR, status, name)
+	}
+
+	// Every status displayed by statusString parses back to the same
+	// status, as do the protobuf enum names.
+	for value, enumName := range sdkv1.Status_name {
+		status := sdkv1.Status(value)
+		if status == sdkv1.Status_STATUS_UNSPECIFIED {
+			continue
+		}
+		parsed, ok := parseStatus(statusString(status))
+		assert.True(t, ok, statusString(status))
+		assert.Equal(t, status, parsed, statusString(status))
+
+		parsed, ok = parseStatus(enumName)
+		assert.True(t, ok, enumName)
+		assert.Equal(t, status, parsed, enumName)
+	}
+
+	for _, name := range []string{"", "unspecified", "Permanent failure"} {
+		_, ok := parseStatus(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestSuccessStatuses(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the success statuses!
+	t.Cleanup(func() { successStatuses = nil })
+
+	assert.Error(t, setSuccessStatuses([]string{"Permanent failure"}))
+
+	if err := setSuccessStatuses([]string{"Permanent error"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, isSuccessStatus(sdkv1.Status_STATUS_OK))
+	assert.True(t, isSuccessStatus(sdkv1.Status_STATUS_PERMANENT_ERROR))
+	assert.False(t, isSuccessStatus(sdkv1.Status_STATUS_TEMPORARY_ERROR))
+
+	now := time.Now()
+	call := functionCall{
+		creationTime: now,
+		timeline:     []*roundtrip{{request: runRequest{ts: now}}},
+		done:         true,
+		lastStatus:   sdkv1.Status_STATUS_PERMANENT_ERROR,
+	}
+	_, icon, status := call.status(now)
+	assert.Equal(t, successIcon, icon)
+	assert.Equal(t, "Permanent error", status)
+}
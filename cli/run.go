@@ -3,12 +3,16 @@ package cli
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"math/big"
 	"net"
 	"net/http"
@@ -28,26 +32,92 @@ import (
 	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/ansi"
 	"github.com/spf13/cobra"
+	"golang.org/x/net/http2"
 	"google.golang.org/protobuf/proto"
 )
 
 var (
-	BridgeSession string
-	LocalEndpoint string
-	Verbose       bool
+	BridgeSession      string
+	NewOnMissing       bool
+	Since              string
+	LocalEndpoint      string
+	EndpointClientCert string
+	EndpointClientKey  string
+	EndpointCACert     string
+	EndpointAddrFormat string
+	EndpointHTTP2      bool
+	Verbose            bool
+	Quiet              bool
+	LogLevel           string
+	RedactLogKeys      []string
+	LogFile            string
+	LogMaxSize         int64
+	LogMaxFiles        int
+	PrintEnv           bool
+	FollowRedirects    bool
+	InspectID          string
+	EnvPrefixes        []string
+	SuccessStatuses    []string
+	OTLPEndpoint       string
+	StateAddr          string
+	PrefixTimestamps   bool
+	MaxLogLineSize     int
+	StripAppColor      bool
+	ExportPath         string
+	ExportRedact       bool
+	FunctionColumnMin  int
+	FunctionColumnMax  int
+	AttemptWarning     int
+	FakeBridge         string
+
+	PollConcurrency   int
+	MaxRPS            float64
+	DedupSize         int
+	DedupWindow       time.Duration
+	CleanupTimeout    time.Duration
+	EndpointTimeout   time.Duration
+	TableInterval     time.Duration
+	HeartbeatInterval time.Duration
+	NoForceKill       bool
+	ShutdownGrace     time.Duration
 )
 
 const defaultEndpoint = "127.0.0.1:8000"
 
 const (
-	pollTimeout    = 30 * time.Second
-	cleanupTimeout = 5 * time.Second
+	pollTimeout = 30 * time.Second
+
+	// Maximum number of requests that are cleaned up concurrently.
+	cleanupConcurrency = 16
+
+	// Maximum number of function call hierarchies retained when the TUI
+	// isn't displayed.
+	headlessMaxRoots = 1000
 )
 
 var httpClient = &http.Client{
-	Transport: http.DefaultTransport,
-	Timeout:   pollTimeout,
+	Transport:     http.DefaultTransport,
+	Timeout:       pollTimeout,
+	CheckRedirect: checkRedirect,
+}
+
+// checkRedirect doesn't follow redirects, unless --follow-redirects is set,
+// since they could send the API key to another host, e.g. if a proxy is
+// misconfigured. When redirects are followed, the Authorization header is
+// only forwarded to the same host.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if !FollowRedirects {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		req.Header.Del("Authorization")
+	}
+	return nil
 }
 
 var (
@@ -57,6 +127,9 @@ var (
 )
 
 func runCommand() *cobra.Command {
+	// The requests read from the --fake-bridge script.
+	var fakeBridgeRequests []*sdkv1.RunRequest
+
 	cmd := &cobra.Command{
 		Use:   "run",
 		Short: "Run a Dispatch application",
@@ -72,9 +145,16 @@ function calls to it continuously.
 
 Dispatch connects to the local application endpoint on http://%s.
 If the local application is listening on a different host or port,
-please set the --endpoint option appropriately. The value passed to
-this option will be exported as the DISPATCH_ENDPOINT_ADDR environment
-variable to the local application.
+please set the --endpoint option appropriately. If the local application
+is listening on a Unix socket, use --endpoint unix:///path/to.sock. The
+value passed to this option will be exported as the DISPATCH_ENDPOINT_ADDR
+environment variable to the local application.
+
+When running several instances of the local application, pass a
+comma-separated list of addresses to --endpoint (e.g. --endpoint
+127.0.0.1:8000,127.0.0.1:8001). Function calls are then dispatched to
+the instances in turn. The first address is exported as
+DISPATCH_ENDPOINT_ADDR, and the full list as DISPATCH_ENDPOINT_ADDRS.
 
 A new session is created each time the command is run. A session is
 a pristine environment in which function calls can be dispatched and
@@ -84,6 +164,84 @@ previous run.`, defaultEndpoint),
 		Args:    cobra.MinimumNArgs(1),
 		GroupID: "dispatch",
 		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if PollConcurrency < 1 {
+				return fmt.Errorf("invalid --poll-concurrency: %d (must be at least 1)", PollConcurrency)
+			}
+			since = time.Time{}
+			if Since != "" {
+				t, err := parseSince(Since, time.Now())
+				if err != nil {
+					return err
+				}
+				since = t
+			}
+			if MaxRPS < 0 {
+				return fmt.Errorf("invalid --max-rps: %v (must not be negative)", MaxRPS)
+			}
+			if LogMaxSize < 0 {
+				return fmt.Errorf("invalid --log-max-size: %d (must not be negative)", LogMaxSize)
+			}
+			if LogMaxFiles < 0 {
+				return fmt.Errorf("invalid --log-max-files: %d (must not be negative)", LogMaxFiles)
+			}
+			switch EndpointAddrFormat {
+			case "host-port", "url":
+			default:
+				return fmt.Errorf("invalid --endpoint-addr-format: %q (must be host-port or url)", EndpointAddrFormat)
+			}
+			endpoints, err := splitEndpoints(LocalEndpoint)
+			if err != nil {
+				return err
+			}
+			localEndpoints = endpoints
+			tlsConfig, err := newEndpointTLSConfig(endpoints[0], EndpointClientCert, EndpointClientKey, EndpointCACert)
+			if err != nil {
+				return err
+			}
+			endpointTLSConfig = tlsConfig
+			if FunctionColumnMin < 1 || FunctionColumnMax < 0 || (FunctionColumnMax > 0 && FunctionColumnMax < FunctionColumnMin) {
+				return fmt.Errorf("invalid --function-column-min/--function-column-max: %d/%d", FunctionColumnMin, FunctionColumnMax)
+			}
+			if AttemptWarning < 0 {
+				return fmt.Errorf("invalid --attempt-warning: %d (must not be negative)", AttemptWarning)
+			}
+			if EndpointTimeout < 0 {
+				return fmt.Errorf("invalid --endpoint-timeout: %v (must not be negative)", EndpointTimeout)
+			}
+			if ShutdownGrace < 0 {
+				return fmt.Errorf("invalid --shutdown-grace: %v (must not be negative)", ShutdownGrace)
+			}
+			if CleanupTimeout <= 0 {
+				return fmt.Errorf("invalid --cleanup-timeout: %v (must be positive)", CleanupTimeout)
+			}
+			level, err := parseLogLevel(LogLevel)
+			if err != nil {
+				return err
+			}
+			if Verbose && Quiet {
+				return fmt.Errorf("--verbose and --quiet cannot be used together")
+			}
+			if Verbose {
+				level = slog.LevelDebug
+			} else if Quiet {
+				level = slog.LevelWarn
+			}
+			logLevel.Set(level)
+			redactAttrKeys(RedactLogKeys...)
+			if err := setSuccessStatuses(SuccessStatuses); err != nil {
+				return fmt.Errorf("invalid --success-status: %w", err)
+			}
+			if FakeBridge != "" {
+				requests, err := readFakeBridgeScript(FakeBridge)
+				if err != nil {
+					return err
+				}
+				fakeBridgeRequests = requests
+				// The fake bridge doesn't check the API key, so the
+				// configuration isn't needed.
+				DispatchApiKey = "fake-bridge"
+				return nil
+			}
 			return runConfigFlow()
 		},
 		RunE: func(c *cobra.Command, args []string) error {
@@ -91,34 +249,132 @@ previous run.`, defaultEndpoint),
 
 			prefixWidth := max(len("dispatch"), len(arg0))
 
-			if checkEndpoint(LocalEndpoint, time.Second) {
-				return fmt.Errorf("cannot start local application on address that's already in use: %v", LocalEndpoint)
+			for _, endpoint := range localEndpoints {
+				if checkEndpoint(endpoint, time.Second) {
+					if err := probeEndpoint(endpoint, time.Second); err != nil {
+						slog.Warn(fmt.Sprintf("%s does not look like a Dispatch application endpoint: %v (check that -e,--endpoint is correct)", endpoint, err))
+					}
+					return endpointInUseError{endpoint}
+				}
 			}
 
 			// Enable the TUI if this is an interactive session and
 			// stdout/stderr aren't redirected.
 			var tui *TUI
 			var logWriter io.Writer = os.Stderr
-			var observer FunctionCallObserver
-			if isTerminal(os.Stdin) && isTerminal(os.Stdout) && isTerminal(os.Stderr) {
-				tui = &TUI{}
+			calls := &callStore{
+				attemptWarning: AttemptWarning,
+				// The calls that are evicted or cleared are still
+				// exported.
+				keepRemoved: ExportPath != "",
+			}
+			var observer FunctionCallObserver = calls
+			if InspectID != "" {
+				observer = &callInspector{id: DispatchID(InspectID), out: os.Stdout, calls: calls}
+			} else if isTerminal(os.Stdin) && isTerminal(os.Stdout) && isTerminal(os.Stderr) {
+				tui = &TUI{
+					store:                  calls,
+					functionColumnMinWidth: FunctionColumnMin,
+					functionColumnMaxWidth: FunctionColumnMax,
+					cancelCall:             inFlightCalls.cancel,
+				}
 				logWriter = tui
-				observer = tui
+			} else {
+				// Keep track of function calls so that they can be dumped
+				// on demand, even though the TUI isn't displayed. Finished
+				// calls are evicted so that long sessions don't accumulate
+				// them forever.
+				calls.maxRoots = headlessMaxRoots
+			}
+
+			// Export a span for each function call roundtrip.
+			if OTLPEndpoint != "" {
+				provider, err := newOTLPTracerProvider(c.Context(), OTLPEndpoint)
+				if err != nil {
+					return fmt.Errorf("failed to configure OTLP exporter: %v", err)
+				}
+				defer func() {
+					// Flush the spans that haven't been exported yet.
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					if err := provider.Shutdown(ctx); err != nil {
+						slog.Warn("failed to export spans", "error", err)
+					}
+				}()
+				observer = observers{observer, newSpanObserver(provider)}
+			}
+
+			// Capture Dispatch and local application logs to a file,
+			// in addition to displaying them. The file contains plain
+			// text, without the colors of the terminal output.
+			if LogFile != "" {
+				logFile, err := openRotatingFile(LogFile, LogMaxSize*1024*1024, LogMaxFiles)
+				if err != nil {
+					return fmt.Errorf("failed to open log file: %v", err)
+				}
+				defer logFile.Close()
+				logWriter = io.MultiWriter(logWriter, plainTextWriter{logFile})
 			}
 
 			// Add a prefix to Dispatch logs.
 			slog.SetDefault(slog.New(&slogHandler{
+				level: &logLevel,
 				stream: &prefixLogWriter{
 					stream: logWriter,
 					prefix: []byte(dispatchLogPrefixStyle.Render(pad("dispatch", prefixWidth)) + logPrefixSeparatorStyle.Render(" | ")),
 				},
 			}))
 
-			if BridgeSession == "" {
+			if FakeBridge != "" {
+				fakeBridgeCtx, stopFakeBridge := context.WithCancel(c.Context())
+				defer stopFakeBridge()
+				url, err := newFakeBridge(fakeBridgeRequests).start(fakeBridgeCtx)
+				if err != nil {
+					return err
+				}
+				DispatchBridgeUrl = url
+				slog.Info("serving requests from the fake bridge", "script", FakeBridge, "url", url)
+			}
+
+			resumed := BridgeSession != ""
+			if !resumed {
 				BridgeSession = randomSessionID()
 			}
 
-			if !Verbose && tui == nil {
+			bridgeSessionURL := fmt.Sprintf("%s/sessions/%s", DispatchBridgeUrl, BridgeSession)
+
+			// Fail fast if the bridge can't be contacted, rather than
+			// logging the same warning from the poll loop forever.
+			err := probeBridge(c.Context(), httpClient, bridgeSessionURL)
+			if err != nil && relogin(c, err) {
+				err = probeBridge(c.Context(), httpClient, bridgeSessionURL)
+			}
+			if _, ok := err.(sessionNotFoundError); ok {
+				switch {
+				case !resumed:
+					// New sessions are created on demand.
+					err = nil
+				case NewOnMissing:
+					simple(c, fmt.Sprintf("Session %s no longer exists, starting a new session.", BridgeSession))
+					BridgeSession = randomSessionID()
+					bridgeSessionURL = fmt.Sprintf("%s/sessions/%s", DispatchBridgeUrl, BridgeSession)
+					err = nil
+				}
+			}
+			if _, ok := err.(bridgeProbeUnknownError); ok {
+				// Let the poll loop report the problem, if any.
+				slog.Warn(err.Error())
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if tui != nil {
+				tui.resumeCommand = resumeCommand(os.Args[0], BridgeSession, args)
+			}
+
+			if !Verbose && !Quiet && tui == nil {
 				dialog(`Starting Dispatch session: %v
 
 Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
@@ -129,6 +385,17 @@ Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
+			// Serve the function calls of the session as JSON, so that
+			// other tools can follow its progress.
+			if StateAddr != "" {
+				l, err := net.Listen("tcp", StateAddr)
+				if err != nil {
+					return fmt.Errorf("failed to listen on %s: %v", StateAddr, err)
+				}
+				slog.Info("serving session state", "addr", l.Addr().String())
+				go serveState(ctx, l, BridgeSession, calls)
+			}
+
 			// Execute the command, forwarding the environment and
 			// setting the necessary extra DISPATCH_* variables.
 			cmd := exec.Command(args[0], args[1:]...)
@@ -160,17 +427,20 @@ Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
 			// Pipe stdout/stderr streams through a writer that adds a prefix,
 			// so that it's easier to disambiguate Dispatch logs from the local
 			// application's logs.
-			stdout, err := cmd.StdoutPipe()
-			if err != nil {
-				return fmt.Errorf("failed to create stdout pipe: %v", err)
-			}
+			//
+			// Unlike cmd.StdoutPipe/StderrPipe, cmd.Wait() waits for the output
+			// to be copied to these pipes, so the last lines written by the
+			// local application aren't lost. WaitDelay bounds the wait in case
+			// the output is held open by a process that outlives the command.
+			stdout, stdoutWriter := io.Pipe()
 			defer stdout.Close()
+			cmd.Stdout = stdoutWriter
 
-			stderr, err := cmd.StderrPipe()
-			if err != nil {
-				return fmt.Errorf("failed to create stderr pipe: %v", err)
-			}
+			stderr, stderrWriter := io.Pipe()
 			defer stderr.Close()
+			cmd.Stderr = stderrWriter
+
+			cmd.WaitDelay = time.Second
 
 			// Pass on environment variables to the local application.
 			// Pass on the configured API key, and set a special endpoint
@@ -178,12 +448,30 @@ Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
 			// it doesn't conflict with the session. A verification key
 			// is not required here, since function calls are retrieved
 			// from an authenticated API endpoint.
-			cmd.Env = append(
-				withoutEnv(os.Environ(), "DISPATCH_VERIFICATION_KEY="),
+			//
+			// If --env-prefix is set, only variables matching one of the
+			// prefixes are passed on, in addition to the DISPATCH_*
+			// variables set below.
+			env := withoutEnv(os.Environ(), "DISPATCH_VERIFICATION_KEY=")
+			if len(EnvPrefixes) > 0 {
+				env = onlyEnv(env, EnvPrefixes...)
+			}
+			cmd.Env = append(env,
 				"DISPATCH_API_KEY="+DispatchApiKey,
 				"DISPATCH_ENDPOINT_URL=bridge://"+BridgeSession,
-				"DISPATCH_ENDPOINT_ADDR="+LocalEndpoint,
+				"DISPATCH_ENDPOINT_ADDR="+endpointAddr(EndpointAddrFormat, localEndpoints[0]),
 			)
+			if len(localEndpoints) > 1 {
+				addrs := make([]string, len(localEndpoints))
+				for i, endpoint := range localEndpoints {
+					addrs[i] = endpointAddr(EndpointAddrFormat, endpoint)
+				}
+				cmd.Env = append(cmd.Env, "DISPATCH_ENDPOINT_ADDRS="+strings.Join(addrs, ","))
+			}
+
+			if PrintEnv {
+				printEnv(logWriter, cmd.Env)
+			}
 
 			// Set OS-specific process attributes.
 			cmd.SysProcAttr = &syscall.SysProcAttr{}
@@ -193,17 +481,15 @@ Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
 			signals := make(chan os.Signal, 2)
 			signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 			var signaled bool
+			forwarder := &signalForwarder{noForceKill: NoForceKill, grace: ShutdownGrace}
 			backgroundGoroutine(func() {
 				for {
 					select {
 					case <-ctx.Done():
 						return
 					case s := <-signals:
-						if !signaled {
-							signaled = true
-						} else {
-							s = os.Kill
-						}
+						signaled = true
+						s = forwarder.forward(time.Now(), s)
 						if cmd.Process != nil && cmd.Process.Pid > 0 {
 							killProcess(cmd.Process, s.(syscall.Signal))
 						}
@@ -211,6 +497,36 @@ Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
 				}
 			})
 
+			// Dump the in-flight function calls when signaled (SIGUSR1
+			// on platforms that support it). When the TUI isn't displayed,
+			// the function calls table is also printed, and optionally at
+			// a regular interval.
+			showTable := tui == nil && InspectID == ""
+			var tableTicks <-chan time.Time
+			if showTable && TableInterval > 0 {
+				ticker := time.NewTicker(TableInterval)
+				defer ticker.Stop()
+				tableTicks = ticker.C
+			}
+			dumpSignals := make(chan os.Signal, 1)
+			notifyDumpSignal(dumpSignals)
+			defer signal.Stop(dumpSignals)
+			backgroundGoroutine(func() {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-dumpSignals:
+						writeInFlightCalls(logWriter, calls, time.Now())
+						if showTable {
+							writeFunctionsTable(logWriter, calls, time.Now())
+						}
+					case <-tableTicks:
+						writeFunctionsTable(logWriter, calls, time.Now())
+					}
+				}
+			})
+
 			// Initialize the TUI.
 			if tui != nil {
 				p := tea.NewProgram(tui,
@@ -230,79 +546,119 @@ Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
 				})
 			}
 
-			bridgeSessionURL := fmt.Sprintf("%s/sessions/%s", DispatchBridgeUrl, BridgeSession)
-
 			// Poll for work in the background.
-			var successfulPolls int64
+			var stats sessionStats
 
-			backgroundGoroutine(func() {
-				for ctx.Err() == nil {
-					// Fetch a request from the API.
-					requestID, res, err := poll(ctx, httpClient, bridgeSessionURL)
-					if err != nil {
-						if ctx.Err() != nil {
-							return
-						}
-						slog.Warn(err.Error())
+			// Periodically log that the session is healthy when the TUI
+			// isn't displayed. The heartbeat is an info log, so it's
+			// suppressed by --quiet.
+			if tui == nil && HeartbeatInterval > 0 {
+				backgroundGoroutine(func() { logHeartbeats(ctx, slog.Default(), HeartbeatInterval, &stats) })
+			}
 
-						if tui != nil {
-							if _, ok := err.(authError); ok {
-								tui.SetError(err)
-							}
-						}
+			onPollError := func(err error) {
+				slog.Warn(err.Error())
 
-						time.Sleep(1 * time.Second)
-						continue
-					} else if res == nil {
-						continue
+				if tui != nil {
+					if _, ok := err.(authError); ok {
+						tui.SetError(err)
 					}
+				}
+			}
 
-					atomic.AddInt64(&successfulPolls, +1)
-
-					// Asynchronously send the request to invoke a function to
-					// the local application.
-					wg.Add(1)
-					go func() {
-						defer wg.Done()
-
-						err := invoke(ctx, httpClient, bridgeSessionURL, requestID, res, observer)
-						res.Body.Close()
-						if err != nil {
-							if ctx.Err() == nil {
-								slog.Warn(err.Error())
-							}
-
-							// Notify upstream if we're unable to generate a response,
-							// either because the local application can't be contacted,
-							// is misbehaving, or a shutdown sequence has been initiated.
-							ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
-							defer cancel()
-							if err := deleteRequest(ctx, httpClient, bridgeSessionURL, requestID); err != nil {
-								slog.Debug(err.Error())
-							}
-						}
-					}()
+			dedup := newRequestDeduplicator(DedupSize, DedupWindow)
+
+			cleaner := newRequestCleaner(httpClient, bridgeSessionURL, CleanupTimeout, cleanupConcurrency)
+			cleaner.forget = dedup.forget
+			defer cleaner.cancel()
+
+			onRequest := func(requestID string, res *http.Response) {
+				stats.successfulPolls.Add(1)
+
+				// Asynchronously send the request to invoke a function to
+				// the local application.
+				wg.Add(1)
+				stats.inFlight.Add(1)
+				go func() {
+					defer wg.Done()
+					defer stats.inFlight.Add(-1)
+
+					handleRequest(ctx, httpClient, bridgeSessionURL, requestID, res, observer, cleaner)
+				}()
+			}
+
+			// Limit the rate at which requests are dispatched, if
+			// configured. Requests that exceed the rate are cleaned up
+			// so that Dispatch redelivers them later.
+			onRequest = rateLimitRequests(ctx, newRateLimiter(MaxRPS), rateLimitWait, onRequest, func(requestID string, res *http.Response) {
+				res.Body.Close()
+				if ctx.Err() != nil {
+					return
 				}
+				slog.Warn("request exceeds --max-rps, returning it to Dispatch", "request_id", requestID)
+				if err := cleaner.cleanup(requestID); err != nil {
+					slog.Debug(err.Error())
+				}
+			})
+
+			// Skip the requests that are delivered again while they're
+			// handled, or were handled recently. Deduplication applies
+			// before the rate limit, so that duplicates are never cleaned
+			// up: deleting them would also delete the original request.
+			// Requests that are cleaned up are forgotten, so that their
+			// redelivery isn't skipped.
+			onRequest = dedupRequests(dedup, onRequest, func(requestID string, res *http.Response) {
+				res.Body.Close()
+				slog.Warn("skipping duplicate request", "request_id", requestID)
 			})
 
+			for i := 0; i < PollConcurrency; i++ {
+				backgroundGoroutine(func() {
+					pollLoop(ctx, httpClient, bridgeSessionURL, &stats, onPollError, onRequest)
+				})
+			}
+
 			runtime.LockOSThread()
 			defer runtime.UnlockOSThread()
 
-			if err = cmd.Start(); err != nil {
+			if err := cmd.Start(); err != nil {
 				return fmt.Errorf("failed to start %s: %v", strings.Join(args, " "), err)
 			}
+			if err := trackProcess(cmd.Process); err != nil {
+				slog.Debug("cannot track the processes spawned by the local application", "error", err)
+			}
 
 			// Add a prefix to the local application's logs.
 			appLogPrefix := []byte(appLogPrefixStyle.Render(pad(arg0, prefixWidth)) + logPrefixSeparatorStyle.Render(" | "))
-			backgroundGoroutine(func() { printPrefixedLines(logWriter, stdout, appLogPrefix) })
-			backgroundGoroutine(func() { printPrefixedLines(logWriter, stderr, appLogPrefix) })
+			appLogOptions := prefixedLinesOptions{
+				timestamps:  PrefixTimestamps,
+				maxLineSize: MaxLogLineSize,
+				stripColor:  StripAppColor,
+			}
+			backgroundGoroutine(func() { printPrefixedLines(logWriter, stdout, appLogPrefix, appLogOptions) })
+			backgroundGoroutine(func() { printPrefixedLines(logWriter, stderr, appLogPrefix, appLogOptions) })
 
 			err = cmd.Wait()
 			cmd = nil
+			stdoutWriter.Close()
+			stderrWriter.Close()
 
 			// Cancel the context and wait for all goroutines to return.
+			// Requests that are still being cleaned up are given until
+			// the cleanup timeout to complete, so that shutdown doesn't
+			// hang past this deadline.
 			cancel()
+			deadline := time.AfterFunc(CleanupTimeout, cleaner.cancel)
 			wg.Wait()
+			deadline.Stop()
+
+			if ExportPath != "" {
+				if err := writeSessionExport(ExportPath, calls, BridgeSession, time.Now(), ExportRedact); err != nil {
+					failure(c, fmt.Sprintf("Failed to export the session to %s: %v", ExportPath, err))
+				} else if !Quiet {
+					simple(c, "Exported the session to "+ExportPath)
+				}
+			}
 
 			// If the command was halted by a signal rather than some other error,
 			// assume that the command invocation succeeded and that the user may
@@ -310,18 +666,17 @@ Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
 			if signaled {
 				err = nil
 
-				if atomic.LoadInt64(&successfulPolls) > 0 && !Verbose {
-					dispatchArg0 := os.Args[0]
-					dialog("To resume this Dispatch session:\n\n\t%s run --session %s -- %s",
-						dispatchArg0, BridgeSession, strings.Join(args, " "))
+				if stats.successfulPolls.Load() > 0 && !Verbose {
+					dialog("To resume this Dispatch session:\n\n\t%s",
+						resumeCommand(os.Args[0], BridgeSession, args))
 				}
 			}
 
 			if err != nil {
-				dumpLogs(logWriter)
+				dumpLogs(tui)
 				return fmt.Errorf("failed to invoke command '%s': %v", strings.Join(args, " "), err)
-			} else if !signaled && successfulPolls == 0 {
-				dumpLogs(logWriter)
+			} else if !signaled && stats.successfulPolls.Load() == 0 {
+				dumpLogs(tui)
 				return fmt.Errorf("command '%s' exited unexpectedly", strings.Join(args, " "))
 			}
 			return nil
@@ -329,16 +684,82 @@ Run 'dispatch help run' to learn about Dispatch sessions.`, BridgeSession)
 	}
 
 	cmd.Flags().StringVarP(&BridgeSession, "session", "s", "", "Optional session to resume")
-	cmd.Flags().StringVarP(&LocalEndpoint, "endpoint", "e", defaultEndpoint, "Host:port that the local application endpoint is listening on")
-	cmd.Flags().BoolVarP(&Verbose, "verbose", "", false, "Enable verbose logging")
+	cmd.Flags().BoolVarP(&NewOnMissing, "new-on-missing", "", false, "Start a new session if the session to resume no longer exists")
+	cmd.Flags().StringVarP(&Since, "since", "", "", "Only handle function calls created after this time, failing older ones (RFC 3339 timestamp, or duration before now, e.g. 10m)")
+	cmd.Flags().StringVarP(&LocalEndpoint, "endpoint", "e", defaultEndpoint, "Host:port (or https://host:port, or unix:///path/to.sock) that the local application endpoint is listening on, or a comma-separated list of addresses of several instances")
+	cmd.Flags().StringVarP(&EndpointClientCert, "endpoint-client-cert", "", "", "Path to the client certificate presented to an https:// endpoint (requires --endpoint-client-key)")
+	cmd.Flags().StringVarP(&EndpointClientKey, "endpoint-client-key", "", "", "Path to the private key of the client certificate (requires --endpoint-client-cert)")
+	cmd.Flags().StringVarP(&EndpointCACert, "endpoint-ca-cert", "", "", "Path to the CA certificate used to verify an https:// endpoint, instead of the system roots")
+	cmd.Flags().StringVarP(&EndpointAddrFormat, "endpoint-addr-format", "", "host-port", "Format of the DISPATCH_ENDPOINT_ADDR environment variable (host-port or url)")
+	cmd.Flags().BoolVarP(&Verbose, "verbose", "", false, "Enable verbose logging (same as --log-level=debug)")
+	cmd.Flags().BoolVarP(&Quiet, "quiet", "", false, "Only log warnings and errors (same as --log-level=warn)")
+	cmd.Flags().StringVarP(&LogLevel, "log-level", "", "info", "Minimum level of Dispatch logs (debug, info, warn or error)")
+	cmd.Flags().StringArrayVarP(&RedactLogKeys, "log-redact", "", nil, "Redact the value of this attribute in Dispatch logs (can be repeated)")
+	cmd.Flags().StringVarP(&LogFile, "log-file", "", "", "Also write Dispatch and local application logs to this file")
+	cmd.Flags().Int64VarP(&LogMaxSize, "log-max-size", "", 0, "Size in megabytes after which the log file is rotated (0 to disable rotation)")
+	cmd.Flags().IntVarP(&LogMaxFiles, "log-max-files", "", 5, "Number of rotated log files to keep")
+	cmd.Flags().BoolVarP(&PrintEnv, "print-env", "", false, "Print the environment passed to the local application")
+	cmd.Flags().BoolVarP(&FollowRedirects, "follow-redirects", "", false, "Follow HTTP redirects from Dispatch and the local application (the API key is only sent to the original host)")
+	cmd.Flags().StringArrayVarP(&EnvPrefixes, "env-prefix", "", nil, "Only pass environment variables with this name prefix to the local application (can be repeated)")
+	cmd.Flags().IntVarP(&PollConcurrency, "poll-concurrency", "", 1, "Number of concurrent requests polling Dispatch for function calls")
+	cmd.Flags().Float64VarP(&MaxRPS, "max-rps", "", 0, "Maximum number of function calls dispatched to the local application per second (0 for no limit)")
+	cmd.Flags().IntVarP(&DedupSize, "dedup-size", "", 1000, "Number of recent request IDs remembered to skip redelivered requests (0 to disable)")
+	cmd.Flags().DurationVarP(&DedupWindow, "dedup-window", "", time.Minute, "Time window in which redelivered requests are skipped (0 to disable)")
+	cmd.Flags().DurationVarP(&CleanupTimeout, "cleanup-timeout", "", 5*time.Second, "Time allowed to clean up requests that the local application could not respond to, including on shutdown")
+	cmd.Flags().BoolVarP(&EndpointHTTP2, "endpoint-http2", "", false, "Use HTTP/2 to send requests to the local application (without TLS, unless the endpoint is https://)")
+	cmd.Flags().DurationVarP(&EndpointTimeout, "endpoint-timeout", "", 0, "Time allowed for the local application to respond to a function call, also sent in the Request-Timeout header (0 for no limit)")
+	cmd.Flags().BoolVarP(&NoForceKill, "no-force-kill", "", false, "Forward repeated signals to the local application instead of killing it on the second one")
+	cmd.Flags().DurationVarP(&ShutdownGrace, "shutdown-grace", "", 0, "With --no-force-kill, kill the local application on a signal received this long after the first one (0 to never kill it)")
+	cmd.Flags().StringVarP(&FakeBridge, "fake-bridge", "", "", "Serve the requests of this script (one JSON RunRequest per line) from an in-process fake bridge, for local development")
+	cmd.Flags().MarkHidden("fake-bridge")
+	cmd.Flags().DurationVarP(&TableInterval, "table-interval", "", 0, "Print the function calls table at this interval when the TUI is disabled (0 to disable)")
+	cmd.Flags().DurationVarP(&HeartbeatInterval, "heartbeat-interval", "", time.Minute, "Log a summary of the session's activity at this interval when the TUI is disabled (0 to disable)")
+	cmd.Flags().IntVarP(&FunctionColumnMin, "function-column-min", "", defaultFunctionColumnMinWidth, "Minimum width of the function column of the TUI")
+	cmd.Flags().IntVarP(&FunctionColumnMax, "function-column-max", "", 0, "Maximum width of the function column of the TUI (0 to use the width of the terminal)")
+	cmd.Flags().IntVarP(&AttemptWarning, "attempt-warning", "", 0, "Highlight function calls and log a warning when they exceed this number of attempts (0 to disable)")
+	cmd.Flags().StringArrayVarP(&SuccessStatuses, "success-status", "", nil, "Treat function calls returning this status (e.g. \"Permanent error\") as successful (can be repeated)")
+	cmd.Flags().StringVarP(&OTLPEndpoint, "otlp-endpoint", "", "", "Export a trace span for each function call to this OpenTelemetry collector (OTLP/HTTP URL, e.g. http://localhost:4318)")
+	cmd.Flags().StringVarP(&StateAddr, "state-addr", "", "", "Serve the function calls of the session as JSON over HTTP on this address (e.g. 127.0.0.1:4040)")
+	cmd.Flags().BoolVarP(&PrefixTimestamps, "prefix-timestamps", "", false, "Prefix the local application's log lines with a timestamp, like the Dispatch logs")
+	cmd.Flags().IntVarP(&MaxLogLineSize, "max-log-line-size", "", 1024*1024, "Maximum size in bytes of the local application's log lines; longer lines are split")
+	cmd.Flags().BoolVarP(&StripAppColor, "strip-app-color", "", false, "Remove the ANSI colors from the local application's log lines")
+	cmd.Flags().StringVarP(&ExportPath, "export", "", "", "Export the function calls of the session to this JSON file when the session ends")
+	cmd.Flags().BoolVarP(&ExportRedact, "export-redact", "", false, "Mask the inputs and outputs of the function calls in the --export file")
+	cmd.Flags().StringVarP(&InspectID, "inspect", "", "", "Disable the TUI and print the details of a function call (by dispatch ID) once it completes")
 
 	return cmd
 }
 
-func dumpLogs(logWriter io.Writer) {
-	if r, ok := logWriter.(io.Reader); ok {
+// successStatuses are the statuses, in addition to STATUS_OK, that are
+// expected outcomes of function calls (see --success-status).
+var successStatuses map[sdkv1.Status]bool
+
+func setSuccessStatuses(names []string) error {
+	statuses := make(map[sdkv1.Status]bool, len(names))
+	for _, name := range names {
+		status, ok := parseStatus(name)
+		if !ok {
+			return fmt.Errorf("invalid status: %q", name)
+		}
+		statuses[status] = true
+	}
+	successStatuses = statuses
+	return nil
+}
+
+func isSuccessStatus(status sdkv1.Status) bool {
+	return status == sdkv1.Status_STATUS_OK || successStatuses[status]
+}
+
+// resumeCommand returns the command to run to resume a session.
+func resumeCommand(dispatchArg0, sessionID string, args []string) string {
+	return fmt.Sprintf("%s run --session %s -- %s", dispatchArg0, sessionID, strings.Join(args, " "))
+}
+
+func dumpLogs(tui *TUI) {
+	if tui != nil {
 		time.Sleep(100 * time.Millisecond)
-		_, _ = io.Copy(os.Stderr, r)
+		_, _ = io.Copy(os.Stderr, tui)
 		_, _ = os.Stderr.Write([]byte{'\n'})
 	}
 }
@@ -351,6 +772,7 @@ func poll(ctx context.Context, client *http.Client, url string) (string, *http.R
 		panic(err)
 	}
 	req.Header.Add("Authorization", "Bearer "+DispatchApiKey)
+	req.Header.Set("User-Agent", userAgent())
 	req.Header.Add("Request-Timeout", strconv.FormatInt(int64(pollTimeout.Seconds()), 10))
 	if DispatchBridgeHostHeader != "" {
 		req.Host = DispatchBridgeHostHeader
@@ -358,7 +780,7 @@ func poll(ctx context.Context, client *http.Client, url string) (string, *http.R
 
 	res, err := client.Do(req)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to contact Dispatch API (%s): %v", DispatchBridgeUrl, err)
+		return "", nil, pollError{err}
 	}
 	if res.StatusCode != http.StatusOK {
 		res.Body.Close()
@@ -366,13 +788,15 @@ func poll(ctx context.Context, client *http.Client, url string) (string, *http.R
 		switch res.StatusCode {
 		case http.StatusUnauthorized:
 			return "", nil, authError{}
+		case http.StatusTooManyRequests:
+			return "", nil, throttledError{retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now())}
 		case http.StatusGatewayTimeout:
 			// A 504 is expected when long polling and no requests
 			// are available. Return a nil in this case and let the
 			// caller try again.
 			return "", nil, nil
 		default:
-			return "", nil, fmt.Errorf("failed to contact Dispatch API (%s): response code %d", DispatchBridgeUrl, res.StatusCode)
+			return "", nil, pollError{fmt.Errorf("response code %d", res.StatusCode)}
 		}
 	}
 
@@ -381,9 +805,143 @@ func poll(ctx context.Context, client *http.Client, url string) (string, *http.R
 	return requestID, res, nil
 }
 
+// errMissingRequestID is returned when a request from the Dispatch API has
+// no request ID. The response can't be sent back to Dispatch, nor can the
+// request be cleaned up, since the bridge identifies requests by their ID.
+var errMissingRequestID = errors.New("request from Dispatch API has no X-Request-Id header, skipping it")
+
+// since is the time parsed from --since. Function calls created before
+// are not sent to the local application.
+var since time.Time
+
+// parseSince parses the value of --since, which is either a RFC 3339
+// timestamp, or a duration before now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since: %q (must be a RFC 3339 timestamp, e.g. 2024-07-01T12:00:00Z, or a duration, e.g. 10m)", value)
+}
+
+// pollRetryDelay is the delay before polling again after an error.
+var pollRetryDelay = 1 * time.Second
+
+// maxThrottleDelay is the maximum delay before polling again when the
+// bridge is throttling requests without specifying a Retry-After delay.
+const maxThrottleDelay = 1 * time.Minute
+
+// parseRetryAfter parses the value of a Retry-After header, which is either
+// a number of seconds or an HTTP date. It returns zero if the value is
+// missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(0, time.Duration(seconds)*time.Second)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(0, t.Sub(now))
+	}
+	return 0
+}
+
+// pollLoop repeatedly polls the Dispatch bridge for requests until the
+// context is canceled. Requests are passed to onRequest, which takes
+// ownership of the response. Errors are passed to onError, and polling is
+// retried after a delay.
+//
+// When the bridge is throttling requests, polling pauses for the delay
+// specified by the bridge, or for an exponentially increasing delay if it
+// doesn't specify one.
+func pollLoop(ctx context.Context, client *http.Client, url string, stats *sessionStats, onError func(error), onRequest func(string, *http.Response)) {
+	var throttles int
+	for ctx.Err() == nil {
+		// Fetch a request from the API.
+		requestID, res, err := poll(ctx, client, url)
+		if err == nil && stats != nil {
+			stats.polled(time.Now())
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			delay := pollRetryDelay
+			if throttled, ok := err.(throttledError); ok {
+				if throttled.retryAfter > 0 {
+					delay = throttled.retryAfter
+				} else {
+					delay = min(maxThrottleDelay, pollRetryDelay<<min(throttles, 16))
+					err = throttledError{retryAfter: delay}
+				}
+				throttles++
+			} else {
+				throttles = 0
+			}
+			onError(err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			continue
+		}
+		throttles = 0
+		if res == nil {
+			continue
+		}
+
+		onRequest(requestID, res)
+	}
+}
+
+// probeBridge checks that the Dispatch bridge can be contacted, that the
+// API key is accepted and that the session exists. A HEAD request is used
+// so that no function call is consumed from the session.
+//
+// The bridge doesn't document its response to HEAD requests, so only
+// successful, 401 and 404 responses are trusted. Any other response is
+// reported as a bridgeProbeUnknownError.
+func probeBridge(ctx context.Context, client *http.Client, url string) error {
+	slog.Debug("checking connectivity to Dispatch", "url", url)
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return bridgeUnreachableError{err}
+	}
+	req.Header.Add("Authorization", "Bearer "+DispatchApiKey)
+	req.Header.Set("User-Agent", userAgent())
+	if DispatchBridgeHostHeader != "" {
+		req.Host = DispatchBridgeHostHeader
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return bridgeUnreachableError{tidyErr(err)}
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return authError{}
+	case http.StatusNotFound:
+		return sessionNotFoundError{}
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return bridgeProbeUnknownError{res.Status}
+	}
+	return nil
+}
+
 // FunctionCallObserver observes function call requests and responses.
 //
-// The observer may be invoked concurrently from many goroutines.
+// The observer may be invoked concurrently from many goroutines. The time
+// passed to the observer is when the request or response was observed,
+// which lets tests use deterministic timestamps.
 type FunctionCallObserver interface {
 	// ObserveRequest observes a RunRequest as it passes from the API through
 	// the CLI to the local application.
@@ -402,20 +960,56 @@ type FunctionCallObserver interface {
 	ObserveResponse(time.Time, *sdkv1.RunRequest, error, *http.Response, *sdkv1.RunResponse)
 }
 
+var (
+	_ FunctionCallObserver = (*callStore)(nil)
+	_ FunctionCallObserver = (*callInspector)(nil)
+	_ FunctionCallObserver = (*spanObserver)(nil)
+	_ FunctionCallObserver = observers(nil)
+)
+
+// handleRequest sends the request to the local application, and cleans it
+// up if the local application could not respond to it.
+func handleRequest(ctx context.Context, client *http.Client, url, requestID string, res *http.Response, observer FunctionCallObserver, cleaner *requestCleaner) {
+	err := invoke(ctx, client, url, requestID, res, observer)
+	res.Body.Close()
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn(err.Error())
+		}
+
+		// Notify upstream if we're unable to generate a response,
+		// either because the local application can't be contacted,
+		// is misbehaving, the function call was canceled, or a shutdown
+		// sequence has been initiated.
+		if err := cleaner.cleanup(requestID); err != nil {
+			slog.Debug(err.Error())
+		}
+	}
+}
+
+// errEndpointTimeout is returned when the local application doesn't
+// respond within --endpoint-timeout.
+var errEndpointTimeout = errors.New("local application did not respond within --endpoint-timeout")
+
 func invoke(ctx context.Context, client *http.Client, url, requestID string, bridgeGetRes *http.Response, observer FunctionCallObserver) error {
+	if requestID == "" {
+		// Don't call the function if the response can't be sent.
+		return errMissingRequestID
+	}
+
 	logger := slog.Default()
 	if Verbose {
 		logger = slog.With("request_id", requestID)
 	}
 
-	logger.Debug("sending request to local application", "endpoint", LocalEndpoint)
+	endpoint := nextEndpoint()
+	logger.Debug("sending request to local application", "endpoint", endpoint)
 
 	// Extract the nested request header/body.
 	endpointReq, err := http.ReadRequest(bufio.NewReader(bridgeGetRes.Body))
 	if err != nil {
 		return fmt.Errorf("invalid response from Dispatch API: %v", err)
 	}
-	endpointReq = endpointReq.WithContext(ctx)
 
 	// Buffer the request body in memory.
 	endpointReqBody := &bytes.Buffer{}
@@ -433,12 +1027,21 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 	}
 	endpointReq.Body, _ = endpointReq.GetBody()
 
-	// Parse the request body from the API.
+	// Parse the request body from the API. The body is forwarded to the
+	// local application as is, so only a copy is decompressed.
+	reqBody, err := decodeBody(endpointReq.Header, endpointReqBody.Bytes())
+	if err != nil {
+		return fmt.Errorf("invalid response from Dispatch API: %v", err)
+	}
 	var runRequest sdkv1.RunRequest
-	if err := proto.Unmarshal(endpointReqBody.Bytes(), &runRequest); err != nil {
+	if err := proto.Unmarshal(reqBody, &runRequest); err != nil {
 		return fmt.Errorf("invalid response from Dispatch API: %v", err)
 	}
 	logger.Debug("parsed request", "function", runRequest.Function, "dispatch_id", runRequest.DispatchId)
+	if created := runRequest.GetCreationTime(); !since.IsZero() && created != nil && created.AsTime().Before(since) {
+		logger.Info("skipping function call created before --since", "function", runRequest.Function, "dispatch_id", runRequest.DispatchId, "creation_time", created.AsTime())
+		return sendResponse(ctx, client, url, requestID, skippedResponse(), logger)
+	}
 	switch d := runRequest.Directive.(type) {
 	case *sdkv1.RunRequest_Input:
 		if Verbose {
@@ -449,6 +1052,24 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 	case *sdkv1.RunRequest_PollResult:
 		logger.Info("resuming function", "function", runRequest.Function)
 	}
+
+	// Allow the function call to be canceled from the TUI while the
+	// request is in flight.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	defer inFlightCalls.track(DispatchID(runRequest.DispatchId), cancel)()
+
+	// Limit the time allowed for the local application to respond, and
+	// let it know about the deadline so that it can stop early.
+	endpointCtx := ctx
+	if EndpointTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		endpointCtx, cancelTimeout = context.WithTimeoutCause(ctx, EndpointTimeout, errEndpointTimeout)
+		defer cancelTimeout()
+		endpointReq.Header.Set("Request-Timeout", strconv.FormatInt(int64(math.Ceil(EndpointTimeout.Seconds())), 10))
+	}
+	endpointReq = endpointReq.WithContext(endpointCtx)
+
 	if observer != nil {
 		observer.ObserveRequest(time.Now(), &runRequest)
 	}
@@ -458,13 +1079,25 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 	endpointReq.RequestURI = ""
 
 	// Forward the request to the local application endpoint.
-	endpointReq.Host = LocalEndpoint
-	endpointReq.URL.Scheme = "http"
-	endpointReq.URL.Host = LocalEndpoint
-	endpointRes, err := client.Do(endpointReq)
+	endpointClient, endpointHost, endpointScheme := newEndpointClient(client, endpoint)
+	if EndpointTimeout > 0 {
+		// The deadline of the request takes precedence over the timeout
+		// of the client, which may be shorter.
+		c := *endpointClient
+		c.Timeout = 0
+		endpointClient = &c
+	}
+	endpointReq.Host = endpointHost
+	endpointReq.URL.Scheme = endpointScheme
+	endpointReq.URL.Host = endpointHost
+	endpointRes, err := endpointClient.Do(endpointReq)
 	now := time.Now()
 	if err != nil {
-		err = fmt.Errorf("can't connect to %s: %v (check that -e,--endpoint is correct)", LocalEndpoint, tidyErr(err))
+		if cause := context.Cause(endpointCtx); errors.Is(cause, errCallCanceled) || errors.Is(cause, errEndpointTimeout) {
+			err = cause
+		} else {
+			err = fmt.Errorf("can't connect to %s: %v (check that -e,--endpoint is correct)", endpoint, tidyErr(err))
+		}
 		if observer != nil {
 			observer.ObserveResponse(now, &runRequest, err, nil, nil)
 		}
@@ -479,7 +1112,11 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 	_, err = io.Copy(endpointResBody, endpointRes.Body)
 	endpointRes.Body.Close()
 	if err != nil {
-		err = fmt.Errorf("read error from %s: %v", LocalEndpoint, tidyErr(err))
+		if cause := context.Cause(endpointCtx); errors.Is(cause, errCallCanceled) || errors.Is(cause, errEndpointTimeout) {
+			err = cause
+		} else {
+			err = fmt.Errorf("read error from %s: %v", endpoint, tidyErr(err))
+		}
 		if observer != nil {
 			observer.ObserveResponse(now, &runRequest, err, endpointRes, nil)
 		}
@@ -488,18 +1125,23 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 	endpointRes.Body = io.NopCloser(endpointResBody)
 	endpointRes.ContentLength = int64(endpointResBody.Len())
 
-	// Parse the response body from the API.
+	// Parse the response body from the local application. As with the
+	// request, the body is sent back to Dispatch as is.
 	if endpointRes.StatusCode == http.StatusOK && endpointRes.Header.Get("Content-Type") == "application/proto" {
 		var runResponse sdkv1.RunResponse
-		if err := proto.Unmarshal(endpointResBody.Bytes(), &runResponse); err != nil {
-			err = fmt.Errorf("invalid response from %s: %v", LocalEndpoint, tidyErr(err))
+		resBody, err := decodeBody(endpointRes.Header, endpointResBody.Bytes())
+		if err == nil {
+			err = proto.Unmarshal(resBody, &runResponse)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid response from %s: %v", endpoint, tidyErr(err))
 			if observer != nil {
 				observer.ObserveResponse(now, &runRequest, err, endpointRes, nil)
 			}
 			return err
 		}
-		switch runResponse.Status {
-		case sdkv1.Status_STATUS_OK:
+		switch {
+		case runResponse.Status == sdkv1.Status_STATUS_OK:
 			switch d := runResponse.Directive.(type) {
 			case *sdkv1.RunResponse_Exit:
 				if d.Exit.TailCall != nil {
@@ -512,6 +1154,8 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 			case *sdkv1.RunResponse_Poll:
 				logger.Info("function yielded", "function", runRequest.Function)
 			}
+		case isSuccessStatus(runResponse.Status):
+			logger.Info("function call returned an expected status", "function", runRequest.Function, "status", statusString(runResponse.Status))
 		default:
 			err := runResponse.GetExit().GetResult().GetError()
 			logger.Warn("function call failed", "function", runRequest.Function, "status", statusString(runResponse.Status), "error_type", err.GetType(), "error_message", err.GetMessage())
@@ -527,10 +1171,16 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 		}
 	}
 
+	return sendResponse(ctx, client, url, requestID, endpointRes, logger)
+}
+
+// sendResponse sends the response of the local application to the Dispatch
+// API.
+func sendResponse(ctx context.Context, client *http.Client, url, requestID string, res *http.Response, logger *slog.Logger) error {
 	// Use io.Pipe to convert the response writer into an io.Reader.
 	pr, pw := io.Pipe()
 	go func() {
-		err := endpointRes.Write(pw)
+		err := res.Write(pw)
 		pw.CloseWithError(err)
 	}()
 
@@ -542,6 +1192,7 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 		panic(err)
 	}
 	bridgePostReq.Header.Add("Authorization", "Bearer "+DispatchApiKey)
+	bridgePostReq.Header.Set("User-Agent", userAgent())
 	bridgePostReq.Header.Add("X-Request-ID", requestID)
 	if DispatchBridgeHostHeader != "" {
 		bridgePostReq.Host = DispatchBridgeHostHeader
@@ -550,6 +1201,7 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 	if err != nil {
 		return fmt.Errorf("failed to contact Dispatch API or send response: %v", err)
 	}
+	bridgePostRes.Body.Close()
 	switch bridgePostRes.StatusCode {
 	case http.StatusAccepted:
 		return nil
@@ -563,7 +1215,61 @@ func invoke(ctx context.Context, client *http.Client, url, requestID string, bri
 	}
 }
 
+// skippedResponse returns the response sent to the Dispatch API for the
+// function calls created before --since. The function calls fail
+// permanently: cleaning them up instead would have them redelivered.
+func skippedResponse() *http.Response {
+	b, err := proto.Marshal(&sdkv1.RunResponse{
+		Status: sdkv1.Status_STATUS_PERMANENT_ERROR,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{
+			Result: &sdkv1.CallResult{Error: &sdkv1.Error{
+				Type:    "SkippedError",
+				Message: "function call was created before --since",
+			}},
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/proto"}},
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+	}
+}
+
+// decodeBody returns the body decompressed according to the
+// Content-Encoding header. Only gzip is supported, which is the encoding
+// that HTTP servers and clients commonly use.
+func decodeBody(header http.Header, body []byte) ([]byte, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+}
+
 func deleteRequest(ctx context.Context, client *http.Client, url, requestID string) error {
+	if requestID == "" {
+		// A DELETE without a request ID could be interpreted by the bridge
+		// as targeting another request.
+		return errMissingRequestID
+	}
 	slog.Debug("cleaning up request", "request_id", requestID)
 
 	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
@@ -571,6 +1277,7 @@ func deleteRequest(ctx context.Context, client *http.Client, url, requestID stri
 		panic(err)
 	}
 	req.Header.Add("Authorization", "Bearer "+DispatchApiKey)
+	req.Header.Set("User-Agent", userAgent())
 	req.Header.Add("X-Request-ID", requestID)
 	if DispatchBridgeHostHeader != "" {
 		req.Host = DispatchBridgeHostHeader
@@ -593,9 +1300,213 @@ func deleteRequest(ctx context.Context, client *http.Client, url, requestID stri
 	}
 }
 
+// endpointAddr formats the local application endpoint as exported to the
+// application in DISPATCH_ENDPOINT_ADDR. Some SDKs expect a host:port,
+// while others expect a URL.
+func endpointAddr(format, endpoint string) string {
+	if hostPort, ok := strings.CutPrefix(endpoint, "https://"); ok {
+		if format == "url" {
+			return endpoint
+		}
+		return hostPort
+	}
+	if format == "url" && !strings.HasPrefix(endpoint, "unix://") {
+		return "http://" + endpoint
+	}
+	return endpoint
+}
+
+// newEndpointClient returns the client used to send requests to the local
+// application endpoint, and the host and scheme of the requests.
+func newEndpointClient(client *http.Client, endpoint string) (endpointClient *http.Client, host, scheme string) {
+	endpointClient, host, scheme = client, endpoint, "http"
+	if network, address := endpointNetwork(endpoint); network == "unix" {
+		endpointClient = &http.Client{
+			Transport:     unixSocketTransport(address),
+			Timeout:       client.Timeout,
+			CheckRedirect: client.CheckRedirect,
+		}
+		// The host is ignored when connecting to a Unix socket, but it's
+		// still required to form a valid request.
+		host = "localhost"
+	} else if isHTTPSEndpoint(endpoint) {
+		endpointClient = &http.Client{
+			Transport:     tlsTransport(endpointTLSConfig),
+			Timeout:       client.Timeout,
+			CheckRedirect: client.CheckRedirect,
+		}
+		host, scheme = address, "https"
+	}
+	if EndpointHTTP2 {
+		endpointClient = &http.Client{
+			Transport:     http2Transport(endpoint),
+			Timeout:       client.Timeout,
+			CheckRedirect: client.CheckRedirect,
+		}
+	}
+	return endpointClient, host, scheme
+}
+
+// Transports speaking HTTP/2 to the local application endpoint, by
+// endpoint, for --endpoint-http2.
+var http2Transports sync.Map
+
+// http2Transport returns a transport speaking HTTP/2 to the endpoint, over
+// TLS for https:// endpoints, or in cleartext (h2c) otherwise.
+func http2Transport(endpoint string) http.RoundTripper {
+	if t, ok := http2Transports.Load(endpoint); ok {
+		return t.(http.RoundTripper)
+	}
+	transport := &http2.Transport{}
+	if isHTTPSEndpoint(endpoint) {
+		transport.TLSClientConfig = endpointTLSConfig
+	} else {
+		// Dial the endpoint without TLS, rather than the address in the
+		// request URL, so that Unix sockets are supported as well.
+		network, address := endpointNetwork(endpoint)
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, _, _ string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		}
+	}
+	t, _ := http2Transports.LoadOrStore(endpoint, transport)
+	return t.(http.RoundTripper)
+}
+
+// splitEndpoints returns the addresses of the local application endpoints,
+// from the comma-separated value of --endpoint. The endpoints must all be
+// of the same kind (host:port, https:// or unix://), since they're expected
+// to be instances of the same application.
+func splitEndpoints(value string) ([]string, error) {
+	endpoints := strings.Split(value, ",")
+	for i, endpoint := range endpoints {
+		endpoints[i] = strings.TrimSpace(endpoint)
+		if endpoints[i] == "" {
+			return nil, fmt.Errorf("invalid --endpoint: %q (empty address)", value)
+		}
+	}
+	network, _ := endpointNetwork(endpoints[0])
+	https := isHTTPSEndpoint(endpoints[0])
+	for _, endpoint := range endpoints[1:] {
+		if n, _ := endpointNetwork(endpoint); n != network || isHTTPSEndpoint(endpoint) != https {
+			return nil, fmt.Errorf("invalid --endpoint: %q (addresses must all be host:port, https:// or unix://)", value)
+		}
+	}
+	return endpoints, nil
+}
+
+// localEndpoints are the addresses of the local application endpoints,
+// parsed from --endpoint in PreRunE.
+var localEndpoints []string
+
+// endpointCounter is used to dispatch requests to the local application
+// endpoints in turn, see nextEndpoint.
+var endpointCounter atomic.Uint64
+
+// nextEndpoint returns the local application endpoint that the next request
+// is sent to. When multiple endpoints are configured, requests are
+// distributed across them in a round-robin fashion.
+func nextEndpoint() string {
+	switch len(localEndpoints) {
+	case 0:
+		return LocalEndpoint
+	case 1:
+		return localEndpoints[0]
+	}
+	return localEndpoints[(endpointCounter.Add(1)-1)%uint64(len(localEndpoints))]
+}
+
+// endpointNetwork returns the network and address to dial to connect to
+// the local application endpoint. The endpoint is either a host:port, or
+// the path of a Unix socket prefixed with unix://. A host:port may be
+// prefixed with https:// when the endpoint is served over TLS.
+func endpointNetwork(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return "unix", path
+	}
+	return "tcp", strings.TrimPrefix(addr, "https://")
+}
+
+func isHTTPSEndpoint(addr string) bool {
+	return strings.HasPrefix(addr, "https://")
+}
+
+// Transports connecting to Unix sockets, by path, so that connections to
+// the local application endpoint are reused across requests.
+var unixSocketTransports sync.Map
+
+func unixSocketTransport(path string) http.RoundTripper {
+	if t, ok := unixSocketTransports.Load(path); ok {
+		return t.(http.RoundTripper)
+	}
+	t, _ := unixSocketTransports.LoadOrStore(path, &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	})
+	return t.(http.RoundTripper)
+}
+
+// endpointTLSConfig is the TLS configuration used to connect to https://
+// endpoints, see newEndpointTLSConfig.
+var endpointTLSConfig *tls.Config
+
+// newEndpointTLSConfig returns the TLS configuration used to connect to the
+// endpoint, with the client certificate (for mutual TLS) and the CA
+// certificate to trust, if set. It returns nil if the endpoint is not
+// served over TLS.
+func newEndpointTLSConfig(endpoint, certFile, keyFile, caFile string) (*tls.Config, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("--endpoint-client-cert and --endpoint-client-key must be used together")
+	}
+	if !isHTTPSEndpoint(endpoint) {
+		if certFile != "" || caFile != "" {
+			return nil, fmt.Errorf("--endpoint-client-cert, --endpoint-client-key and --endpoint-ca-cert require an https:// endpoint (got %s)", endpoint)
+		}
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load endpoint client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load endpoint CA certificate: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to load endpoint CA certificate: no certificates found in %s", caFile)
+		}
+	}
+	return config, nil
+}
+
+// Transports connecting to https:// endpoints, by TLS configuration, so that
+// connections to the local application endpoint are reused across requests.
+var tlsTransports sync.Map
+
+func tlsTransport(config *tls.Config) http.RoundTripper {
+	if t, ok := tlsTransports.Load(config); ok {
+		return t.(http.RoundTripper)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	t, _ := tlsTransports.LoadOrStore(config, transport)
+	return t.(http.RoundTripper)
+}
+
 func checkEndpoint(addr string, timeout time.Duration) bool {
 	slog.Debug("checking endpoint", "addr", addr)
-	conn, err := net.DialTimeout("tcp", addr, timeout)
+	network, address := endpointNetwork(addr)
+	conn, err := net.DialTimeout(network, address, timeout)
 	if err != nil {
 		slog.Debug("endpoint could not be contacted", "addr", addr, "err", err)
 		return false
@@ -605,6 +1516,43 @@ func checkEndpoint(addr string, timeout time.Duration) bool {
 	return true
 }
 
+// probeEndpoint makes a benign request to the local application endpoint,
+// and returns an error describing why the response doesn't look like it
+// comes from a Dispatch endpoint, if it doesn't. Dispatch SDKs serve the
+// Run method of the function service and reject GET requests to it, while
+// unrelated services usually don't know about the path. The check is best
+// effort, and only used to give better guidance when the address is in use.
+func probeEndpoint(endpoint string, timeout time.Duration) error {
+	client, host, scheme := newEndpointClient(&http.Client{
+		Transport:     http.DefaultTransport,
+		Timeout:       timeout,
+		CheckRedirect: checkRedirect,
+	}, endpoint)
+
+	req, err := http.NewRequest("GET", scheme+"://"+host+"/dispatch.sdk.v1.FunctionService/Run", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent())
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("it did not respond to an HTTP request: %v", tidyErr(err))
+	}
+	res.Body.Close()
+
+	var server string
+	if s := res.Header.Get("Server"); s != "" {
+		server = fmt.Sprintf(" (server: %s)", s)
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("it does not serve the Dispatch function service%s", server)
+	case res.StatusCode < 300:
+		return fmt.Errorf("it accepted an invalid request to the Dispatch function service with status %d%s", res.StatusCode, server)
+	}
+	return nil
+}
+
 func withoutEnv(env []string, prefixes ...string) []string {
 	return slices.DeleteFunc(env, func(v string) bool {
 		for _, prefix := range prefixes {
@@ -616,17 +1564,128 @@ func withoutEnv(env []string, prefixes ...string) []string {
 	})
 }
 
-func printPrefixedLines(w io.Writer, r io.Reader, prefix []byte) {
+// onlyEnv returns the environment variables whose names start with one of
+// the prefixes.
+func onlyEnv(env []string, prefixes ...string) []string {
+	return slices.DeleteFunc(env, func(v string) bool {
+		name, _, _ := strings.Cut(v, "=")
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// printEnv writes the environment variables to w, one per line, masking
+// the values of variables that look like secrets.
+func printEnv(w io.Writer, env []string) {
+	var b bytes.Buffer
+	for _, v := range env {
+		name, value, _ := strings.Cut(v, "=")
+		if isSecretEnv(name) {
+			value = maskSecret(value)
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	_, _ = w.Write(b.Bytes())
+}
+
+func isSecretEnv(name string) bool {
+	name = strings.ToUpper(name)
+	for _, s := range []string{"KEY", "SECRET", "TOKEN", "PASSWORD"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "****"
+}
+
+// prefixedLinesOptions configures printPrefixedLines.
+type prefixedLinesOptions struct {
+	// Insert the time at which each line was read after the prefix, in the
+	// same format as the Dispatch logs so that the lines of both sources
+	// stay aligned.
+	timestamps bool
+
+	// Lines longer than maxLineSize are split into multiple lines rather
+	// than dropped.
+	maxLineSize int
+
+	// Remove the ANSI escape sequences from the lines.
+	stripColor bool
+}
+
+// printPrefixedLines writes the lines read from r to w, each preceded by
+// the prefix. A trailing line without a newline is written when r is
+// closed.
+//
+// Lines containing ANSI escape sequences are preceded and followed by a
+// reset sequence, so that the colors of the local application don't bleed
+// into the prefix, while being preserved within the line.
+func printPrefixedLines(w io.Writer, r io.Reader, prefix []byte, options prefixedLinesOptions) {
+	maxLineSize := options.maxLineSize
+	if maxLineSize <= 0 {
+		maxLineSize = bufio.MaxScanTokenSize
+	}
 	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(maxLineSize, bufio.MaxScanTokenSize)), maxLineSize)
+	scanner.Split(splitLines(maxLineSize))
 	buffer := bytes.NewBuffer(nil)
-	buffer.Write(prefix)
 
 	for scanner.Scan() {
-		buffer.Truncate(len(prefix))
-		buffer.Write(scanner.Bytes())
+		line := scanner.Bytes()
+		colored := bytes.IndexByte(line, ansi.Marker) >= 0
+		if colored && options.stripColor {
+			line, colored = []byte(clearANSI(string(line))), false
+		}
+
+		buffer.Reset()
+		if colored {
+			buffer.WriteString(ansiReset)
+		}
+		buffer.Write(prefix)
+		if options.timestamps {
+			buffer.WriteString(logTimeStyle.Render(time.Now().Format(logTimeFormat)))
+			buffer.WriteByte(' ')
+		}
+		buffer.Write(line)
+		if colored {
+			buffer.WriteString(ansiReset)
+		}
 		buffer.WriteByte('\n')
 		_, _ = w.Write(buffer.Bytes())
 	}
+	if err := scanner.Err(); err != nil {
+		slog.Debug("failed to read local application logs", "error", err)
+	}
+}
+
+// ansiReset is the escape sequence resetting all the text attributes.
+const ansiReset = "\x1b[0m"
+
+// splitLines is a bufio.SplitFunc like bufio.ScanLines, except that lines
+// longer than maxLineSize are split instead of failing with
+// bufio.ErrTooLong.
+func splitLines(maxLineSize int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = bufio.ScanLines(data, atEOF)
+		if advance == 0 && token == nil && err == nil && len(data) >= maxLineSize {
+			return maxLineSize, data[:maxLineSize], nil
+		}
+		return advance, token, err
+	}
 }
 
 func pad(s string, width int) string {
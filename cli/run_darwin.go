@@ -2,6 +2,7 @@ package cli
 
 import (
 	"os"
+	"os/signal"
 	"syscall"
 )
 
@@ -14,3 +15,11 @@ func killProcess(process *os.Process, signal os.Signal) {
 	// in the process group.
 	_ = syscall.Kill(-process.Pid, signal.(syscall.Signal))
 }
+
+func notifyDumpSignal(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGINFO)
+}
+
+func trackProcess(process *os.Process) error {
+	return nil
+}
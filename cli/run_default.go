@@ -1,4 +1,4 @@
-//go:build !linux && !darwin
+//go:build !linux && !darwin && !windows
 
 package cli
 
@@ -12,3 +12,9 @@ func setSysProcAttr(attr *syscall.SysProcAttr) {}
 func killProcess(process *os.Process, _ os.Signal) {
 	process.Kill()
 }
+
+func notifyDumpSignal(c chan<- os.Signal) {}
+
+func trackProcess(process *os.Process) error {
+	return nil
+}
@@ -1,19 +1,42 @@
 package cli
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var dispatchBinary = filepath.Join("../build", runtime.GOOS, runtime.GOARCH, "dispatch")
@@ -101,6 +124,107 @@ func TestRunCommand(t *testing.T) {
 			assert.Equal(t, "morty_smith", result, fmt.Sprintf("Expected 'printenv | morty_smith' in the output, got 'printenv | %s'", result))
 		})
 
+		t.Run("Run with print-env", func(t *testing.T) {
+			t.Parallel()
+
+			buff, err := execRunCommand(&[]string{}, "run", "--print-env", "--", "true")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.Contains(t, buff.String(), "DISPATCH_API_KEY=****\n")
+			assert.NotContains(t, buff.String(), "00000000")
+			assert.Regexp(t, "DISPATCH_ENDPOINT_URL=bridge://[0-9a-zA-Z]+\n", buff.String())
+			assert.Contains(t, buff.String(), "DISPATCH_ENDPOINT_ADDR=127.0.0.1:8000\n")
+		})
+
+		t.Run("Run with env prefix", func(t *testing.T) {
+			t.Parallel()
+
+			envVars := []string{"CHARACTER=morty_smith", "CHARACTER_FRIEND=rick_sanchez", "PLANET=earth", "SECRET_TOKEN=plumbus"}
+			buff, err := execRunCommand(&envVars, "run", "--env-prefix", "CHARACTER", "--env-prefix", "PLANET", "--", "printenv")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.Contains(t, buff.String(), "printenv | CHARACTER=morty_smith\n")
+			assert.Contains(t, buff.String(), "printenv | CHARACTER_FRIEND=rick_sanchez\n")
+			assert.Contains(t, buff.String(), "printenv | PLANET=earth\n")
+			assert.Contains(t, buff.String(), "printenv | DISPATCH_ENDPOINT_ADDR=127.0.0.1:8000\n")
+			assert.NotContains(t, buff.String(), "SECRET_TOKEN")
+			assert.NotContains(t, buff.String(), "printenv | PATH=")
+		})
+
+		t.Run("Run with endpoint addr format", func(t *testing.T) {
+			t.Parallel()
+
+			for format, addr := range map[string]string{
+				"host-port": "127.0.0.1:8000",
+				"url":       "http://127.0.0.1:8000",
+			} {
+				buff, err := execRunCommand(&[]string{}, "run", "--endpoint-addr-format", format, "--", "printenv", "DISPATCH_ENDPOINT_ADDR")
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+
+				result, found := findEnvVariableInLogs(&buff)
+				if !found {
+					t.Fatalf("Expected printenv in the output: %s", buff.String())
+				}
+				assert.Equal(t, addr, result)
+			}
+		})
+
+		t.Run("Run with missing session", func(t *testing.T) {
+			t.Parallel()
+
+			bridge := newStubBridge("expired")
+			defer bridge.Close()
+
+			buff, err := execRunCommandWithBridge(bridge, &[]string{}, "run", "--session", "expired", "--", "printenv", "DISPATCH_ENDPOINT_URL")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.Contains(t, buff.String(), "Error: session expired no longer exists")
+			_, found := findEnvVariableInLogs(&buff)
+			assert.False(t, found)
+		})
+
+		t.Run("Run with missing session and new-on-missing", func(t *testing.T) {
+			t.Parallel()
+
+			bridge := newStubBridge("expired")
+			defer bridge.Close()
+
+			buff, err := execRunCommandWithBridge(bridge, &[]string{}, "run", "--session", "expired", "--new-on-missing", "--", "printenv", "DISPATCH_ENDPOINT_URL")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.Contains(t, buff.String(), "Session expired no longer exists, starting a new session.")
+			result, found := findEnvVariableInLogs(&buff)
+			if !found {
+				t.Fatalf("Expected printenv in the output: %s", buff.String())
+			}
+			assert.Regexp(t, "^bridge://[0-9a-zA-Z]+$", result)
+			assert.NotEqual(t, "bridge://expired", result)
+		})
+
+		t.Run("Run with dump signal", func(t *testing.T) {
+			if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+				t.Skip("SIGUSR1 is not supported on " + runtime.GOOS)
+			}
+			t.Parallel()
+
+			buff, err := execRunCommand(&[]string{}, "run", "--", "sh", "-c", "kill -USR1 $PPID; sleep 1")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.Contains(t, buff.String(), "0 in-flight function call(s)\n")
+		})
+
 		t.Run("Run with env variable in local env vars has priority over the one in the env file", func(t *testing.T) {
 			// Do not use t.Parallel() here as we are manipulating the environment!
 
@@ -130,12 +254,19 @@ func TestRunCommand(t *testing.T) {
 }
 
 func execRunCommand(envVars *[]string, arg ...string) (bytes.Buffer, error) {
+	bridge := newStubBridge()
+	defer bridge.Close()
+	return execRunCommandWithBridge(bridge, envVars, arg...)
+}
+
+func execRunCommandWithBridge(bridge *httptest.Server, envVars *[]string, arg ...string) (bytes.Buffer, error) {
 	// Create a context with a timeout to ensure the process doesn't run indefinitely
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// add the api key to the arguments so the command can run without `dispatch login` being run first
-	arg = append(arg[:1], append([]string{"--api-key", "00000000"}, arg[1:]...)...)
+	// add the api key to the arguments so the command can run without `dispatch login` being run first,
+	// and point the command at a stub bridge so that it doesn't depend on network access
+	arg = append(arg[:1], append([]string{"--api-key", "00000000", "--bridge-url", bridge.URL}, arg[1:]...)...)
 
 	// Set up the command
 	cmd := exec.CommandContext(ctx, dispatchBinary, arg...)
@@ -175,6 +306,27 @@ func execRunCommand(envVars *[]string, arg ...string) (bytes.Buffer, error) {
 	return errBuf, nil
 }
 
+// newStubBridge creates a server that accepts any API key and never has
+// function calls to dispatch. The server reports that the given sessions
+// don't exist.
+func newStubBridge(missingSessions ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, session := range missingSessions {
+			if r.URL.Path == "/sessions/"+session {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+		if r.Method == "GET" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+}
+
 func createEnvFile(path string, content []byte) (string, error) {
 	envFile := filepath.Join(path, "test.env")
 	err := os.WriteFile(envFile, content, 0600)
@@ -198,3 +350,933 @@ func findEnvVariableInLogs(buf *bytes.Buffer) (string, bool) {
 	}
 	return result, found
 }
+
+func TestProbeBridge(t *testing.T) {
+	t.Run("Bridge is reachable", func(t *testing.T) {
+		t.Parallel()
+
+		bridge := newStubBridge()
+		defer bridge.Close()
+
+		err := probeBridge(context.Background(), http.DefaultClient, bridge.URL+"/sessions/test")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Bridge is unreachable", func(t *testing.T) {
+		t.Parallel()
+
+		bridge := newStubBridge()
+		url := bridge.URL + "/sessions/test"
+		bridge.Close()
+
+		err := probeBridge(context.Background(), http.DefaultClient, url)
+		assert.ErrorAs(t, err, &bridgeUnreachableError{})
+		assert.Contains(t, err.Error(), "--bridge-url")
+	})
+
+	t.Run("Session no longer exists", func(t *testing.T) {
+		t.Parallel()
+
+		bridge := newStubBridge("expired")
+		defer bridge.Close()
+
+		err := probeBridge(context.Background(), http.DefaultClient, bridge.URL+"/sessions/expired")
+		assert.ErrorAs(t, err, &sessionNotFoundError{})
+	})
+
+	t.Run("Bridge rejects the API key", func(t *testing.T) {
+		t.Parallel()
+
+		bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer bridge.Close()
+
+		err := probeBridge(context.Background(), http.DefaultClient, bridge.URL+"/sessions/test")
+		assert.True(t, errors.As(err, &authError{}))
+	})
+
+	t.Run("Bridge only supports GET", func(t *testing.T) {
+		t.Parallel()
+
+		bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}))
+		defer bridge.Close()
+
+		err := probeBridge(context.Background(), http.DefaultClient, bridge.URL+"/sessions/test")
+		assert.ErrorAs(t, err, &bridgeProbeUnknownError{})
+		assert.Contains(t, err.Error(), "405 Method Not Allowed")
+	})
+}
+
+func TestResumeCommand(t *testing.T) {
+	got := resumeCommand("dispatch", "2XkcXvPzEaF1WJz5T1bEzq", []string{"python3", "main.py"})
+	assert.Equal(t, "dispatch run --session 2XkcXvPzEaF1WJz5T1bEzq -- python3 main.py", got)
+}
+
+func TestPrintPrefixedLines(t *testing.T) {
+	stdout := strings.NewReader("first line\nsecond line\n")
+
+	var b bytes.Buffer
+	printPrefixedLines(&b, stdout, []byte("app | "), prefixedLinesOptions{})
+	assert.Equal(t, "app | first line\napp | second line\n", b.String())
+
+	stdout = strings.NewReader("first line\nsecond line\n")
+	b.Reset()
+	printPrefixedLines(&b, stdout, []byte("app | "), prefixedLinesOptions{timestamps: true})
+	assert.Regexp(t, regexp.MustCompile(`^`+
+		`app \| \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3} first line\n`+
+		`app \| \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3} second line\n$`), b.String())
+}
+
+func TestPrintPrefixedLongLines(t *testing.T) {
+	long := strings.Repeat("x", 100*1024)
+	stdout := strings.NewReader("first line\n" + long + "\npartial line")
+
+	var b bytes.Buffer
+	printPrefixedLines(&b, stdout, []byte("app | "), prefixedLinesOptions{maxLineSize: 64 * 1024})
+	assert.Equal(t, ""+
+		"app | first line\n"+
+		"app | "+long[:64*1024]+"\n"+
+		"app | "+long[64*1024:]+"\n"+
+		"app | partial line\n", b.String())
+}
+
+func TestPrintPrefixedColoredLines(t *testing.T) {
+	prefix := []byte("\x1b[34mapp\x1b[0m | ")
+	input := "\x1b[31merror: \x1b[1mfailed\nplain line\n"
+
+	var b bytes.Buffer
+	printPrefixedLines(&b, strings.NewReader(input), prefix, prefixedLinesOptions{})
+	assert.Equal(t, ""+
+		"\x1b[0m\x1b[34mapp\x1b[0m | \x1b[31merror: \x1b[1mfailed\x1b[0m\n"+
+		"\x1b[34mapp\x1b[0m | plain line\n", b.String())
+
+	b.Reset()
+	printPrefixedLines(&b, strings.NewReader(input), prefix, prefixedLinesOptions{stripColor: true})
+	assert.Equal(t, ""+
+		"\x1b[34mapp\x1b[0m | error: failed\n"+
+		"\x1b[34mapp\x1b[0m | plain line\n", b.String())
+}
+
+func TestPollLoop(t *testing.T) {
+	const concurrency = 4
+
+	var active int64
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&active, 1)
+		defer atomic.AddInt64(&active, -1)
+		<-r.Context().Done()
+	}))
+	defer bridge.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pollLoop(ctx, http.DefaultClient, bridge.URL, nil, func(error) {}, func(_ string, res *http.Response) {
+				res.Body.Close()
+			})
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&active) == concurrency
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll loops did not return after the context was canceled")
+	}
+}
+
+func TestPollLoopThrottled(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating pollRetryDelay!
+	retryDelay := pollRetryDelay
+	pollRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { pollRetryDelay = retryDelay })
+
+	pollFor := func(d time.Duration, retryAfter string) (int64, []error) {
+		var hits int64
+		bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&hits, 1)
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer bridge.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		defer cancel()
+
+		var errs []error
+		pollLoop(ctx, http.DefaultClient, bridge.URL, nil, func(err error) { errs = append(errs, err) }, func(_ string, res *http.Response) {
+			res.Body.Close()
+		})
+		return atomic.LoadInt64(&hits), errs
+	}
+
+	t.Run("Retry-After is honored", func(t *testing.T) {
+		hits, errs := pollFor(500*time.Millisecond, "1")
+		assert.Equal(t, int64(1), hits)
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, "Dispatch API is throttling requests, backing off for 1s", errs[0].Error())
+		}
+	})
+
+	t.Run("Exponential backoff", func(t *testing.T) {
+		// 10ms, 20ms, 40ms, 80ms, 160ms, 320ms... instead of 50 polls
+		// at the regular retry delay.
+		hits, errs := pollFor(500*time.Millisecond, "")
+		assert.LessOrEqual(t, hits, int64(7))
+		if assert.GreaterOrEqual(t, len(errs), 2) {
+			assert.Equal(t, throttledError{retryAfter: 10 * time.Millisecond}, errs[0])
+			assert.Equal(t, throttledError{retryAfter: 20 * time.Millisecond}, errs[1])
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestUnixSocketEndpoint(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the local endpoint!
+	socketPath := filepath.Join(t.TempDir(), "endpoint.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("Unix sockets are not supported: %v", err)
+	}
+	endpoint := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := proto.Marshal(&sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+		w.Header().Set("Content-Type", "application/proto")
+		w.Write(b)
+	}))
+	endpoint.Listener = l
+	endpoint.Start()
+	defer endpoint.Close()
+
+	localEndpoint := LocalEndpoint
+	LocalEndpoint = "unix://" + socketPath
+	t.Cleanup(func() { LocalEndpoint = localEndpoint })
+
+	assert.True(t, checkEndpoint(LocalEndpoint, time.Second))
+	assert.False(t, checkEndpoint("unix://"+filepath.Join(t.TempDir(), "missing.sock"), time.Second))
+
+	var response []byte
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer bridge.Close()
+
+	b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: "1"})
+	req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+	var body bytes.Buffer
+	req.Write(&body)
+
+	res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+	if err := invoke(context.Background(), http.DefaultClient, bridge.URL, "request-1", res, nil); err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(response), "HTTP/1.1 200 OK")
+}
+
+func TestGzipEndpointResponse(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the local endpoint!
+	var compressed bytes.Buffer
+	b, _ := proto.Marshal(&sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_PERMANENT_ERROR,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(b)
+	zw.Close()
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/proto")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer endpoint.Close()
+
+	localEndpoint := LocalEndpoint
+	LocalEndpoint = endpoint.Listener.Addr().String()
+	t.Cleanup(func() { LocalEndpoint = localEndpoint })
+
+	var response *http.Response
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, _ = http.ReadResponse(bufio.NewReader(r.Body), nil)
+		if response != nil {
+			b, _ := io.ReadAll(response.Body)
+			response.Body = io.NopCloser(bytes.NewReader(b))
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer bridge.Close()
+
+	b, _ = proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: "1"})
+	req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+	req.Header.Set("Accept-Encoding", "gzip")
+	var body bytes.Buffer
+	req.Write(&body)
+
+	calls := &callStore{}
+	res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+	if err := invoke(context.Background(), http.DefaultClient, bridge.URL, "request-1", res, calls); err != nil {
+		t.Fatal(err)
+	}
+
+	// The observer receives the decompressed response.
+	call := calls.calls["1"]
+	assert.True(t, call.done)
+	assert.Equal(t, sdkv1.Status_STATUS_PERMANENT_ERROR, call.lastStatus)
+
+	// The compressed response is forwarded to Dispatch unchanged.
+	if assert.NotNil(t, response) {
+		assert.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
+		forwarded, _ := io.ReadAll(response.Body)
+		assert.Equal(t, compressed.Bytes(), forwarded)
+	}
+}
+
+func TestMultipleEndpoints(t *testing.T) {
+	var hits [2]atomic.Int64
+	newEndpoint := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			b, _ := proto.Marshal(&sdkv1.RunResponse{
+				Status:    sdkv1.Status_STATUS_OK,
+				Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+			})
+			w.Header().Set("Content-Type", "application/proto")
+			w.Write(b)
+		}))
+	}
+	endpoint0, endpoint1 := newEndpoint(0), newEndpoint(1)
+	defer endpoint0.Close()
+	defer endpoint1.Close()
+
+	// Do not use t.Parallel() here as we are manipulating LocalEndpoint!
+	localEndpoint, endpoints := LocalEndpoint, localEndpoints
+	LocalEndpoint = endpoint0.Listener.Addr().String() + ", " + endpoint1.Listener.Addr().String()
+	t.Cleanup(func() { LocalEndpoint, localEndpoints = localEndpoint, endpoints })
+
+	var err error
+	localEndpoints, err = splitEndpoints(LocalEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer bridge.Close()
+
+	for i := 0; i < 4; i++ {
+		b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: strconv.Itoa(i)})
+		req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+		var body bytes.Buffer
+		req.Write(&body)
+
+		res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+		if err := invoke(context.Background(), http.DefaultClient, bridge.URL, "request-"+strconv.Itoa(i), res, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Requests are distributed evenly across the endpoints.
+	assert.Equal(t, int64(2), hits[0].Load())
+	assert.Equal(t, int64(2), hits[1].Load())
+}
+
+func TestInvalidEndpointResponse(t *testing.T) {
+	valid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := proto.Marshal(&sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+		w.Header().Set("Content-Type", "application/proto")
+		w.Write(b)
+	}))
+	defer valid.Close()
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/proto")
+		w.Write([]byte("not a protobuf message"))
+	}))
+	defer invalid.Close()
+
+	// Do not use t.Parallel() here as we are manipulating LocalEndpoint!
+	localEndpoint, endpoints := LocalEndpoint, localEndpoints
+	t.Cleanup(func() { LocalEndpoint, localEndpoints = localEndpoint, endpoints })
+	LocalEndpoint = valid.Listener.Addr().String() + "," + invalid.Listener.Addr().String()
+	localEndpoints = []string{valid.Listener.Addr().String(), invalid.Listener.Addr().String()}
+
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer bridge.Close()
+
+	var errs []error
+	for i := 0; i < 2; i++ {
+		b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: strconv.Itoa(i)})
+		req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+		var body bytes.Buffer
+		req.Write(&body)
+
+		res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+		if err := invoke(context.Background(), http.DefaultClient, bridge.URL, "request-"+strconv.Itoa(i), res, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// The error names the endpoint that sent the invalid response, not
+	// the whole --endpoint list.
+	if assert.Len(t, errs, 1) {
+		assert.Contains(t, errs[0].Error(), "invalid response from "+invalid.Listener.Addr().String()+":")
+		assert.NotContains(t, errs[0].Error(), valid.Listener.Addr().String())
+	}
+}
+
+func TestSplitEndpoints(t *testing.T) {
+	endpoints, err := splitEndpoints("localhost:8000")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"localhost:8000"}, endpoints)
+
+	endpoints, err = splitEndpoints("localhost:8000, localhost:8001")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"localhost:8000", "localhost:8001"}, endpoints)
+
+	_, err = splitEndpoints("localhost:8000,")
+	assert.ErrorContains(t, err, "empty address")
+
+	_, err = splitEndpoints("localhost:8000,unix:///tmp/app.sock")
+	assert.ErrorContains(t, err, "must all be")
+
+	_, err = splitEndpoints("localhost:8000,https://localhost:8001")
+	assert.ErrorContains(t, err, "must all be")
+}
+
+func TestProbeEndpoint(t *testing.T) {
+	t.Run("Dispatch endpoint", func(t *testing.T) {
+		endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/dispatch.sdk.v1.FunctionService/Run" {
+				w.WriteHeader(http.StatusNotFound)
+			} else if r.Method != "POST" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}))
+		defer endpoint.Close()
+
+		assert.NoError(t, probeEndpoint(endpoint.Listener.Addr().String(), time.Second))
+	})
+
+	t.Run("Unrelated service", func(t *testing.T) {
+		endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Server", "nginx")
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer endpoint.Close()
+
+		err := probeEndpoint(endpoint.Listener.Addr().String(), time.Second)
+		assert.ErrorContains(t, err, "does not serve the Dispatch function service (server: nginx)")
+	})
+
+	t.Run("Service accepting any request", func(t *testing.T) {
+		endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html></html>"))
+		}))
+		defer endpoint.Close()
+
+		err := probeEndpoint(endpoint.Listener.Addr().String(), time.Second)
+		assert.ErrorContains(t, err, "accepted an invalid request")
+	})
+
+	t.Run("Not an HTTP server", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				conn.Write([]byte("SSH-2.0-OpenSSH\r\n"))
+				conn.Close()
+			}
+		}()
+
+		err = probeEndpoint(l.Addr().String(), time.Second)
+		assert.ErrorContains(t, err, "did not respond to an HTTP request")
+	})
+}
+
+func TestInvokeSince(t *testing.T) {
+	var hits atomic.Int64
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		b, _ := proto.Marshal(&sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+		w.Header().Set("Content-Type", "application/proto")
+		w.Write(b)
+	}))
+	defer endpoint.Close()
+
+	var mu sync.Mutex
+	var methods []string
+	responses := map[string]*sdkv1.RunResponse{}
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		methods = append(methods, r.Method)
+		if r.Method == "POST" {
+			res, err := (&fakeBridge{}).readResponse(r.Body)
+			assert.NoError(t, err)
+			responses[r.Header.Get("X-Request-ID")] = res
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer bridge.Close()
+
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	localEndpoint, prevSince := LocalEndpoint, since
+	t.Cleanup(func() { LocalEndpoint, since = localEndpoint, prevSince })
+	LocalEndpoint = endpoint.Listener.Addr().String()
+
+	now := time.Now()
+	since = now.Add(-time.Minute)
+
+	cleaner := newRequestCleaner(http.DefaultClient, bridge.URL, 5*time.Second, 1)
+	defer cleaner.cancel()
+
+	invokeCreatedAt := func(requestID, id string, created time.Time) *callStore {
+		b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: id, CreationTime: timestamppb.New(created)})
+		req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+		var body bytes.Buffer
+		req.Write(&body)
+
+		calls := &callStore{}
+		res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+		handleRequest(context.Background(), http.DefaultClient, bridge.URL, requestID, res, calls, cleaner)
+		return calls
+	}
+
+	// Requests created before --since are skipped. They're answered with
+	// a permanent error rather than cleaned up, since cleaning them up
+	// would have them redelivered.
+	for _, requestID := range []string{"request-1", "request-1-redelivered"} {
+		calls := invokeCreatedAt(requestID, "1", now.Add(-time.Hour))
+		assert.Equal(t, int64(0), hits.Load())
+		assert.Empty(t, calls.calls)
+
+		mu.Lock()
+		if res := responses[requestID]; assert.NotNil(t, res, requestID) {
+			assert.Equal(t, sdkv1.Status_STATUS_PERMANENT_ERROR, res.Status)
+			assert.Equal(t, "SkippedError", res.GetExit().GetResult().GetError().GetType())
+		}
+		mu.Unlock()
+	}
+	mu.Lock()
+	assert.Equal(t, []string{"POST", "POST"}, methods)
+	mu.Unlock()
+
+	// Newer requests are sent to the local application.
+	calls := invokeCreatedAt("request-2", "2", now)
+	assert.Equal(t, int64(1), hits.Load())
+	assert.Contains(t, calls.calls, DispatchID("2"))
+	mu.Lock()
+	assert.Equal(t, sdkv1.Status_STATUS_OK, responses["request-2"].Status)
+	mu.Unlock()
+}
+
+func TestMissingRequestID(t *testing.T) {
+	var endpointHits atomic.Int64
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpointHits.Add(1)
+	}))
+	defer endpoint.Close()
+
+	b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: "1", RootDispatchId: "1"})
+	req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+	var body bytes.Buffer
+	req.Write(&body)
+
+	// The bridge serves a request without X-Request-Id.
+	var mu sync.Mutex
+	var methods []string
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		if r.Method == "GET" {
+			w.Write(body.Bytes())
+		}
+	}))
+	defer bridge.Close()
+
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	localEndpoint := LocalEndpoint
+	t.Cleanup(func() { LocalEndpoint = localEndpoint })
+	LocalEndpoint = endpoint.Listener.Addr().String()
+
+	ctx := context.Background()
+	requestID, res, err := poll(ctx, http.DefaultClient, bridge.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "", requestID)
+
+	cleaner := newRequestCleaner(http.DefaultClient, bridge.URL, 5*time.Second, 1)
+	defer cleaner.cancel()
+
+	calls := &callStore{}
+	handleRequest(ctx, http.DefaultClient, bridge.URL, requestID, res, calls, cleaner)
+
+	// The function isn't called, and neither a response nor a cleanup
+	// request is sent to the bridge.
+	assert.Equal(t, int64(0), endpointHits.Load())
+	assert.Empty(t, calls.calls)
+	mu.Lock()
+	assert.Equal(t, []string{"GET"}, methods)
+	mu.Unlock()
+
+	assert.ErrorIs(t, deleteRequest(ctx, http.DefaultClient, bridge.URL, ""), errMissingRequestID)
+}
+
+func TestEndpointTimeout(t *testing.T) {
+	requestTimeouts := make(chan string, 1)
+	unblock := make(chan struct{})
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestTimeouts <- r.Header.Get("Request-Timeout")
+		select {
+		case <-r.Context().Done():
+		case <-unblock:
+		}
+	}))
+	defer endpoint.Close()
+	defer close(unblock)
+
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer bridge.Close()
+
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	localEndpoint, endpointTimeout := LocalEndpoint, EndpointTimeout
+	t.Cleanup(func() { LocalEndpoint, EndpointTimeout = localEndpoint, endpointTimeout })
+	LocalEndpoint = endpoint.Listener.Addr().String()
+	EndpointTimeout = 100 * time.Millisecond
+
+	b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: "1", RootDispatchId: "1"})
+	req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+	var body bytes.Buffer
+	req.Write(&body)
+	res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+
+	calls := &callStore{}
+	start := time.Now()
+	err := invoke(context.Background(), http.DefaultClient, bridge.URL, "request-1", res, calls)
+	assert.ErrorIs(t, err, errEndpointTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// The deadline is rounded up to the second.
+	assert.Equal(t, "1", <-requestTimeouts)
+	assert.ErrorIs(t, calls.calls["1"].lastError, errEndpointTimeout)
+}
+
+func TestEndpointHTTP2(t *testing.T) {
+	protos := make(chan string, 1)
+	endpoint := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos <- r.Proto
+		b, _ := proto.Marshal(&sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+		w.Header().Set("Content-Type", "application/proto")
+		w.Write(b)
+	}), &http2.Server{}))
+	defer endpoint.Close()
+
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer bridge.Close()
+
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	localEndpoint, endpointHTTP2 := LocalEndpoint, EndpointHTTP2
+	t.Cleanup(func() { LocalEndpoint, EndpointHTTP2 = localEndpoint, endpointHTTP2 })
+	LocalEndpoint = endpoint.Listener.Addr().String()
+
+	invokeWith := func(id string) error {
+		b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: id, RootDispatchId: id})
+		req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+		var body bytes.Buffer
+		req.Write(&body)
+		res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+		return invoke(context.Background(), http.DefaultClient, bridge.URL, "request-"+id, res, nil)
+	}
+
+	// HTTP/1.1 is used by default.
+	assert.NoError(t, invokeWith("1"))
+	assert.Equal(t, "HTTP/1.1", <-protos)
+
+	EndpointHTTP2 = true
+	assert.NoError(t, invokeWith("2"))
+	assert.Equal(t, "HTTP/2.0", <-protos)
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	since, err := parseSince("2024-07-01T10:00:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-2*time.Hour), since)
+
+	since, err = parseSince("10m", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-10*time.Minute), since)
+
+	_, err = parseSince("yesterday", now)
+	assert.ErrorContains(t, err, "invalid --since")
+
+	_, err = parseSince("-10m", now)
+	assert.ErrorContains(t, err, "invalid --since")
+}
+
+func TestDecodeBody(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	b, err := decodeBody(http.Header{}, []byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	b, err = decodeBody(http.Header{"Content-Encoding": {"gzip"}}, compressed.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = decodeBody(http.Header{"Content-Encoding": {"gzip"}}, []byte("hello"))
+	assert.ErrorContains(t, err, "invalid gzip body")
+
+	_, err = decodeBody(http.Header{"Content-Encoding": {"br"}}, []byte("hello"))
+	assert.EqualError(t, err, "unsupported content encoding: br")
+}
+
+func TestEndpointClientCertificate(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the local endpoint!
+	dir := t.TempDir()
+
+	// Create a self-signed client certificate.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dispatch"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM := func(name, typ string, b []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	certFile := writePEM("client.crt", "CERTIFICATE", der)
+	keyFile := writePEM("client.key", "EC PRIVATE KEY", keyDER)
+
+	// Start an endpoint requiring client certificates.
+	endpoint := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := proto.Marshal(&sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+		w.Header().Set("Content-Type", "application/proto")
+		w.Write(b)
+	}))
+	endpoint.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  x509.NewCertPool(),
+	}
+	endpoint.TLS.ClientCAs.AddCert(clientCert)
+	endpoint.StartTLS()
+	defer endpoint.Close()
+	caFile := writePEM("server.crt", "CERTIFICATE", endpoint.Certificate().Raw)
+
+	localEndpoint, tlsConfig := LocalEndpoint, endpointTLSConfig
+	LocalEndpoint = "https://" + endpoint.Listener.Addr().String()
+	t.Cleanup(func() { LocalEndpoint, endpointTLSConfig = localEndpoint, tlsConfig })
+
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer bridge.Close()
+
+	invokeEndpoint := func() error {
+		b, _ := proto.Marshal(&sdkv1.RunRequest{Function: "my_function", DispatchId: "1"})
+		req, _ := http.NewRequest("POST", "http://local/dispatch.sdk.v1.FunctionService/Run", bytes.NewReader(b))
+		var body bytes.Buffer
+		req.Write(&body)
+		res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&body)}
+		return invoke(context.Background(), http.DefaultClient, bridge.URL, "request-1", res, nil)
+	}
+
+	t.Run("With client certificate", func(t *testing.T) {
+		endpointTLSConfig, err = newEndpointTLSConfig(LocalEndpoint, certFile, keyFile, caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, invokeEndpoint())
+	})
+
+	t.Run("Without client certificate", func(t *testing.T) {
+		endpointTLSConfig, err = newEndpointTLSConfig(LocalEndpoint, "", "", caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.ErrorContains(t, invokeEndpoint(), "can't connect to "+LocalEndpoint)
+	})
+
+	t.Run("Invalid options", func(t *testing.T) {
+		_, err := newEndpointTLSConfig(LocalEndpoint, certFile, "", "")
+		assert.EqualError(t, err, "--endpoint-client-cert and --endpoint-client-key must be used together")
+
+		_, err = newEndpointTLSConfig(LocalEndpoint, "", keyFile, "")
+		assert.EqualError(t, err, "--endpoint-client-cert and --endpoint-client-key must be used together")
+
+		_, err = newEndpointTLSConfig("127.0.0.1:8000", certFile, keyFile, "")
+		assert.EqualError(t, err, "--endpoint-client-cert, --endpoint-client-key and --endpoint-ca-cert require an https:// endpoint (got 127.0.0.1:8000)")
+
+		_, err = newEndpointTLSConfig(LocalEndpoint, keyFile, certFile, "")
+		assert.ErrorContains(t, err, "failed to load endpoint client certificate")
+	})
+}
+
+func TestCheckRedirect(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the FollowRedirects global!
+	var redirected int64
+	var authorization atomic.Value
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&redirected, 1)
+		authorization.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer target.Close()
+
+	// Use a different host than the redirect target.
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1)+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer bridge.Close()
+
+	client := &http.Client{CheckRedirect: checkRedirect}
+
+	followRedirects := FollowRedirects
+	t.Cleanup(func() { FollowRedirects = followRedirects })
+
+	t.Run("Redirects are not followed", func(t *testing.T) {
+		FollowRedirects = false
+
+		_, _, err := poll(context.Background(), client, bridge.URL+"/sessions/test")
+		assert.ErrorContains(t, err, "response code 307")
+		assert.Equal(t, int64(0), atomic.LoadInt64(&redirected))
+	})
+
+	t.Run("Redirects are followed without the API key", func(t *testing.T) {
+		FollowRedirects = true
+
+		_, _, err := poll(context.Background(), client, bridge.URL+"/sessions/test")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&redirected))
+		assert.Equal(t, "", authorization.Load())
+	})
+}
+
+func TestErrorsDoNotContainAPIKey(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the API key!
+	const apiKey = "sk_test_4f9c2b7e1d"
+	dispatchApiKey := DispatchApiKey
+	DispatchApiKey = apiKey
+	t.Cleanup(func() { DispatchApiKey = dispatchApiKey })
+
+	// The stub echoes the Authorization header in the response, as a
+	// misbehaving proxy could.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer failing.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	ctx := context.Background()
+	for _, url := range []string{failing.URL + "/sessions/test", closed.URL + "/sessions/test"} {
+		_, _, err := poll(ctx, http.DefaultClient, url)
+		if assert.Error(t, err) {
+			assert.NotContains(t, err.Error(), apiKey)
+		}
+
+		err = deleteRequest(ctx, http.DefaultClient, url, "request-1")
+		if assert.Error(t, err) {
+			assert.NotContains(t, err.Error(), apiKey)
+		}
+
+		if err := probeBridge(ctx, http.DefaultClient, url); err != nil {
+			assert.NotContains(t, err.Error(), apiKey)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+//go:build linux || darwin
+
+package cli
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillProcessGroup(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The grandchild (sleep) inherits stdout, so the pipe is only closed
+	// once both the child and the grandchild have exited.
+	cmd := exec.Command("sh", "-c", "sleep 60 & echo started; wait")
+	cmd.Stdout = w
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	setSysProcAttr(cmd.SysProcAttr)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "started\n", line)
+
+	killProcess(cmd.Process, syscall.SIGTERM)
+	_ = cmd.Wait()
+
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(br); err != nil {
+		t.Fatalf("grandchild process was not terminated: %v", err)
+	}
+}
+
+func TestKillShellWrapper(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Run the application through a shell wrapper, as in:
+	//
+	//	dispatch run -- sh -c "python app.py"
+	//
+	// The trailing command prevents the shell from exec'ing the child,
+	// which instead runs as a grandchild of the CLI and handles SIGTERM
+	// gracefully.
+	cmd := exec.Command("sh", "-c", `sh -c 'trap "echo terminated; exit 0" TERM; echo $$; while :; do sleep 0.1; done'; true`)
+	cmd.Stdout = w
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	setSysProcAttr(cmd.SysProcAttr)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The grandchild is in the process group of the child.
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cmd.Process.Pid, pgid)
+
+	killProcess(cmd.Process, syscall.SIGTERM)
+	_ = cmd.Wait()
+
+	// The grandchild received SIGTERM, rather than being killed.
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("grandchild process was not terminated: %v", err)
+	}
+	assert.Equal(t, "terminated\n", string(b))
+}
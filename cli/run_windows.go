@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Job objects that processes were assigned to, by pid.
+var jobs sync.Map
+
+// setSysProcAttr starts the process suspended, so that trackProcess can
+// assign it to a job object before it gets a chance to spawn processes.
+func setSysProcAttr(attr *syscall.SysProcAttr) {
+	attr.CreationFlags |= windows.CREATE_SUSPENDED
+}
+
+// trackProcess assigns the process to a job object, so that killProcess
+// can terminate the process along with the processes it spawned. Windows
+// has no equivalent to Unix process groups that would let us do that.
+//
+// The job object is configured to terminate its processes when the last
+// handle to it is closed, which ensures they don't outlive the CLI.
+//
+// The process is resumed once it's been assigned to the job object, or if
+// that failed. If the process can't be resumed, it's killed.
+func trackProcess(process *os.Process) error {
+	err := assignJobObject(process)
+	if resumeErr := resumeProcess(process); resumeErr != nil {
+		process.Kill()
+		return resumeErr
+	}
+	return err
+}
+
+func assignJobObject(process *os.Process) error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(process.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+	defer windows.CloseHandle(handle)
+
+	if err := windows.AssignProcessToJobObject(job, handle); err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+	jobs.Store(process.Pid, job)
+	return nil
+}
+
+// resumeProcess resumes the threads of a process that was started
+// suspended. Resuming a thread that isn't suspended has no effect.
+func resumeProcess(process *os.Process) error {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	entry := windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	for err = windows.Thread32First(snapshot, &entry); err == nil; err = windows.Thread32Next(snapshot, &entry) {
+		if entry.OwnerProcessID != uint32(process.Pid) {
+			continue
+		}
+		thread, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, entry.ThreadID)
+		if err != nil {
+			return err
+		}
+		_, err = windows.ResumeThread(thread)
+		windows.CloseHandle(thread)
+		if err != nil {
+			return err
+		}
+	}
+	if err != windows.ERROR_NO_MORE_FILES {
+		return err
+	}
+	return nil
+}
+
+func killProcess(process *os.Process, _ os.Signal) {
+	// Windows doesn't support sending signals to processes, so the
+	// processes are terminated regardless of the signal.
+	if job, ok := jobs.Load(process.Pid); ok {
+		_ = windows.TerminateJobObject(job.(windows.Handle), 1)
+		return
+	}
+	process.Kill()
+}
+
+func notifyDumpSignal(c chan<- os.Signal) {}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows"
+)
+
+// JOBOBJECT_BASIC_ACCOUNTING_INFORMATION isn't defined in x/sys/windows.
+type jobObjectBasicAccountingInformation struct {
+	TotalUserTime             int64
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+}
+
+func activeJobProcesses(t *testing.T, job windows.Handle) uint32 {
+	var info jobObjectBasicAccountingInformation
+	if err := windows.QueryInformationJobObject(job, windows.JobObjectBasicAccountingInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return info.ActiveProcesses
+}
+
+func TestKillProcessTree(t *testing.T) {
+	// The process is started suspended, so both pings are spawned by cmd
+	// after the process has been assigned to the job object.
+	cmd := exec.Command("cmd", "/c", "ping -n 2 127.0.0.1 >nul & ping -n 60 127.0.0.1 >nul")
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	setSysProcAttr(cmd.SysProcAttr)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	if err := trackProcess(cmd.Process); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := jobs.Load(cmd.Process.Pid)
+	if !ok {
+		t.Fatal("process was not assigned to a job object")
+	}
+	job := v.(windows.Handle)
+
+	assert.Eventually(t, func() bool {
+		return activeJobProcesses(t, job) >= 2
+	}, 10*time.Second, 50*time.Millisecond)
+
+	killProcess(cmd.Process, nil)
+	_ = cmd.Wait()
+
+	assert.Eventually(t, func() bool {
+		return activeJobProcesses(t, job) == 0
+	}, 10*time.Second, 50*time.Millisecond)
+}
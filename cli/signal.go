@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"os"
+	"time"
+)
+
+// signalForwarder decides which signal to send to the local application
+// when the CLI receives a signal.
+//
+// The first signal is forwarded as is, to let the application shut down
+// gracefully. Subsequent signals kill the application, unless noForceKill
+// is set, in which case they're forwarded as well, and the application is
+// only killed once the grace period has elapsed since the first signal. If
+// the grace period is zero, the application is never killed.
+type signalForwarder struct {
+	noForceKill bool
+	grace       time.Duration
+	first       time.Time
+}
+
+func (f *signalForwarder) forward(now time.Time, s os.Signal) os.Signal {
+	if f.first.IsZero() {
+		f.first = now
+		return s
+	}
+	if !f.noForceKill || (f.grace > 0 && now.Sub(f.first) >= f.grace) {
+		return os.Kill
+	}
+	return s
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignalForwarder(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Second signal kills", func(t *testing.T) {
+		f := &signalForwarder{}
+		assert.Equal(t, syscall.SIGINT, f.forward(now, syscall.SIGINT))
+		assert.Equal(t, os.Kill, f.forward(now.Add(time.Second), syscall.SIGINT))
+	})
+
+	t.Run("No force kill before the grace period", func(t *testing.T) {
+		f := &signalForwarder{noForceKill: true, grace: 10 * time.Second}
+		assert.Equal(t, syscall.SIGINT, f.forward(now, syscall.SIGINT))
+		assert.Equal(t, syscall.SIGINT, f.forward(now.Add(time.Second), syscall.SIGINT))
+		assert.Equal(t, syscall.SIGTERM, f.forward(now.Add(9*time.Second), syscall.SIGTERM))
+		assert.Equal(t, os.Kill, f.forward(now.Add(10*time.Second), syscall.SIGINT))
+	})
+
+	t.Run("No force kill without a grace period", func(t *testing.T) {
+		f := &signalForwarder{noForceKill: true}
+		assert.Equal(t, syscall.SIGINT, f.forward(now, syscall.SIGINT))
+		assert.Equal(t, syscall.SIGINT, f.forward(now.Add(time.Hour), syscall.SIGINT))
+	})
+}
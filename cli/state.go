@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// sessionState is the JSON representation of the function calls of a
+// session, served by the state server (see --state-addr).
+type sessionState struct {
+	SessionID string      `json:"session_id"`
+	Calls     []callState `json:"calls"`
+}
+
+type callState struct {
+	ID         string      `json:"id"`
+	Function   string      `json:"function"`
+	Status     string      `json:"status"`
+	Done       bool        `json:"done"`
+	Attempt    int         `json:"attempt"`
+	DurationMs int64       `json:"duration_ms"`
+	Children   []callState `json:"children"`
+}
+
+// sessionState returns the function call trees, in the order in which they
+// are displayed by the TUI.
+func (s *callStore) sessionState(sessionID string, now time.Time) sessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := sessionState{SessionID: sessionID, Calls: []callState{}}
+	for _, id := range s.orderedRoots {
+		state.Calls = append(state.Calls, s.callState(id, now))
+	}
+	return state
+}
+
+func (s *callStore) callState(id DispatchID, now time.Time) callState {
+	n := s.calls[id]
+	_, _, status := n.status(now)
+	state := callState{
+		ID:         string(id),
+		Function:   n.function(),
+		Status:     status,
+		Done:       n.done,
+		Attempt:    n.attempt(),
+		DurationMs: n.duration(now).Milliseconds(),
+		Children:   []callState{},
+	}
+	for _, child := range n.orderedChildren {
+		state.Children = append(state.Children, s.callState(child, now))
+	}
+	return state
+}
+
+// stateHandler serves the current state of the function calls as JSON.
+func stateHandler(sessionID string, calls *callStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(calls.sessionState(sessionID, time.Now()))
+	})
+}
+
+// serveState serves the state of the function calls on the listener until
+// the context is canceled.
+func serveState(ctx context.Context, l net.Listener, sessionID string, calls *callStore) {
+	server := &http.Server{Handler: stateHandler(sessionID, calls)}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+		slog.Error("failed to serve session state", "error", err)
+	}
+}
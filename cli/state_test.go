@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeState(t *testing.T) {
+	now := time.Now()
+
+	calls := &callStore{}
+	calls.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "parent"})
+	calls.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "2", RootDispatchId: "1", ParentDispatchId: "1", Function: "child"})
+	calls.ObserveResponse(now.Add(time.Second), &sdkv1.RunRequest{DispatchId: "2"}, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveState(ctx, l, "session", calls)
+		close(done)
+	}()
+
+	get := func() sessionState {
+		res, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+		var state sessionState
+		if err := json.NewDecoder(res.Body).Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	state := get()
+	assert.Equal(t, "session", state.SessionID)
+	assert.Len(t, state.Calls, 1)
+
+	parent := state.Calls[0]
+	assert.Equal(t, "1", parent.ID)
+	assert.Equal(t, "parent", parent.Function)
+	assert.Equal(t, "Running", parent.Status)
+	assert.False(t, parent.Done)
+	assert.Equal(t, 1, parent.Attempt)
+	assert.Len(t, parent.Children, 1)
+
+	child := parent.Children[0]
+	assert.Equal(t, "2", child.ID)
+	assert.Equal(t, "child", child.Function)
+	assert.Equal(t, "OK", child.Status)
+	assert.True(t, child.Done)
+	assert.Equal(t, int64(1000), child.DurationMs)
+	assert.Empty(t, child.Children)
+
+	// The state reflects new function calls as they are observed.
+	calls.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "3", RootDispatchId: "3", Function: "other"})
+	state = get()
+	assert.Len(t, state.Calls, 2)
+	assert.Equal(t, "other", state.Calls[1].Function)
+
+	// The server shuts down with the context.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("state server did not shut down")
+	}
+	_, err = http.Get("http://" + l.Addr().String())
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muesli/reflow/ansi"
+)
+
+// functionStats are aggregate stats of the calls to a function.
+type functionStats struct {
+	function string
+
+	// Number of calls, and number of calls that are done and that
+	// succeeded (see isSuccessStatus).
+	calls     int
+	done      int
+	succeeded int
+
+	// Latency of each roundtrip to the local application, sorted.
+	latencies []time.Duration
+}
+
+// successRate returns the ratio of the calls that are done that succeeded,
+// or -1 if no call is done.
+func (s *functionStats) successRate() float64 {
+	if s.done == 0 {
+		return -1
+	}
+	return float64(s.succeeded) / float64(s.done)
+}
+
+// percentile returns the p-th percentile of the latencies, using the
+// nearest-rank method, or zero if there are no latencies.
+func (s *functionStats) percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(s.latencies))))
+	return s.latencies[min(max(rank, 1), len(s.latencies))-1]
+}
+
+// aggregateStats aggregates the function calls by function name. The stats
+// are sorted by function name.
+func aggregateStats(calls map[DispatchID]functionCall) []functionStats {
+	byFunction := map[string]*functionStats{}
+	for _, n := range calls {
+		if len(n.timeline) == 0 {
+			// Placeholder for a root or parent whose request hasn't
+			// been observed.
+			continue
+		}
+		name := n.function()
+		s, ok := byFunction[name]
+		if !ok {
+			s = &functionStats{function: name}
+			byFunction[name] = s
+		}
+		s.calls++
+		if n.done {
+			s.done++
+			if isSuccessStatus(n.lastStatus) && n.lastError == nil {
+				s.succeeded++
+			}
+		}
+		for _, rt := range n.timeline {
+			if !rt.response.ts.IsZero() {
+				s.latencies = append(s.latencies, rt.response.ts.Sub(rt.request.ts))
+			}
+		}
+	}
+
+	stats := make([]functionStats, 0, len(byFunction))
+	for _, s := range byFunction {
+		slices.Sort(s.latencies)
+		stats = append(stats, *s)
+	}
+	slices.SortFunc(stats, func(a, b functionStats) int {
+		return strings.Compare(a.function, b.function)
+	})
+	return stats
+}
+
+// statsView renders the aggregate stats of the function calls as a table.
+func (t *TUI) statsView() string {
+	stats := aggregateStats(t.store.calls)
+
+	functionColumnWidth := 9
+	for i := range stats {
+		functionColumnWidth = max(functionColumnWidth, ansi.PrintableRuneWidth(stats[i].function))
+	}
+	functionColumnWidth = min(50, functionColumnWidth)
+
+	var b strings.Builder
+	b.WriteString(join(
+		left(functionColumnWidth, tableHeaderStyle.Render("Function")),
+		right(6, tableHeaderStyle.Render("Calls")),
+		right(6, tableHeaderStyle.Render("Done")),
+		right(8, tableHeaderStyle.Render("Success")),
+		right(10, tableHeaderStyle.Render("p50")),
+		right(10, tableHeaderStyle.Render("p95")),
+	))
+	b.WriteByte('\n')
+
+	for i := range stats {
+		s := &stats[i]
+		success := "-"
+		if rate := s.successRate(); rate >= 0 {
+			success = fmt.Sprintf("%.0f%%", rate*100)
+		}
+		p50, p95 := "-", "-"
+		if len(s.latencies) > 0 {
+			// Like the functions table, durations are truncated to
+			// milliseconds so that they fit in the columns.
+			p50 = s.percentile(50).Truncate(time.Millisecond).String()
+			p95 = s.percentile(95).Truncate(time.Millisecond).String()
+		}
+		b.WriteString(join(
+			left(functionColumnWidth, s.function),
+			right(6, strconv.Itoa(s.calls)),
+			right(6, strconv.Itoa(s.done)),
+			right(8, success),
+			right(10, p50),
+			right(10, p95),
+		))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
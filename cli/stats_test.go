@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+)
+
+// callWithLatencies returns a function call with a roundtrip for each of
+// the latencies.
+func callWithLatencies(function string, done bool, status sdkv1.Status, latencies ...time.Duration) functionCall {
+	now := time.Now()
+	n := functionCall{lastFunction: function, done: done, lastStatus: status}
+	for _, latency := range latencies {
+		n.timeline = append(n.timeline, &roundtrip{
+			request:  runRequest{ts: now},
+			response: runResponse{ts: now.Add(latency)},
+		})
+	}
+	return n
+}
+
+func TestAggregateStats(t *testing.T) {
+	calls := map[DispatchID]functionCall{
+		"root": {}, // placeholder without a timeline
+	}
+	for i := 1; i <= 20; i++ {
+		status := sdkv1.Status_STATUS_OK
+		if i%4 == 0 {
+			status = sdkv1.Status_STATUS_PERMANENT_ERROR
+		}
+		calls[DispatchID(fmt.Sprintf("a%d", i))] = callWithLatencies("fetch", true, status, time.Duration(i)*time.Millisecond)
+	}
+	calls["b1"] = callWithLatencies("process", true, sdkv1.Status_STATUS_OK, time.Second, 3*time.Second)
+	calls["b2"] = callWithLatencies("process", false, 0, 2*time.Second)
+	running := callWithLatencies("process", false, 0)
+	running.timeline = append(running.timeline, &roundtrip{request: runRequest{ts: time.Now()}})
+	calls["b3"] = running
+
+	stats := aggregateStats(calls)
+	assert.Len(t, stats, 2)
+
+	fetch := stats[0]
+	assert.Equal(t, "fetch", fetch.function)
+	assert.Equal(t, 20, fetch.calls)
+	assert.Equal(t, 20, fetch.done)
+	assert.Equal(t, 15, fetch.succeeded)
+	assert.Equal(t, 0.75, fetch.successRate())
+	assert.Equal(t, 10*time.Millisecond, fetch.percentile(50))
+	assert.Equal(t, 19*time.Millisecond, fetch.percentile(95))
+	assert.Equal(t, 20*time.Millisecond, fetch.percentile(100))
+	assert.Equal(t, 1*time.Millisecond, fetch.percentile(0))
+
+	process := stats[1]
+	assert.Equal(t, "process", process.function)
+	assert.Equal(t, 3, process.calls)
+	assert.Equal(t, 1, process.done)
+	assert.Equal(t, 1.0, process.successRate())
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, process.latencies)
+	assert.Equal(t, 2*time.Second, process.percentile(50))
+	assert.Equal(t, 3*time.Second, process.percentile(95))
+
+	empty := functionStats{}
+	assert.Equal(t, -1.0, empty.successRate())
+	assert.Equal(t, time.Duration(0), empty.percentile(50))
+}
+
+func TestTUIStatsMode(t *testing.T) {
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{}}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 80, Height: 20})
+
+	for _, id := range []string{"1", "2"} {
+		req := &sdkv1.RunRequest{Function: "my_function", DispatchId: id, RootDispatchId: id}
+		tui.store.ObserveRequest(now, req)
+		tui.store.ObserveResponse(now.Add(time.Second), req, nil, nil, &sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+	}
+
+	// Latencies are truncated to milliseconds.
+	req := &sdkv1.RunRequest{Function: "other_function", DispatchId: "3", RootDispatchId: "3"}
+	tui.store.ObserveRequest(now, req)
+	tui.store.ObserveResponse(now.Add(12345678*time.Nanosecond), req, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+
+	stats := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")}
+	tui.Update(stats)
+	assert.True(t, tui.statsMode)
+	view := tui.View()
+	assert.Regexp(t, `Function\s+Calls\s+Done\s+Success\s+p50\s+p95`, view)
+	assert.Regexp(t, `my_function\s+2\s+2\s+100%\s+1s\s+1s`, view)
+	assert.Regexp(t, `other_function\s+1\s+1\s+100%\s+12ms\s+12ms\s`, view)
+
+	tui.Update(stats)
+	assert.False(t, tui.statsMode)
+	assert.Regexp(t, `Function\s+Attempt\s+Duration`, tui.View())
+}
@@ -1,7 +1,10 @@
 package cli
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -10,6 +13,27 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// PlainDialogs disables the box drawn around dialog messages.
+var PlainDialogs bool
+
+// SpinnerStyle is the style of the spinner displayed while waiting for
+// operations to complete, or "none" to disable it.
+var SpinnerStyle string
+
+var spinnerStyles = map[string]spinner.Spinner{
+	"dot":     spinner.Dot,
+	"line":    spinner.Line,
+	"minidot": spinner.MiniDot,
+	"points":  spinner.Points,
+}
+
+func validateSpinnerStyle(style string) error {
+	if _, ok := spinnerStyles[style]; !ok && style != "none" {
+		return fmt.Errorf("invalid spinner style: %q (must be one of dot, line, minidot, points or none)", style)
+	}
+	return nil
+}
+
 var (
 	dialogBoxStyle = lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
@@ -24,6 +48,8 @@ var (
 	successStyle = lipgloss.NewStyle().Foreground(greenColor)
 
 	failureStyle = lipgloss.NewStyle().Foreground(redColor)
+
+	warningStyle = lipgloss.NewStyle().Foreground(yellowColor)
 )
 
 type errMsg struct{ error }
@@ -42,6 +68,9 @@ type spinnerModel struct {
 func newSpinnerModel(hello string, fn func() (tea.Msg, error)) spinnerModel {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
+	if style, ok := spinnerStyles[SpinnerStyle]; ok {
+		s.Spinner = style
+	}
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
 	return spinnerModel{
 		spinner: s,
@@ -50,16 +79,67 @@ func newSpinnerModel(hello string, fn func() (tea.Msg, error)) spinnerModel {
 	}
 }
 
+// withSpinner runs fn while displaying a spinner, and then displays the
+// result or error returned by fn. When stdout isn't a terminal or the
+// spinner is disabled, fn is run synchronously and plain lines are written
+// to out instead. The error returned by fn isn't returned.
+func withSpinner(out io.Writer, hello string, fn func() (tea.Msg, error)) error {
+	if SpinnerStyle == "none" || !isTerminal(os.Stdout) {
+		fmt.Fprintf(out, "%s...\n", hello)
+		result, err := fn()
+		if err != nil {
+			fmt.Fprintf(out, "Error: %s\n", err.Error())
+		} else if s := resultString(result); s != "" {
+			io.WriteString(out, s)
+			if !strings.HasSuffix(s, "\n") {
+				io.WriteString(out, "\n")
+			}
+		}
+		return nil
+	}
+	_, err := tea.NewProgram(newSpinnerModel(hello, fn)).Run()
+	return err
+}
+
+// silenceError returns err, preventing cobra from printing it, for errors
+// that were already displayed, e.g. by withSpinner. The error is still
+// returned so that the command exits with the corresponding code.
+func silenceError(cmd *cobra.Command, err error) error {
+	if err != nil {
+		cmd.SilenceErrors = true
+	}
+	return err
+}
+
 func runSpinner(fn func() (tea.Msg, error)) tea.Cmd {
 	return func() tea.Msg {
 		result, err := fn()
 		if err != nil {
 			return errMsg{err}
 		}
-		if result == nil {
-			return resultMsg{}
-		}
-		return resultMsg{result.(string)}
+		return resultMsg{resultString(result)}
+	}
+}
+
+// viewer is implemented by results of spinner-backed operations that
+// render themselves, e.g. tables or other bubbles components.
+type viewer interface {
+	View() string
+}
+
+// resultString formats the result of a spinner-backed operation.
+func resultString(result tea.Msg) string {
+	switch r := result.(type) {
+	case nil:
+		return ""
+	case string:
+		return r
+	case viewer:
+		return r.View()
+	case fmt.Stringer:
+		return r.String()
+	default:
+		return fmt.Sprint(r)
 	}
 }
 
@@ -120,6 +200,26 @@ func simple(cmd *cobra.Command, msgs ...string) {
 	cmd.Println(strings.Join(msgs, " "))
 }
 
+// confirm asks a yes/no question, and returns true if the user
+// answered yes.
+func confirm(in *bufio.Reader, out io.Writer, prompt string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", prompt)
+	line, _ := in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
 func dialog(msg string, args ...interface{}) {
-	fmt.Println(dialogBoxStyle.Render(fmt.Sprintf(msg, args...)))
+	fmt.Println(renderDialog(fmt.Sprintf(msg, args...)))
+}
+
+func renderDialog(msg string) string {
+	if PlainDialogs {
+		return msg
+	}
+	return dialogBoxStyle.Render(msg)
 }
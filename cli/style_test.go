@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderDialog(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the PlainDialogs global!
+	lipgloss.SetColorProfile(termenv.Ascii)
+
+	plainDialogs := PlainDialogs
+	t.Cleanup(func() { PlainDialogs = plainDialogs })
+
+	const msg = "To resume this Dispatch session:\n\n\tdispatch run --session xyz"
+
+	PlainDialogs = false
+	assert.Contains(t, renderDialog(msg), "╭")
+
+	PlainDialogs = true
+	assert.Equal(t, msg, renderDialog(msg))
+	for _, c := range []string{"╭", "╮", "╰", "╯", "│", "─"} {
+		assert.NotContains(t, renderDialog(msg), c)
+	}
+}
+
+type stringerResult struct{ name string }
+
+func (r stringerResult) String() string { return "result: " + r.name }
+
+func TestRunSpinner(t *testing.T) {
+	tcs := []struct {
+		name   string
+		result tea.Msg
+		want   string
+	}{
+		{"No result", nil, ""},
+		{"String", "hello", "hello"},
+		{"Stringer", stringerResult{"hello"}, "result: hello"},
+		{"Other", 42, "42"},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			msg := runSpinner(func() (tea.Msg, error) { return tc.result, nil })()
+			assert.Equal(t, resultMsg{tc.want}, msg)
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		err := errors.New("oops")
+		msg := runSpinner(func() (tea.Msg, error) { return nil, err })()
+		assert.Equal(t, errMsg{err}, msg)
+	})
+}
+
+type keyTable struct{ keys []string }
+
+func (t keyTable) View() string {
+	var b strings.Builder
+	b.WriteString("ID  KEY\n")
+	for i, key := range t.keys {
+		fmt.Fprintf(&b, "%-3d %s\n", i+1, key)
+	}
+	return b.String()
+}
+
+func TestSpinnerViewResult(t *testing.T) {
+	const table = "ID  KEY\n1   key-a\n2   key-b\n"
+	fn := func() (tea.Msg, error) {
+		return keyTable{[]string{"key-a", "key-b"}}, nil
+	}
+
+	m := newSpinnerModel("Fetching keys", fn)
+	assert.Contains(t, m.View(), "Fetching keys...")
+
+	model, _ := m.Update(runSpinner(fn)())
+	assert.Equal(t, table, model.View())
+
+	// Tests don't run in a terminal, so the spinner is not displayed.
+	var out bytes.Buffer
+	assert.NoError(t, withSpinner(&out, "Fetching keys", fn))
+	assert.Equal(t, "Fetching keys...\n"+table, out.String())
+}
@@ -24,6 +24,12 @@ func switchCommand(configPath string) *cobra.Command {
 		Long:    SwitchCmdLong,
 		GroupID: "management",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			unlock, err := lockConfig(configPath, configLockTimeout)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
 			cfg, err := LoadConfig(configPath)
 			if err != nil {
 				if !errors.Is(err, os.ErrNotExist) {
@@ -59,6 +65,13 @@ func switchCommand(configPath string) *cobra.Command {
 
 			simple(cmd, fmt.Sprintf("Switched to organization: %v", name))
 			cfg.Active = name
+			if cfg.ActiveProfile != "" {
+				// The active profile takes precedence over the active
+				// organization, so it's deselected for the switch to
+				// take effect.
+				simple(cmd, fmt.Sprintf("Deselected profile: %v", cfg.ActiveProfile))
+				cfg.ActiveProfile = ""
+			}
 			return CreateConfig(configPath, cfg)
 		},
 	}
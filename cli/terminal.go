@@ -0,0 +1,21 @@
+package cli
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// isTerminal returns true if f is a terminal. It's used to decide whether
+// interactive features, such as the TUI or prompts, can be enabled. Nil and
+// closed files are not terminals.
+func isTerminal(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+	fd := f.Fd()
+	if fd == ^uintptr(0) { // closed
+		return false
+	}
+	return term.IsTerminal(int(fd))
+}
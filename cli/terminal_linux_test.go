@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a pseudo-terminal, returning the controlling and the
+// terminal side.
+func openPty() (ptmx, pts *os.File, err error) {
+	ptmx, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := unix.IoctlSetPointerInt(int(ptmx.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+	n, err := unix.IoctlGetInt(int(ptmx.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+	pts, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+	return ptmx, pts, nil
+}
+
+func TestIsTerminalPty(t *testing.T) {
+	ptmx, pts, err := openPty()
+	if err != nil {
+		t.Skipf("pseudo-terminals are not available: %v", err)
+	}
+	defer ptmx.Close()
+	defer pts.Close()
+
+	assert.True(t, isTerminal(pts))
+
+	pts.Close()
+	assert.False(t, isTerminal(pts))
+}
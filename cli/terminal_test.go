@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTerminal(t *testing.T) {
+	t.Run("Nil file", func(t *testing.T) {
+		assert.False(t, isTerminal(nil))
+	})
+
+	t.Run("Pipe", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+
+		assert.False(t, isTerminal(r))
+		assert.False(t, isTerminal(w))
+	})
+
+	t.Run("Closed file", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		w.Close()
+
+		assert.False(t, isTerminal(r))
+	})
+
+	t.Run("Regular file", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		assert.False(t, isTerminal(f))
+	})
+}
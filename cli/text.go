@@ -26,6 +26,30 @@ func truncate(width int, s string) string {
 	return s
 }
 
+// skipColumns removes the first n printable columns of s. ANSI escape
+// sequences are kept, so that the rest of the string is styled the same.
+func skipColumns(n int, s string) string {
+	var isANSI bool
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == ansi.Marker:
+			isANSI = true
+			b.WriteRune(c)
+		case isANSI:
+			if ansi.IsTerminator(c) {
+				isANSI = false
+			}
+			b.WriteRune(c)
+		case n > 0:
+			n -= ansi.PrintableRuneWidth(string(c))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
 func right(width int, s string) string {
 	if ansi.PrintableRuneWidth(s) > width {
 		return truncate(width-3, s) + "..."
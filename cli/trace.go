@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newOTLPTracerProvider returns a tracer provider exporting spans to the
+// OpenTelemetry collector at the OTLP/HTTP endpoint URL.
+func newOTLPTracerProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	return newTracerProvider(sdktrace.NewBatchSpanProcessor(exporter)), nil
+}
+
+func newTracerProvider(processor sdktrace.SpanProcessor) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithIDGenerator(dispatchIDGenerator{}),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("dispatch"))),
+	)
+}
+
+// spanObserver is a FunctionCallObserver that emits a span for each
+// function call roundtrip (request to response).
+//
+// All the spans of a call tree share a trace ID derived from the root
+// dispatch ID. The spans of a function call are children of the first span
+// of its parent function call, so that the call tree forms a trace.
+type spanObserver struct {
+	tracer trace.Tracer
+
+	mu    sync.Mutex
+	calls map[DispatchID]*tracedCall
+}
+
+type tracedCall struct {
+	// first is the span context of the first roundtrip, which is the parent
+	// of the spans of child function calls.
+	first    trace.SpanContext
+	span     trace.Span
+	attempts int
+}
+
+func newSpanObserver(provider trace.TracerProvider) *spanObserver {
+	return &spanObserver{
+		tracer: provider.Tracer("github.com/dispatchrun/dispatch"),
+		calls:  map[DispatchID]*tracedCall{},
+	}
+}
+
+func (o *spanObserver) ObserveRequest(now time.Time, req *sdkv1.RunRequest) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	id := DispatchID(req.DispatchId)
+	call, ok := o.calls[id]
+	if !ok {
+		call = &tracedCall{}
+		o.calls[id] = call
+	}
+	call.attempts++
+
+	rootID := req.RootDispatchId
+	if rootID == "" {
+		rootID = req.DispatchId
+	}
+	ctx := context.WithValue(context.Background(), rootDispatchIDKey{}, rootID)
+	if parent, ok := o.calls[DispatchID(req.ParentDispatchId)]; ok && parent.first.IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, parent.first)
+	}
+
+	_, span := o.tracer.Start(ctx, req.Function,
+		trace.WithTimestamp(now),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("dispatch.function", req.Function),
+			attribute.String("dispatch.id", req.DispatchId),
+			attribute.String("dispatch.root_id", req.RootDispatchId),
+			attribute.String("dispatch.parent_id", req.ParentDispatchId),
+			attribute.Int("dispatch.attempt", call.attempts),
+		))
+	if !call.first.IsValid() {
+		call.first = span.SpanContext()
+	}
+	call.span = span
+}
+
+func (o *spanObserver) ObserveResponse(now time.Time, req *sdkv1.RunRequest, err error, httpRes *http.Response, res *sdkv1.RunResponse) {
+	o.mu.Lock()
+	call, ok := o.calls[DispatchID(req.DispatchId)]
+	var span trace.Span
+	if ok {
+		span, call.span = call.span, nil
+
+		// The function call won't be retried, and its children are done
+		// since it returned, so the call isn't needed anymore.
+		if callDone(err, httpRes, res) {
+			delete(o.calls, DispatchID(req.DispatchId))
+		}
+	}
+	o.mu.Unlock()
+	if span == nil {
+		return
+	}
+
+	switch {
+	case err != nil:
+		span.SetStatus(codes.Error, err.Error())
+	case res != nil:
+		span.SetAttributes(attribute.String("dispatch.status", statusString(res.Status)))
+		if !isSuccessStatus(res.Status) {
+			span.SetStatus(codes.Error, statusString(res.Status))
+		}
+	case httpRes != nil:
+		span.SetAttributes(attribute.Int("http.response.status_code", httpRes.StatusCode))
+		span.SetStatus(codes.Error, "HTTP "+strconv.Itoa(httpRes.StatusCode))
+	}
+	span.End(trace.WithTimestamp(now))
+}
+
+// callDone returns true if the response ends the function call, i.e. the
+// function call won't be redelivered.
+func callDone(err error, httpRes *http.Response, res *sdkv1.RunResponse) bool {
+	switch {
+	case err != nil:
+		return false
+	case res != nil:
+		exit := res.GetExit()
+		return exit != nil && exit.TailCall == nil && terminalStatus(res.Status)
+	case httpRes != nil:
+		return terminalHTTPStatusCode(httpRes.StatusCode)
+	}
+	return false
+}
+
+type rootDispatchIDKey struct{}
+
+// dispatchIDGenerator derives trace IDs from the root dispatch ID of the
+// function calls, so that the spans of a call tree belong to the same
+// trace. Span IDs are random.
+type dispatchIDGenerator struct{}
+
+func (g dispatchIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	var traceID trace.TraceID
+	if rootID, ok := ctx.Value(rootDispatchIDKey{}).(string); ok && rootID != "" {
+		sum := sha256.Sum256([]byte(rootID))
+		copy(traceID[:], sum[:])
+	} else {
+		_, _ = rand.Read(traceID[:])
+	}
+	return traceID, g.NewSpanID(ctx, traceID)
+}
+
+func (dispatchIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	var spanID trace.SpanID
+	_, _ = rand.Read(spanID[:])
+	return spanID
+}
+
+// observers is a FunctionCallObserver that forwards observations to
+// multiple observers.
+type observers []FunctionCallObserver
+
+func (o observers) ObserveRequest(now time.Time, req *sdkv1.RunRequest) {
+	for _, observer := range o {
+		observer.ObserveRequest(now, req)
+	}
+}
+
+func (o observers) ObserveResponse(now time.Time, req *sdkv1.RunRequest, err error, httpRes *http.Response, res *sdkv1.RunResponse) {
+	for _, observer := range o {
+		observer.ObserveResponse(now, req, err, httpRes, res)
+	}
+}
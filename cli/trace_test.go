@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpanObserver(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newTracerProvider(sdktrace.NewSimpleSpanProcessor(exporter))
+	observer := newSpanObserver(provider)
+
+	now := time.Now()
+	parent := &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "parent"}
+	child := &sdkv1.RunRequest{DispatchId: "2", RootDispatchId: "1", ParentDispatchId: "1", Function: "child"}
+	other := &sdkv1.RunRequest{DispatchId: "3", RootDispatchId: "3", Function: "other"}
+
+	observer.ObserveRequest(now, parent)
+	observer.ObserveResponse(now.Add(time.Second), parent, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Poll{Poll: &sdkv1.Poll{}},
+	})
+	observer.ObserveRequest(now.Add(time.Second), child)
+	observer.ObserveResponse(now.Add(2*time.Second), child, nil, nil, &sdkv1.RunResponse{Status: sdkv1.Status_STATUS_TEMPORARY_ERROR})
+	observer.ObserveRequest(now.Add(2*time.Second), child)
+	observer.ObserveResponse(now.Add(3*time.Second), child, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+	observer.ObserveRequest(now.Add(3*time.Second), other)
+	observer.ObserveResponse(now.Add(4*time.Second), other, errors.New("connection refused"), nil, nil)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 4) {
+		return
+	}
+	parentSpan, childSpan1, childSpan2, otherSpan := spans[0], spans[1], spans[2], spans[3]
+
+	assert.Equal(t, "parent", parentSpan.Name)
+	assert.Equal(t, now, parentSpan.StartTime)
+	assert.Equal(t, now.Add(time.Second), parentSpan.EndTime)
+	assert.False(t, parentSpan.Parent.IsValid())
+	assert.Contains(t, parentSpan.Attributes, attribute.String("dispatch.function", "parent"))
+	assert.Contains(t, parentSpan.Attributes, attribute.String("dispatch.id", "1"))
+	assert.Contains(t, parentSpan.Attributes, attribute.String("dispatch.root_id", "1"))
+	assert.Contains(t, parentSpan.Attributes, attribute.Int("dispatch.attempt", 1))
+	assert.Contains(t, parentSpan.Attributes, attribute.String("dispatch.status", "OK"))
+	assert.Equal(t, codes.Unset, parentSpan.Status.Code)
+
+	// Child function calls are part of the same trace.
+	for i, span := range []tracetest.SpanStub{childSpan1, childSpan2} {
+		assert.Equal(t, "child", span.Name)
+		assert.Equal(t, parentSpan.SpanContext.TraceID(), span.SpanContext.TraceID())
+		assert.Equal(t, parentSpan.SpanContext.SpanID(), span.Parent.SpanID())
+		assert.Contains(t, span.Attributes, attribute.String("dispatch.parent_id", "1"))
+		assert.Contains(t, span.Attributes, attribute.Int("dispatch.attempt", i+1))
+	}
+	assert.Equal(t, codes.Error, childSpan1.Status.Code)
+	assert.Contains(t, childSpan1.Attributes, attribute.String("dispatch.status", "Temporary error"))
+	assert.Equal(t, codes.Unset, childSpan2.Status.Code)
+
+	// Other call trees have their own trace.
+	assert.NotEqual(t, parentSpan.SpanContext.TraceID(), otherSpan.SpanContext.TraceID())
+	assert.Equal(t, codes.Error, otherSpan.Status.Code)
+	assert.Equal(t, "connection refused", otherSpan.Status.Description)
+
+	// Function calls that are done are forgotten. The others may be
+	// redelivered or have children.
+	observer.ObserveRequest(now.Add(3*time.Second), parent)
+	observer.ObserveResponse(now.Add(4*time.Second), parent, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+	assert.Len(t, observer.calls, 1)
+	assert.Contains(t, observer.calls, DispatchID("3"))
+}
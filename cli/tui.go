@@ -2,16 +2,15 @@ package cli
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"log/slog"
 	"math"
-	"net/http"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/atotto/clipboard"
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textinput"
@@ -24,12 +23,7 @@ import (
 const (
 	refreshInterval         = time.Second / 10
 	underscoreBlinkInterval = time.Second / 2
-)
-
-const (
-	pendingIcon = "•" // U+2022
-	successIcon = "✔" // U+2714
-	failureIcon = "✗" // U+2718
+	flashInterval           = 3 * time.Second
 )
 
 var (
@@ -44,53 +38,73 @@ var (
 	tableHeaderStyle = lipgloss.NewStyle().Foreground(defaultColor).Bold(true)
 	selectedStyle    = lipgloss.NewStyle().Background(magentaColor)
 
-	// Styles for function names and statuses in the table.
-	pendingStyle   = lipgloss.NewStyle().Foreground(grayColor)
-	suspendedStyle = lipgloss.NewStyle().Foreground(grayColor)
-	retryStyle     = lipgloss.NewStyle().Foreground(yellowColor)
-	errorStyle     = lipgloss.NewStyle().Foreground(redColor)
-	okStyle        = lipgloss.NewStyle().Foreground(greenColor)
-
 	// Styles for other components inside the table.
 	treeStyle = lipgloss.NewStyle().Foreground(grayColor)
 
-	// Styles for the function call detail tab.
-	detailHeaderStyle      = lipgloss.NewStyle().Foreground(grayColor)
-	detailLowPriorityStyle = lipgloss.NewStyle().Foreground(grayColor)
+	// Style for the scroll indicator in the status bar.
+	scrollIndicatorStyle = lipgloss.NewStyle().Foreground(grayColor)
 )
 
 type TUI struct {
 	ticks uint64
 
-	// Storage for the function call hierarchies.
-	//
-	// FIXME: we never clean up items from these maps
-	roots        map[DispatchID]struct{}
-	orderedRoots []DispatchID
-	calls        map[DispatchID]functionCall
+	// The function calls displayed by the TUI, and the version of the
+	// store that the viewport content was built from.
+	store        *callStore
+	storeVersion uint64
 
 	// Storage for logs.
 	logs bytes.Buffer
 
 	// TUI models / options / flags, used to display the information
 	// above.
-	viewport         viewport.Model
-	selection        textinput.Model
-	help             help.Model
-	ready            bool
-	activeTab        tab
-	selectMode       bool
-	tailMode         bool
-	logoHelp         string
-	logsTabHelp      string
-	functionsTabHelp string
-	detailTabHelp    string
-	selectHelp       string
-	windowHeight     int
-	selected         *DispatchID
+	viewport     viewport.Model
+	selection    textinput.Model
+	help         help.Model
+	ready        bool
+	activeTab    tab
+	selectMode   bool
+	tailMode     bool
+	rawMode      bool
+	statsMode    bool
+	fullHelp     bool
+	windowHeight int
+	selected     *DispatchID
+
+	// Horizontal offset of the functions table, for tables that are wider
+	// than the terminal. When non-zero, the columns aren't truncated.
+	xOffset int
+
+	// Function to cancel an in-flight function call, see callCanceler.
+	// If nil, function calls can't be canceled.
+	cancelCall func(DispatchID) bool
+
+	// Command to run to resume the session, and a message that is
+	// briefly displayed in the status bar.
+	resumeCommand   string
+	flashMessage    string
+	flashExpiryTick uint64
 
 	err error
 
+	// Minimum and maximum width of the function column of the functions
+	// table. If zero, defaults are used (see functionColumnWidth).
+	functionColumnMinWidth int
+	functionColumnMaxWidth int
+
+	// Whether the viewport content is up to date. It's invalidated when
+	// logs are written, or a message other than a tick is received. The
+	// content is also rebuilt when the function calls change.
+	contentValid bool
+
+	// Whether the TUI displays function calls loaded from a session
+	// export, rather than the calls of a running session.
+	readOnly bool
+
+	// Clock used to render the views. If nil, time.Now is used. Tests
+	// set it to render the views at a fixed time.
+	clock func() time.Time
+
 	mu sync.Mutex
 }
 
@@ -141,17 +155,72 @@ var (
 	)
 
 	scrollKeys = key.NewBinding(
-		key.WithKeys("up", "down"),
-		key.WithHelp("↑↓", "scroll"),
+		key.WithKeys("up", "down", "pgup", "pgdown"),
+		key.WithHelp("↑↓/pgup/pgdn", "scroll"),
+	)
+
+	panKeys = key.NewBinding(
+		key.WithKeys("left", "right"),
+		key.WithHelp("←→", "scroll sideways"),
+	)
+
+	copyResumeCommandKey = key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "copy resume command"),
+	)
+
+	lastFailedKey = key.NewBinding(
+		key.WithKeys("f"),
+		key.WithHelp("f", "show last failure"),
 	)
 
-	logoKeyMap         = []key.Binding{showLogsTabKey, quitKey}
-	functionsTabKeyMap = []key.Binding{showLogsTabKey, selectModeKey, scrollKeys, quitKey}
-	detailTabKeyMap    = []key.Binding{showFunctionsTabKey, scrollKeys, quitKey}
-	logsTabKeyMap      = []key.Binding{showFunctionsTabKey, tailKey, scrollKeys, quitKey}
+	clearKey = key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "clear finished"),
+	)
+
+	statsModeKey = key.NewBinding(
+		key.WithKeys("a"),
+		key.WithHelp("a", "toggle stats"),
+	)
+
+	cancelCallKey = key.NewBinding(
+		key.WithKeys("x"),
+		key.WithHelp("x", "cancel function call"),
+	)
+
+	rawModeKey = key.NewBinding(
+		key.WithKeys("p"),
+		key.WithHelp("p", "toggle raw proto"),
+	)
+
+	helpKey = key.NewBinding(
+		key.WithKeys("?"),
+		key.WithHelp("?", "toggle help"),
+	)
+
+	logoKeyMap         = []key.Binding{showLogsTabKey, helpKey, quitKey}
+	functionsTabKeyMap = []key.Binding{showLogsTabKey, selectModeKey, lastFailedKey, statsModeKey, clearKey, copyResumeCommandKey, scrollKeys, panKeys, helpKey, quitKey}
+	detailTabKeyMap    = []key.Binding{showFunctionsTabKey, rawModeKey, cancelCallKey, scrollKeys, helpKey, quitKey}
+	noDetailTabKeyMap  = []key.Binding{showFunctionsTabKey, selectModeKey, helpKey, quitKey}
+	logsTabKeyMap      = []key.Binding{showFunctionsTabKey, tailKey, scrollKeys, helpKey, quitKey}
 	selectKeyMap       = []key.Binding{selectKeys, scrollKeys, exitSelectKey}
+
+	// liveKeys are the keys that only apply to a running session, and
+	// are disabled in read-only mode.
+	liveKeys = map[string]bool{"t": true, "r": true, "c": true, "v": true, "x": true}
 )
 
+func readOnlyKeyMap(keyMap []key.Binding) []key.Binding {
+	var result []key.Binding
+	for _, k := range keyMap {
+		if !liveKeys[k.Help().Key] {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
 type tickMsg struct{}
 
 func tick() tea.Cmd {
@@ -184,11 +253,6 @@ func (t *TUI) Init() tea.Cmd {
 	t.tailMode = true
 
 	t.activeTab = functionsTab
-	t.logoHelp = t.help.ShortHelpView(logoKeyMap)
-	t.logsTabHelp = t.help.ShortHelpView(logsTabKeyMap)
-	t.functionsTabHelp = t.help.ShortHelpView(functionsTabKeyMap)
-	t.detailTabHelp = t.help.ShortHelpView(detailTabKeyMap)
-	t.selectHelp = t.help.ShortHelpView(selectKeyMap)
 
 	return tick()
 }
@@ -199,6 +263,11 @@ func (t *TUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// have been processed.
 	var cmd tea.Cmd
 	var cmds []tea.Cmd
+	if _, ok := msg.(tickMsg); !ok {
+		t.mu.Lock()
+		t.contentValid = false
+		t.mu.Unlock()
+	}
 	switch msg := msg.(type) {
 	case tickMsg:
 		t.ticks++
@@ -206,6 +275,7 @@ func (t *TUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case focusSelectMsg:
 		t.selectMode = true
+		t.activeTab = functionsTab
 		t.selection.SetValue("")
 		cmds = append(cmds, textinput.Blink)
 
@@ -236,11 +306,14 @@ func (t *TUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if t.selected != nil {
 					t.selectMode = false
 					t.activeTab = detailTab
+					t.rawMode = false
 					t.viewport.YOffset = 0 // reset
 				}
 			case "ctrl+c":
 				return t, tea.Quit
 			}
+		} else if t.readOnly && liveKeys[msg.String()] {
+			// Ignore the keys that don't apply to a session export.
 		} else {
 			switch msg.String() {
 			case "esc":
@@ -256,23 +329,78 @@ func (t *TUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "s":
 				// Don't accept s/select until at least one function
 				// call has been received.
-				if len(t.calls) > 0 && t.err == nil {
+				if t.store.count() > 0 && t.err == nil {
 					cmds = append(cmds, focusSelect)
 				}
 			case "t":
 				t.tailMode = true
+			case "f":
+				if id, ok := t.store.lastFailedCall(); ok {
+					t.selected = &id
+					t.activeTab = detailTab
+					t.rawMode = false
+					t.viewport.YOffset = 0 // reset
+				} else {
+					t.flash("No function call has failed")
+				}
+			case "c":
+				if t.activeTab == functionsTab {
+					switch n := t.clearFinishedCalls(); n {
+					case 0:
+						t.flash("No finished function calls to clear")
+					case 1:
+						t.flash("Cleared 1 finished function call")
+					default:
+						t.flash(fmt.Sprintf("Cleared %d finished function calls", n))
+					}
+					t.viewport.YOffset = 0 // reset
+				}
+			case "r":
+				if t.resumeCommand != "" {
+					if err := clipboard.WriteAll(t.resumeCommand); err != nil {
+						t.flash("Resume with: " + t.resumeCommand)
+					} else {
+						t.flash("Copied resume command to the clipboard")
+					}
+				}
+			case "a":
+				if t.activeTab == functionsTab {
+					t.statsMode = !t.statsMode
+					t.viewport.YOffset = 0 // reset
+				}
+			case "p":
+				if t.activeTab == detailTab {
+					t.rawMode = !t.rawMode
+					t.viewport.YOffset = 0 // reset
+				}
+			case "x":
+				if t.activeTab == detailTab && t.selected != nil {
+					if t.cancelInFlightCall(*t.selected) {
+						t.flash("Canceled the function call")
+					} else {
+						t.flash("The function call isn't running")
+					}
+				}
+			case "?":
+				t.fullHelp = !t.fullHelp
 			case "v":
 				Verbose = true
+				logLevel.Set(slog.LevelDebug)
 			case "tab":
 				t.selectMode = false
 				t.activeTab = (t.activeTab + 1) % tabCount
-				if t.activeTab == detailTab && t.selected == nil {
-					t.activeTab = functionsTab
-				}
 				t.viewport.YOffset = 0 // reset
 				t.tailMode = true
 			case "up", "down", "left", "right", "pgup", "pgdown", "ctrl+u", "ctrl+d":
 				t.tailMode = false
+				if t.activeTab == functionsTab && !t.statsMode {
+					switch msg.String() {
+					case "left":
+						t.xOffset = max(0, t.xOffset-horizontalScrollStep)
+					case "right":
+						t.xOffset += horizontalScrollStep
+					}
+				}
 			}
 		}
 	}
@@ -306,47 +434,89 @@ func (t *TUI) View() string {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	var viewportContent string
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	if t.storeVersion != t.store.version {
+		t.storeVersion = t.store.version
+		t.contentValid = false
+	}
+
+	now := t.now()
+
+	// Building the viewport content can be expensive when there are many
+	// function calls, so it's only rebuilt when the data it's built from
+	// changed, or when it changes over time (e.g. the duration of in-flight
+	// calls, or the blinking logo).
+	var viewportContent func() string
+	var volatile bool
 	var statusBarContent string
-	var helpContent string
+	var helpKeyMap []key.Binding
 	if !t.ready {
-		viewportContent = t.logoView()
+		viewportContent, volatile = t.logoView, true
 		statusBarContent = "Initializing..."
-		helpContent = t.logoHelp
+		helpKeyMap = logoKeyMap
 	} else {
 		switch t.activeTab {
 		case functionsTab:
-			if len(t.roots) == 0 {
-				viewportContent = t.logoView()
+			if len(t.store.roots) == 0 {
+				viewportContent, volatile = t.logoView, true
 				statusBarContent = "Waiting for function calls..."
-				helpContent = t.logoHelp
-			} else {
-				viewportContent = t.functionsView(time.Now())
-				if len(t.calls) == 1 {
-					statusBarContent = "1 total function call"
-				} else {
-					statusBarContent = fmt.Sprintf("%d total function calls", len(t.calls))
+				if t.readOnly {
+					statusBarContent = "No function calls in this session"
 				}
+				helpKeyMap = logoKeyMap
+			} else {
 				var inflightCount int
-				for _, n := range t.calls {
+				for _, n := range t.store.calls {
 					if !n.done {
 						inflightCount++
 					}
 				}
+				if t.statsMode && !t.selectMode {
+					viewportContent = t.statsView
+				} else {
+					viewportContent = func() string { return t.functionsView(now) }
+					volatile = inflightCount > 0
+				}
+				if len(t.store.calls) == 1 {
+					statusBarContent = "1 total function call"
+				} else {
+					statusBarContent = fmt.Sprintf("%d total function calls", len(t.store.calls))
+				}
 				statusBarContent += fmt.Sprintf(", %d in-flight", inflightCount)
-				helpContent = t.functionsTabHelp
+				helpKeyMap = functionsTabKeyMap
 			}
 			if t.selectMode {
 				statusBarContent = t.selection.View()
-				helpContent = t.selectHelp
+				helpKeyMap = selectKeyMap
 			}
 		case detailTab:
-			id := *t.selected
-			viewportContent = t.detailView(id)
-			helpContent = t.detailTabHelp
+			if t.selected == nil {
+				viewportContent, volatile = t.logoView, true
+				statusBarContent = "Select a function (press s) to view its details"
+				helpKeyMap = noDetailTabKeyMap
+			} else {
+				id := *t.selected
+				viewportContent = func() string { return t.detailView(id, now) }
+				volatile = !t.store.calls[id].done
+				helpKeyMap = detailTabKeyMap
+			}
 		case logsTab:
-			viewportContent = t.logs.String()
-			helpContent = t.logsTabHelp
+			viewportContent = t.logs.String
+			helpKeyMap = logsTabKeyMap
+		}
+	}
+
+	if t.readOnly {
+		helpKeyMap = readOnlyKeyMap(helpKeyMap)
+	}
+
+	if t.flashMessage != "" {
+		if t.ticks < t.flashExpiryTick {
+			statusBarContent = t.flashMessage
+		} else {
+			t.flashMessage = ""
 		}
 	}
 
@@ -354,12 +524,31 @@ func (t *TUI) View() string {
 		statusBarContent = errorStyle.Render(t.err.Error())
 	}
 
-	t.viewport.SetContent(viewportContent)
+	var helpContent string
+	if t.fullHelp {
+		helpContent = t.help.FullHelpView(helpColumns(helpKeyMap))
+	} else {
+		helpContent = t.help.ShortHelpView(helpKeyMap)
+	}
+	helpHeight := lipgloss.Height(helpContent)
 
-	// Shrink the viewport so it contains the content and status bar only.
-	footerHeight := 1
-	if statusBarContent != "" {
-		footerHeight = 3
+	if !t.contentValid || volatile {
+		t.viewport.SetContent(viewportContent())
+		t.contentValid = true
+	}
+
+	// Show a scroll indicator in the status bar when the content
+	// of the detail or logs tab doesn't fit in the viewport.
+	var showScrollIndicator bool
+	if t.ready && statusBarContent == "" && (t.activeTab == detailTab || t.activeTab == logsTab) {
+		showScrollIndicator = t.viewport.TotalLineCount()+1 > max(t.windowHeight-2-helpHeight, 8)
+	}
+
+	// Shrink the viewport so it contains the content, status bar
+	// and help only.
+	footerHeight := helpHeight
+	if statusBarContent != "" || showScrollIndicator {
+		footerHeight += 2
 	}
 	maxViewportHeight := max(t.windowHeight-footerHeight, 8)
 	t.viewport.Height = min(t.viewport.TotalLineCount()+1, maxViewportHeight)
@@ -370,6 +559,10 @@ func (t *TUI) View() string {
 		t.viewport.GotoBottom()
 	}
 
+	if showScrollIndicator {
+		statusBarContent = scrollIndicatorStyle.Render(fmt.Sprintf("%d%%", int(t.viewport.ScrollPercent()*100)))
+	}
+
 	var b strings.Builder
 	b.WriteString(t.viewport.View())
 	b.WriteByte('\n')
@@ -379,10 +572,28 @@ func (t *TUI) View() string {
 		b.WriteString("\n\n")
 	}
 	b.WriteString("  ")
-	b.WriteString(helpContent)
+	b.WriteString(strings.ReplaceAll(helpContent, "\n", "\n  "))
 	return b.String()
 }
 
+// helpColumns splits the key bindings into columns for the full help view.
+func helpColumns(keyMap []key.Binding) [][]key.Binding {
+	const columnHeight = 3
+
+	var columns [][]key.Binding
+	for len(keyMap) > columnHeight {
+		columns = append(columns, keyMap[:columnHeight])
+		keyMap = keyMap[columnHeight:]
+	}
+	return append(columns, keyMap)
+}
+
+// flash briefly displays a message in the status bar.
+func (t *TUI) flash(msg string) {
+	t.flashMessage = msg
+	t.flashExpiryTick = t.ticks + uint64(flashInterval/refreshInterval)
+}
+
 // https://patorjk.com/software/taag/ (Ogre)
 var dispatchAscii = []string{
 	`     _ _                 _       _`,
@@ -416,13 +627,42 @@ func (t *TUI) logoView() string {
 	return b.String()
 }
 
+// Number of columns the functions table is scrolled by when pressing the
+// left and right keys.
+const horizontalScrollStep = 8
+
 func (t *TUI) functionsView(now time.Time) string {
 	t.selected = nil
+	if t.xOffset == 0 {
+		return t.functionsTable(now, false)
+	}
+
+	// Render the table without truncating the function and status columns,
+	// and only show the part of it that fits in the viewport.
+	table := t.functionsTable(now, true)
+	width := t.viewport.Width - viewportStyle.GetHorizontalFrameSize()
+	lines := strings.Split(table, "\n")
+	var tableWidth int
+	for _, line := range lines {
+		tableWidth = max(tableWidth, ansi.PrintableRuneWidth(line))
+	}
+	t.xOffset = min(t.xOffset, max(0, tableWidth-width))
+	if t.xOffset == 0 {
+		return t.functionsTable(now, false)
+	}
+	for i, line := range lines {
+		lines[i] = truncate(width, skipColumns(t.xOffset, line))
+	}
+	return strings.Join(lines, "\n")
+}
 
-	// Render function calls in a hybrid table/tree view.
+// functionsTable renders function calls in a hybrid table/tree view. If wide
+// is true, the function and status columns are sized to fit their content
+// rather than the terminal.
+func (t *TUI) functionsTable(now time.Time, wide bool) string {
 	var b strings.Builder
 	var rows rowBuffer
-	for i, rootID := range t.orderedRoots {
+	for i, rootID := range t.store.orderedRoots {
 		if i > 0 {
 			b.WriteByte('\n')
 		}
@@ -435,14 +675,21 @@ func (t *TUI) functionsView(now time.Time) string {
 		for i := range rows.rows {
 			maxFunctionWidth = max(maxFunctionWidth, ansi.PrintableRuneWidth(rows.rows[i].function))
 		}
-		functionColumnWidth := max(9, min(50, maxFunctionWidth))
+		functionColumnWidth := t.functionColumnWidth(maxFunctionWidth)
+		statusColumnWidth := defaultStatusColumnWidth
+		if wide {
+			functionColumnWidth = max(functionColumnWidth, maxFunctionWidth)
+			for i := range rows.rows {
+				statusColumnWidth = max(statusColumnWidth, ansi.PrintableRuneWidth(rows.rows[i].status))
+			}
+		}
 
 		// Render the table.
 		if i == 0 {
-			b.WriteString(t.tableHeaderView(functionColumnWidth))
+			b.WriteString(t.tableHeaderView(functionColumnWidth, statusColumnWidth))
 		}
 		for i := range rows.rows {
-			b.WriteString(t.tableRowView(&rows.rows[i], functionColumnWidth))
+			b.WriteString(t.tableRowView(&rows.rows[i], functionColumnWidth, statusColumnWidth))
 		}
 
 		rows.reset()
@@ -451,23 +698,60 @@ func (t *TUI) functionsView(now time.Time) string {
 	return b.String()
 }
 
-func (t *TUI) tableHeaderView(functionColumnWidth int) string {
+const (
+	defaultFunctionColumnMinWidth = 9
+	defaultFunctionColumnMaxWidth = 50
+
+	defaultStatusColumnWidth = 35
+
+	// Width of the columns following the function column, including the
+	// separators: attempt (8), duration (10), icon (1) and status (35).
+	otherColumnsWidth = 1 + 8 + 1 + 10 + 1 + 1 + 1 + defaultStatusColumnWidth
+)
+
+// functionColumnWidth returns the width of the function column of the
+// functions table, given the width of the longest function name. Unless
+// configured, the maximum width adapts to the width of the terminal, so
+// that the function column uses the space left by the other columns.
+func (t *TUI) functionColumnWidth(maxFunctionWidth int) int {
+	minWidth := t.functionColumnMinWidth
+	if minWidth <= 0 {
+		minWidth = defaultFunctionColumnMinWidth
+	}
+	maxWidth := t.functionColumnMaxWidth
+	if maxWidth <= 0 {
+		maxWidth = defaultFunctionColumnMaxWidth
+		if t.viewport.Width > 0 {
+			available := t.viewport.Width - viewportStyle.GetHorizontalFrameSize() - otherColumnsWidth
+			if t.selectMode {
+				available -= int(math.Log10(float64(len(t.store.calls)))) + 2
+			}
+			maxWidth = available
+		}
+	}
+	return max(minWidth, min(maxWidth, maxFunctionWidth))
+}
+
+func (t *TUI) tableHeaderView(functionColumnWidth, statusColumnWidth int) string {
 	columns := []string{
 		left(functionColumnWidth, tableHeaderStyle.Render("Function")),
 		right(8, tableHeaderStyle.Render("Attempt")),
 		right(10, tableHeaderStyle.Render("Duration")),
 		left(1, pendingIcon),
-		left(35, tableHeaderStyle.Render("Status")),
+		left(statusColumnWidth, tableHeaderStyle.Render("Status")),
 	}
 	if t.selectMode {
-		idWidth := int(math.Log10(float64(len(t.calls)))) + 1
+		idWidth := int(math.Log10(float64(len(t.store.calls)))) + 1
 		columns = append([]string{left(idWidth, strings.Repeat("#", idWidth))}, columns...)
 	}
 	return join(columns...) + "\n"
 }
 
-func (t *TUI) tableRowView(r *row, functionColumnWidth int) string {
+func (t *TUI) tableRowView(r *row, functionColumnWidth, statusColumnWidth int) string {
 	attemptStr := strconv.Itoa(r.attempt)
+	if r.attemptWarning {
+		attemptStr = retryStyle.Render(attemptStr)
+	}
 
 	var durationStr string
 	if r.duration > 0 {
@@ -481,13 +765,13 @@ func (t *TUI) tableRowView(r *row, functionColumnWidth int) string {
 		right(8, attemptStr),
 		right(10, durationStr),
 		left(1, r.icon),
-		left(35, r.status),
+		left(statusColumnWidth, r.status),
 	}
 
 	id := strconv.Itoa(r.index)
 	var selected bool
 	if t.selectMode {
-		idWidth := int(math.Log10(float64(len(t.calls)))) + 1
+		idWidth := int(math.Log10(float64(len(t.store.calls)))) + 1
 		paddedID := left(idWidth, id)
 		if input := strings.TrimSpace(t.selection.Value()); input != "" && id == input {
 			selected = true
@@ -502,154 +786,22 @@ func (t *TUI) tableRowView(r *row, functionColumnWidth int) string {
 	return result + "\n"
 }
 
-func (t *TUI) detailView(id DispatchID) string {
-	now := time.Now()
-
-	n := t.calls[id]
-
-	style, _, status := n.status(now)
-
-	var view strings.Builder
-
-	add := func(name, value string) {
-		const padding = 16
-		view.WriteString(right(padding, detailHeaderStyle.Render(name+":")))
-		view.WriteByte(' ')
-		view.WriteString(value)
-		view.WriteByte('\n')
+func (t *TUI) detailView(id DispatchID, now time.Time) string {
+	if t.rawMode {
+		return t.rawDetailView(id)
 	}
+	return renderDetail(id, t.store.calls[id], now)
+}
 
-	const timestampFormat = "2006-01-02T15:04:05.000"
-
-	add("ID", detailLowPriorityStyle.Render(string(id)))
-	add("Function", n.function())
-	add("Status", style.Render(status))
-	add("Creation time", detailLowPriorityStyle.Render(n.creationTime.Local().Format(timestampFormat)))
-	if !n.expirationTime.IsZero() && !n.done {
-		add("Expiration time", detailLowPriorityStyle.Render(n.expirationTime.Local().Format(timestampFormat)))
-	}
-	add("Duration", n.duration(now).String())
-	add("Attempts", strconv.Itoa(n.attempt()))
-	add("Requests", strconv.Itoa(len(n.timeline)))
-
-	var result strings.Builder
-	result.WriteString(view.String())
-
-	for _, rt := range n.timeline {
-		view.Reset()
-
-		result.WriteByte('\n')
-
-		// TODO: show request # and/or attempt #?
-
-		add("Timestamp", detailLowPriorityStyle.Render(rt.request.ts.Local().Format(timestampFormat)))
-		req := rt.request.proto
-		switch d := req.Directive.(type) {
-		case *sdkv1.RunRequest_Input:
-			if rt.request.input == "" {
-				rt.request.input = anyString(d.Input)
-			}
-			add("Input", rt.request.input)
-
-		case *sdkv1.RunRequest_PollResult:
-			switch s := d.PollResult.State.(type) {
-			case *sdkv1.PollResult_CoroutineState:
-				add("Input", detailLowPriorityStyle.Render(fmt.Sprintf("<%d bytes of opaque state>", len(s.CoroutineState))))
-			case *sdkv1.PollResult_TypedCoroutineState:
-				if any := s.TypedCoroutineState; any != nil {
-					add("Input", detailLowPriorityStyle.Render(fmt.Sprintf("<%d bytes of %s state>", len(any.Value), typeName(any.TypeUrl))))
-				} else {
-					add("Input", detailLowPriorityStyle.Render("<no state>"))
-				}
-			case nil:
-				add("Input", detailLowPriorityStyle.Render("<no state>"))
-			default:
-				add("Input", detailLowPriorityStyle.Render("<unknown state>"))
-			}
-			// TODO: show call results
-			// TODO: show poll error
-		}
-
-		if rt.response.ts.IsZero() {
-			add("Status", "Running")
-		} else {
-			if res := rt.response.proto; res != nil {
-				switch d := res.Directive.(type) {
-				case *sdkv1.RunResponse_Exit:
-					var statusStyle lipgloss.Style
-					if res.Status == sdkv1.Status_STATUS_OK {
-						statusStyle = okStyle
-					} else if terminalStatus(res.Status) {
-						statusStyle = errorStyle
-					} else {
-						statusStyle = retryStyle
-					}
-					add("Status", statusStyle.Render(statusString(res.Status)))
-
-					if result := d.Exit.Result; result != nil {
-						if rt.response.output == "" {
-							rt.response.output = anyString(result.Output)
-						}
-						add("Output", rt.response.output)
-
-						if result.Error != nil {
-							errorMessage := result.Error.Type
-							if result.Error.Message != "" {
-								errorMessage += ": " + result.Error.Message
-							}
-							add("Error", statusStyle.Render(errorMessage))
-						}
-					}
-					if tailCall := d.Exit.TailCall; tailCall != nil {
-						add("Tail call", tailCall.Function)
-					}
-
-				case *sdkv1.RunResponse_Poll:
-					add("Status", suspendedStyle.Render("Suspended"))
-
-					switch s := d.Poll.State.(type) {
-					case *sdkv1.Poll_CoroutineState:
-						add("Output", detailLowPriorityStyle.Render(fmt.Sprintf("<%d bytes of opaque state>", len(s.CoroutineState))))
-					case *sdkv1.Poll_TypedCoroutineState:
-						if any := s.TypedCoroutineState; any != nil {
-							add("Output", detailLowPriorityStyle.Render(fmt.Sprintf("<%d bytes of %s state>", len(any.Value), typeName(any.TypeUrl))))
-						} else {
-							add("Output", detailLowPriorityStyle.Render("<no state>"))
-						}
-					case nil:
-						add("Output", detailLowPriorityStyle.Render("<no state>"))
-					default:
-						add("Output", detailLowPriorityStyle.Render("<unknown state>"))
-					}
-
-					if len(d.Poll.Calls) > 0 {
-						var calls strings.Builder
-						for i, call := range d.Poll.Calls {
-							if i > 0 {
-								calls.WriteString(", ")
-							}
-							calls.WriteString(call.Function)
-						}
-						add("Calls", truncate(50, calls.String()))
-					}
-				}
-			} else if c := rt.response.httpStatus; c != 0 {
-				style := errorStyle
-				if !terminalHTTPStatusCode(c) {
-					style = retryStyle
-				}
-				add("Error", style.Render(fmt.Sprintf("%d %s", c, http.StatusText(c))))
-			} else if rt.response.err != nil {
-				add("Error", retryStyle.Render(rt.response.err.Error()))
-			}
-
-			latency := rt.response.ts.Sub(rt.request.ts)
-			add("Latency", latency.String())
-		}
-		result.WriteString(view.String())
+func (t *TUI) now() time.Time {
+	if t.clock != nil {
+		return t.clock()
 	}
+	return time.Now()
+}
 
-	return result.String()
+func (t *TUI) rawDetailView(id DispatchID) string {
+	return renderRawDetail(t.store.calls[id])
 }
 
 type row struct {
@@ -660,6 +812,9 @@ type row struct {
 	duration time.Duration
 	icon     string
 	status   string
+
+	// Whether the attempt exceeds the threshold (see callStore.attemptWarning).
+	attemptWarning bool
 }
 
 type rowBuffer struct {
@@ -678,7 +833,7 @@ func (b *rowBuffer) reset() {
 }
 
 func (t *TUI) buildRows(now time.Time, id DispatchID, isLast []bool, rows *rowBuffer) {
-	n := t.calls[id]
+	n := t.store.calls[id]
 
 	// Render the tree prefix.
 	var function strings.Builder
@@ -702,16 +857,30 @@ func (t *TUI) buildRows(now time.Time, id DispatchID, isLast []bool, rows *rowBu
 	}
 
 	style, icon, status := n.status(now)
+	if n.suspended {
+		switch outstanding := t.store.outstandingCalls(n); outstanding {
+		case 0:
+		case 1:
+			status += " (waiting on 1 call)"
+		default:
+			status += fmt.Sprintf(" (waiting on %d calls)", outstanding)
+		}
+	}
 
+	for _, tailCall := range n.tailCalls {
+		function.WriteString(treeStyle.Render(tailCall + " → "))
+	}
 	function.WriteString(style.Render(n.function()))
 
+	attempt := n.attempt()
 	rows.add(row{
-		id:       id,
-		function: function.String(),
-		attempt:  n.attempt(),
-		duration: n.duration(now),
-		icon:     style.Render(icon),
-		status:   style.Render(status),
+		id:             id,
+		function:       function.String(),
+		attempt:        attempt,
+		duration:       n.duration(now),
+		icon:           style.Render(icon),
+		status:         style.Render(status),
+		attemptWarning: t.store.exceedsAttemptWarning(attempt),
 	})
 
 	// Recursively render children.
@@ -721,267 +890,28 @@ func (t *TUI) buildRows(now time.Time, id DispatchID, isLast []bool, rows *rowBu
 	}
 }
 
-type DispatchID string
-
-type functionCall struct {
-	lastFunction string
-	lastStatus   sdkv1.Status
-	lastError    error
-
-	failures int
-	polls    int
-
-	running   bool
-	suspended bool
-	done      bool
-
-	creationTime   time.Time
-	expirationTime time.Time
-	doneTime       time.Time
-
-	children        map[DispatchID]struct{}
-	orderedChildren []DispatchID
-
-	timeline []*roundtrip
-}
-
-type roundtrip struct {
-	request  runRequest
-	response runResponse
-}
-
-type runRequest struct {
-	ts    time.Time
-	proto *sdkv1.RunRequest
-	input string
-}
-
-type runResponse struct {
-	ts         time.Time
-	proto      *sdkv1.RunResponse
-	httpStatus int
-	err        error
-	output     string
-}
-
-func (n *functionCall) function() string {
-	if n.lastFunction != "" {
-		return n.lastFunction
-	}
-	return "(?)"
-}
-
-func (n *functionCall) status(now time.Time) (style lipgloss.Style, icon, status string) {
-	icon = pendingIcon
-	if n.running {
-		style = pendingStyle
-	} else if n.suspended {
-		style = suspendedStyle
-	} else if n.done {
-		if n.lastStatus == sdkv1.Status_STATUS_OK {
-			style = okStyle
-			icon = successIcon
-		} else {
-			style = errorStyle
-			icon = failureIcon
-		}
-	} else if !n.expirationTime.IsZero() && n.expirationTime.Before(now) {
-		n.lastError = errors.New("Expired")
-		style = errorStyle
-		n.done = true
-		n.doneTime = n.expirationTime
-		icon = failureIcon
-	} else if n.failures > 0 {
-		style = retryStyle
-	} else {
-		style = pendingStyle
+// clearFinishedCalls removes the function call hierarchies in which all
+// the calls are done, and returns the number of calls that were removed.
+func (t *TUI) clearFinishedCalls() int {
+	removed := t.store.clearFinishedCalls()
+	if t.selected != nil && !t.store.contains(*t.selected) {
+		t.selected = nil
 	}
-
-	if n.running {
-		status = "Running"
-	} else if n.suspended {
-		status = "Suspended"
-	} else if n.lastError != nil {
-		status = n.lastError.Error()
-	} else if n.lastStatus != sdkv1.Status_STATUS_UNSPECIFIED {
-		status = statusString(n.lastStatus)
-	} else {
-		status = "Pending"
-	}
-
-	return
+	return removed
 }
 
-func (n *functionCall) attempt() int {
-	attempt := len(n.timeline) - n.polls
-	if n.suspended {
-		attempt++
-	}
-	return attempt
-}
-
-func (n *functionCall) duration(now time.Time) time.Duration {
-	var duration time.Duration
-	if !n.creationTime.IsZero() {
-		var start time.Time
-		if !n.creationTime.IsZero() && n.creationTime.Before(n.timeline[0].request.ts) {
-			start = n.creationTime
-		} else {
-			start = n.timeline[0].request.ts
-		}
-		var end time.Time
-		if !n.done {
-			end = now
-		} else {
-			end = n.doneTime
-		}
-		duration = end.Sub(start).Truncate(time.Millisecond)
-	}
-	return max(duration, 0)
-}
-
-func (t *TUI) ObserveRequest(now time.Time, req *sdkv1.RunRequest) {
-	// ObserveRequest is part of the FunctionCallObserver interface.
-	// It's called after a request has been received from the Dispatch API,
-	// and before the request has been sent to the local application.
-
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	if t.roots == nil {
-		t.roots = map[DispatchID]struct{}{}
-	}
-	if t.calls == nil {
-		t.calls = map[DispatchID]functionCall{}
-	}
-
-	rootID := DispatchID(req.RootDispatchId)
-	parentID := DispatchID(req.ParentDispatchId)
-	id := DispatchID(req.DispatchId)
-
-	// Upsert the root.
-	if _, ok := t.roots[rootID]; !ok {
-		t.roots[rootID] = struct{}{}
-		t.orderedRoots = append(t.orderedRoots, rootID)
-	}
-	root, ok := t.calls[rootID]
-	if !ok {
-		root = functionCall{}
-	}
-	t.calls[rootID] = root
-
-	// Upsert the function call.
-	n, ok := t.calls[id]
-	if !ok {
-		n = functionCall{}
-	}
-	n.lastFunction = req.Function
-	n.running = true
-	n.suspended = false
-	if req.CreationTime != nil {
-		n.creationTime = req.CreationTime.AsTime()
-	}
-	if n.creationTime.IsZero() {
-		n.creationTime = now
-	}
-	if req.ExpirationTime != nil {
-		n.expirationTime = req.ExpirationTime.AsTime()
-	}
-	n.timeline = append(n.timeline, &roundtrip{request: runRequest{ts: now, proto: req}})
-	t.calls[id] = n
-
-	// Upsert the parent and link its child, if applicable.
-	if parentID != "" {
-		parent, ok := t.calls[parentID]
-		if !ok {
-			parent = functionCall{}
-			if parentID != rootID {
-				panic("not implemented")
-			}
-		}
-		if parent.children == nil {
-			parent.children = map[DispatchID]struct{}{}
-		}
-		if _, ok := parent.children[id]; !ok {
-			parent.children[id] = struct{}{}
-			parent.orderedChildren = append(parent.orderedChildren, id)
-		}
-		t.calls[parentID] = parent
-	}
-}
-
-func (t *TUI) ObserveResponse(now time.Time, req *sdkv1.RunRequest, err error, httpRes *http.Response, res *sdkv1.RunResponse) {
-	// ObserveResponse is part of the FunctionCallObserver interface.
-	// It's called after a response has been received from the local
-	// application, and before the response has been sent to Dispatch.
-
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	id := DispatchID(req.DispatchId)
-	n := t.calls[id]
-
-	rt := n.timeline[len(n.timeline)-1]
-	rt.response.ts = now
-	rt.response.proto = res
-	rt.response.err = err
-	if res == nil && httpRes != nil {
-		rt.response.httpStatus = httpRes.StatusCode
-	}
-
-	n.lastError = nil
-	n.lastStatus = 0
-	n.running = false
-
-	if res != nil {
-		switch res.Status {
-		case sdkv1.Status_STATUS_OK:
-			// noop
-		case sdkv1.Status_STATUS_INCOMPATIBLE_STATE:
-			n = functionCall{lastFunction: n.lastFunction} // reset
-		default:
-			n.failures++
-		}
-
-		switch d := res.Directive.(type) {
-		case *sdkv1.RunResponse_Exit:
-			n.lastStatus = res.Status
-			n.done = terminalStatus(res.Status)
-			if d.Exit.TailCall != nil {
-				n = functionCall{lastFunction: d.Exit.TailCall.Function} // reset
-			} else if res.Status != sdkv1.Status_STATUS_OK && d.Exit.Result != nil {
-				if e := d.Exit.Result.Error; e != nil && e.Type != "" {
-					if e.Message == "" {
-						n.lastError = fmt.Errorf("%s", e.Type)
-					} else {
-						n.lastError = fmt.Errorf("%s: %s", e.Type, e.Message)
-					}
-				}
-			}
-		case *sdkv1.RunResponse_Poll:
-			n.suspended = true
-			n.polls++
-		}
-	} else if httpRes != nil {
-		n.failures++
-		n.lastError = fmt.Errorf("unexpected HTTP status code %d", httpRes.StatusCode)
-		n.done = terminalHTTPStatusCode(httpRes.StatusCode)
-	} else if err != nil {
-		n.failures++
-		n.lastError = err
-	}
-
-	if n.done && n.doneTime.IsZero() {
-		n.doneTime = now
-	}
-
-	t.calls[id] = n
+// cancelInFlightCall cancels the function call if it's running. The request
+// to the local application is aborted, and the function call is updated
+// when the invocation returns.
+func (t *TUI) cancelInFlightCall(id DispatchID) bool {
+	return t.store.running(id) && t.cancelCall != nil && t.cancelCall(id)
 }
 
 func (t *TUI) Write(b []byte) (int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	t.contentValid = false
 	return t.logs.Write(b)
 }
 
@@ -989,6 +919,7 @@ func (t *TUI) Read(b []byte) (int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	t.contentValid = false
 	return t.logs.Read(b)
 }
 
@@ -998,73 +929,3 @@ func (t *TUI) SetError(err error) {
 
 	t.err = err
 }
-
-func statusString(status sdkv1.Status) string {
-	switch status {
-	case sdkv1.Status_STATUS_OK:
-		return "OK"
-	case sdkv1.Status_STATUS_TIMEOUT:
-		return "Timeout"
-	case sdkv1.Status_STATUS_THROTTLED:
-		return "Throttled"
-	case sdkv1.Status_STATUS_INVALID_ARGUMENT:
-		return "Invalid response"
-	case sdkv1.Status_STATUS_TEMPORARY_ERROR:
-		return "Temporary error"
-	case sdkv1.Status_STATUS_PERMANENT_ERROR:
-		return "Permanent error"
-	case sdkv1.Status_STATUS_INCOMPATIBLE_STATE:
-		return "Incompatible state"
-	case sdkv1.Status_STATUS_DNS_ERROR:
-		return "DNS error"
-	case sdkv1.Status_STATUS_TCP_ERROR:
-		return "TCP error"
-	case sdkv1.Status_STATUS_TLS_ERROR:
-		return "TLS error"
-	case sdkv1.Status_STATUS_HTTP_ERROR:
-		return "HTTP error"
-	case sdkv1.Status_STATUS_UNAUTHENTICATED:
-		return "Unauthenticated"
-	case sdkv1.Status_STATUS_PERMISSION_DENIED:
-		return "Permission denied"
-	case sdkv1.Status_STATUS_NOT_FOUND:
-		return "Not found"
-	default:
-		return status.String()
-	}
-}
-
-func terminalStatus(status sdkv1.Status) bool {
-	switch status {
-	case sdkv1.Status_STATUS_TIMEOUT,
-		sdkv1.Status_STATUS_THROTTLED,
-		sdkv1.Status_STATUS_TEMPORARY_ERROR,
-		sdkv1.Status_STATUS_INCOMPATIBLE_STATE,
-		sdkv1.Status_STATUS_DNS_ERROR,
-		sdkv1.Status_STATUS_TCP_ERROR,
-		sdkv1.Status_STATUS_TLS_ERROR,
-		sdkv1.Status_STATUS_HTTP_ERROR:
-		return false
-	default:
-		return true
-	}
-}
-
-func terminalHTTPStatusCode(code int) bool {
-	switch code / 100 {
-	case 4:
-		return code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
-	case 5:
-		return code == http.StatusNotImplemented
-	default:
-		return true
-	}
-}
-
-func typeName(typeUrl string) string {
-	i := strings.LastIndexByte(typeUrl, '/')
-	if i < 0 {
-		return typeUrl
-	}
-	return typeUrl[i+1:]
-}
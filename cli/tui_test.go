@@ -0,0 +1,592 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTUIRawDetailView(t *testing.T) {
+	now := time.Now()
+
+	req := &sdkv1.RunRequest{
+		Function:       "my_function",
+		DispatchId:     "1",
+		RootDispatchId: "1",
+		Directive:      &sdkv1.RunRequest_Input{Input: asAny(wrapperspb.Int64(42))},
+	}
+	res := &sdkv1.RunResponse{
+		Status: sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{
+			Result: &sdkv1.CallResult{Output: asAny(wrapperspb.Int64(43))},
+		}},
+	}
+
+	tui := &TUI{store: &callStore{}}
+	tui.store.ObserveRequest(now, req)
+	tui.store.ObserveResponse(now.Add(time.Second), req, nil, nil, res)
+
+	tui.rawMode = true
+	view := tui.detailView("1", now)
+
+	assert.Contains(t, view, "RunRequest:")
+	assert.Contains(t, view, "RunResponse:")
+	assert.Contains(t, view, "function:")
+	assert.Contains(t, view, "dispatch_id:")
+	assert.Contains(t, view, "status:")
+	assert.Contains(t, view, "STATUS_OK")
+}
+
+func TestTUIFixedClock(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := start.Add(5 * time.Second)
+
+	tui := &TUI{store: &callStore{}, clock: func() time.Time { return now }}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 80, Height: 20})
+
+	parent := &sdkv1.RunRequest{Function: "parent", DispatchId: "1", RootDispatchId: "1"}
+	child := &sdkv1.RunRequest{Function: "child", DispatchId: "2", RootDispatchId: "1", ParentDispatchId: "1"}
+	tui.store.ObserveRequest(start, parent)
+	tui.store.ObserveRequest(start.Add(time.Second), child)
+	tui.store.ObserveResponse(start.Add(3*time.Second), child, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+
+	// The functions view is rendered at the time of the clock.
+	view := tui.View()
+	assert.Regexp(t, `parent\s+1\s+5s\s+• Running`, view)
+	assert.Regexp(t, `child\s+1\s+2s\s+✔ OK`, view)
+	assert.Equal(t, view, tui.View())
+
+	// So is the detail view.
+	id := DispatchID("1")
+	tui.selected = &id
+	tui.activeTab = detailTab
+	view = tui.View()
+	assert.Regexp(t, `Duration: 5s\s`, view)
+	assert.Regexp(t, `Status: Running\s`, view)
+
+	now = now.Add(time.Minute)
+	assert.Regexp(t, `Duration: 1m5s\s`, tui.View())
+}
+
+func TestTUIScrollIndicator(t *testing.T) {
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{}}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 80, Height: 20})
+
+	// Retry the function call enough times for the
+	// detail view to overflow the window.
+	req := &sdkv1.RunRequest{
+		Function:       "my_function",
+		DispatchId:     "1",
+		RootDispatchId: "1",
+		Directive:      &sdkv1.RunRequest_Input{Input: asAny(wrapperspb.Int64(42))},
+	}
+	res := &sdkv1.RunResponse{Status: sdkv1.Status_STATUS_TEMPORARY_ERROR}
+	for i := 0; i < 10; i++ {
+		tui.store.ObserveRequest(now, req)
+		tui.store.ObserveResponse(now, req, nil, nil, res)
+	}
+
+	id := DispatchID("1")
+	tui.selected = &id
+	tui.activeTab = detailTab
+
+	tui.tailMode = false
+	view := tui.View()
+	assert.True(t, strings.HasSuffix(statusBar(view), "0%"), "expected scroll indicator at 0%%")
+
+	tui.tailMode = true
+	view = tui.View()
+	assert.True(t, strings.HasSuffix(statusBar(view), "100%"), "expected scroll indicator at 100%%")
+}
+
+func TestTUIContentChangeDetection(t *testing.T) {
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{}}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+
+	req := &sdkv1.RunRequest{Function: "my_function", DispatchId: "1", RootDispatchId: "1"}
+	tui.store.ObserveRequest(now, req)
+	tui.store.ObserveResponse(now.Add(time.Second), req, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+	assert.Contains(t, tui.View(), "my_function")
+
+	// Change the data behind the TUI's back: the content isn't rebuilt on
+	// a tick, since nothing was observed.
+	n := tui.store.calls["1"]
+	n.lastFunction = "renamed_function"
+	tui.store.calls["1"] = n
+	tui.Update(tickMsg{})
+	view := tui.View()
+	assert.Contains(t, view, "my_function")
+	assert.NotContains(t, view, "renamed_function")
+
+	// Observing a function call, writing logs or resizing the window
+	// cause the content to be rebuilt.
+	tui.store.ObserveRequest(now, &sdkv1.RunRequest{Function: "other_function", DispatchId: "2", RootDispatchId: "2"})
+	view = tui.View()
+	assert.Contains(t, view, "renamed_function")
+	assert.Contains(t, view, "other_function")
+
+	tui.Update(tea.KeyMsg{Type: tea.KeyTab})
+	assert.NotContains(t, tui.View(), "log line")
+	fmt.Fprintln(tui, "log line")
+	assert.Contains(t, tui.View(), "log line")
+
+	// The content of in-flight calls changes over time, so it's rebuilt
+	// on every render.
+	tui.Update(tea.KeyMsg{Type: tea.KeyTab})
+	tui.Update(tea.KeyMsg{Type: tea.KeyTab})
+	assert.Contains(t, tui.View(), "other_function")
+	n = tui.store.calls["2"]
+	n.lastFunction = "renamed_other_function"
+	tui.store.calls["2"] = n
+	tui.Update(tickMsg{})
+	assert.Contains(t, tui.View(), "renamed_other_function")
+}
+
+func TestTUIFunctionColumnWidth(t *testing.T) {
+	tests := []struct {
+		scenario         string
+		minWidth         int
+		maxWidth         int
+		terminalWidth    int
+		maxFunctionWidth int
+		expected         int
+	}{
+		{"short names", 0, 0, 120, 5, 9},
+		{"name fits", 0, 0, 120, 30, 30},
+		{"wide terminal", 0, 0, 200, 100, 100},
+		{"wide terminal and long names", 0, 0, 200, 300, 200 - 4 - otherColumnsWidth},
+		{"narrow terminal", 0, 0, 80, 30, 80 - 4 - otherColumnsWidth},
+		{"very narrow terminal", 0, 0, 40, 30, 9},
+		{"unknown terminal width", 0, 0, 0, 100, 50},
+		{"configured minimum", 20, 0, 120, 5, 20},
+		{"configured maximum", 0, 15, 200, 100, 15},
+		{"configured minimum and maximum", 12, 15, 200, 5, 12},
+	}
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			tui := &TUI{store: &callStore{}, functionColumnMinWidth: test.minWidth, functionColumnMaxWidth: test.maxWidth}
+			tui.viewport.Width = test.terminalWidth
+			assert.Equal(t, test.expected, tui.functionColumnWidth(test.maxFunctionWidth))
+		})
+	}
+}
+
+func TestTUIFunctionColumnFits(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	now := time.Now()
+
+	name := strings.Repeat("x", 100)
+	for _, width := range []int{80, 120, 200} {
+		tui := &TUI{store: &callStore{}}
+		tui.Init()
+		tui.Update(tea.WindowSizeMsg{Width: width, Height: 20})
+		tui.store.ObserveRequest(now, &sdkv1.RunRequest{Function: name, DispatchId: "1", RootDispatchId: "1"})
+
+		// The function column is truncated so that the table rows fit.
+		rows := 0
+		for _, line := range strings.Split(tui.View(), "\n") {
+			if strings.Contains(line, "Function") || strings.Contains(line, "xxx") {
+				assert.LessOrEqual(t, lipgloss.Width(line), width, line)
+				rows++
+			}
+		}
+		assert.Equal(t, 2, rows)
+	}
+}
+
+func TestTUIHorizontalScroll(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	now := time.Now()
+
+	name := strings.Repeat("a", 40) + strings.Repeat("b", 40) + strings.Repeat("c", 40)
+	tui := &TUI{store: &callStore{}, clock: func() time.Time { return now }}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 80, Height: 20})
+	tui.store.ObserveRequest(now, &sdkv1.RunRequest{Function: name, DispatchId: "1", RootDispatchId: "1"})
+
+	row := func() string {
+		for _, line := range strings.Split(tui.View(), "\n") {
+			if strings.Contains(line, "aaaa") || strings.Contains(line, "cccc") {
+				assert.LessOrEqual(t, lipgloss.Width(line), 80, line)
+				return strings.TrimSpace(clearANSI(line))
+			}
+		}
+		t.Fatal("function call row not found")
+		return ""
+	}
+
+	// Without an offset, the function name is truncated to fit.
+	assert.Regexp(t, "^a+\\.\\.\\. ", row())
+
+	// Scrolling right shows the rest of the function name, which is no
+	// longer truncated.
+	right := tea.KeyMsg{Type: tea.KeyRight}
+	left := tea.KeyMsg{Type: tea.KeyLeft}
+	tui.Update(right)
+	assert.Equal(t, horizontalScrollStep, tui.xOffset)
+	assert.Regexp(t, "^a{32}b{40}c+$", row())
+
+	tui.Update(right)
+	tui.Update(right)
+	tui.Update(right)
+	tui.Update(right)
+	tui.Update(right)
+	assert.Regexp(t, "^b{32}c{40}$", row())
+
+	// The offset is capped so that the end of the table remains visible.
+	for i := 0; i < 20; i++ {
+		tui.Update(right)
+	}
+	assert.Regexp(t, "^c{18} +1 +\\? +• Running$", row())
+	tableWidth := len(name) + otherColumnsWidth
+	assert.Equal(t, tableWidth-(80-viewportStyle.GetHorizontalFrameSize()), tui.xOffset)
+
+	// Scrolling back left restores the truncated layout.
+	for i := 0; i < 20; i++ {
+		tui.Update(left)
+	}
+	assert.Equal(t, 0, tui.xOffset)
+	assert.Regexp(t, "^a+\\.\\.\\. ", row())
+}
+
+func TestTUIDetailTabWithoutSelection(t *testing.T) {
+	tui := &TUI{store: &callStore{}}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 80, Height: 20})
+
+	tab := tea.KeyMsg{Type: tea.KeyTab}
+	tui.Update(tab) // logs
+	tui.Update(tab) // detail
+	assert.Equal(t, detailTab, tui.activeTab)
+	assert.Nil(t, tui.selected)
+
+	view := tui.View()
+	assert.Equal(t, "Select a function (press s) to view its details", statusBar(view))
+
+	tui.Update(tab)
+	assert.Equal(t, functionsTab, tui.activeTab)
+}
+
+func TestTUIFullHelp(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+
+	tui := &TUI{store: &callStore{}}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+
+	view := tui.View()
+	assert.True(t, strings.HasSuffix(view, "  tab show logs • ? toggle help • q quit"))
+	shortHeight := lipgloss.Height(view)
+
+	help := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("?")}
+	tui.Update(help)
+	assert.True(t, tui.fullHelp)
+
+	// The full help lists the key bindings one per line.
+	view = tui.View()
+	assert.Regexp(t, "tab +show logs *\n +\\? +toggle help *\n +q +quit *$", view)
+	assert.Equal(t, shortHeight+2, lipgloss.Height(view))
+
+	// The viewport shrinks to make room for the full help.
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(tui, "log line %d\n", i)
+	}
+	tui.Update(tea.KeyMsg{Type: tea.KeyTab})
+	assert.Equal(t, logsTab, tui.activeTab)
+	view = tui.View()
+	assert.Contains(t, view, "log line 90")
+	assert.LessOrEqual(t, lipgloss.Height(view), 20)
+
+	tui.Update(help)
+	assert.False(t, tui.fullHelp)
+	view = tui.View()
+	assert.LessOrEqual(t, lipgloss.Height(view), 20)
+}
+
+// statusBar returns the status bar line of a rendered TUI view.
+func statusBar(view string) string {
+	lines := strings.Split(view, "\n")
+	if len(lines) < 3 {
+		return ""
+	}
+	return strings.TrimSpace(lines[len(lines)-3])
+}
+
+func TestTUILastFailedCall(t *testing.T) {
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{}}
+	tui.Init()
+
+	f := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")}
+
+	tui.Update(f)
+	assert.Equal(t, functionsTab, tui.activeTab)
+	assert.Nil(t, tui.selected)
+
+	for _, call := range []struct {
+		id     string
+		status sdkv1.Status
+	}{
+		{"1", sdkv1.Status_STATUS_OK},
+		{"2", sdkv1.Status_STATUS_PERMANENT_ERROR},
+		{"3", sdkv1.Status_STATUS_TEMPORARY_ERROR}, // retried, not done
+		{"4", sdkv1.Status_STATUS_OK},
+	} {
+		req := &sdkv1.RunRequest{
+			Function:       "my_function",
+			DispatchId:     call.id,
+			RootDispatchId: call.id,
+		}
+		res := &sdkv1.RunResponse{
+			Status:    call.status,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		}
+		tui.store.ObserveRequest(now, req)
+		tui.store.ObserveResponse(now, req, nil, nil, res)
+	}
+
+	tui.Update(f)
+	assert.Equal(t, detailTab, tui.activeTab)
+	if assert.NotNil(t, tui.selected) {
+		assert.Equal(t, DispatchID("2"), *tui.selected)
+	}
+}
+
+func TestTUIClearFinishedCalls(t *testing.T) {
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{}}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 80, Height: 20})
+
+	exit := &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	}
+	failed := &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_PERMANENT_ERROR,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	}
+
+	// A finished hierarchy.
+	done := &sdkv1.RunRequest{Function: "done", DispatchId: "1", RootDispatchId: "1"}
+	doneChild := &sdkv1.RunRequest{Function: "done_child", DispatchId: "2", RootDispatchId: "1", ParentDispatchId: "1"}
+	tui.store.ObserveRequest(now, done)
+	tui.store.ObserveRequest(now, doneChild)
+	tui.store.ObserveResponse(now, doneChild, nil, nil, failed)
+	tui.store.ObserveResponse(now, done, nil, nil, exit)
+
+	// A hierarchy with an in-flight child.
+	running := &sdkv1.RunRequest{Function: "running", DispatchId: "3", RootDispatchId: "3"}
+	runningChild := &sdkv1.RunRequest{Function: "running_child", DispatchId: "4", RootDispatchId: "3", ParentDispatchId: "3"}
+	finishedChild := &sdkv1.RunRequest{Function: "finished_child", DispatchId: "5", RootDispatchId: "3", ParentDispatchId: "3"}
+	tui.store.ObserveRequest(now, running)
+	tui.store.ObserveRequest(now, runningChild)
+	tui.store.ObserveRequest(now, finishedChild)
+	tui.store.ObserveResponse(now, finishedChild, nil, nil, exit)
+
+	id := DispatchID("2")
+	tui.selected = &id
+	tui.viewport.YOffset = 5
+
+	tui.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+
+	assert.Equal(t, []DispatchID{"3"}, tui.store.orderedRoots)
+	assert.Equal(t, map[DispatchID]struct{}{"3": {}}, tui.store.roots)
+	assert.Len(t, tui.store.calls, 3)
+	assert.Contains(t, tui.store.calls, DispatchID("3"))
+	assert.Contains(t, tui.store.calls, DispatchID("4"))
+	assert.Contains(t, tui.store.calls, DispatchID("5"))
+	assert.Nil(t, tui.selected)
+	assert.Equal(t, DispatchID(""), tui.store.lastFailed)
+	assert.Equal(t, 0, tui.viewport.YOffset)
+	assert.Equal(t, "Cleared 2 finished function calls", statusBar(tui.View()))
+
+	tui.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	assert.Len(t, tui.store.calls, 3)
+	assert.Equal(t, "No finished function calls to clear", statusBar(tui.View()))
+}
+
+func TestTUITailCall(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{}, clock: func() time.Time { return now }}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+
+	tailCall := func(function, next string) {
+		tui.store.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: function})
+		tui.store.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "1"}, nil, nil, &sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{TailCall: &sdkv1.Call{Function: next}}},
+		})
+	}
+	tailCall("first", "second")
+	tailCall("second", "third")
+	tui.store.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "third"})
+
+	// The row shows the chain of tail calls, rather than only the
+	// function currently running.
+	n := tui.store.calls["1"]
+	assert.Equal(t, "third", n.function())
+	assert.Equal(t, []string{"first", "second"}, n.tailCalls)
+	assert.Regexp(t, `first → second → third +1 +\? +• Running`, tui.View())
+
+	detail := renderDetail("1", n, now)
+	assert.Regexp(t, `Tail-called by: first → second\n`, detail)
+}
+
+func TestTUIPollFanOut(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{}}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+
+	tui.store.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "parent"})
+	tui.store.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "1"}, nil, nil, &sdkv1.RunResponse{
+		Status: sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Poll{Poll: &sdkv1.Poll{Calls: []*sdkv1.Call{
+			{Function: "child"}, {Function: "child"}, {Function: "child"},
+		}}},
+	})
+	assert.Regexp(t, `parent .* Suspended \(waiting on 3 calls\)`, tui.View())
+
+	// The count decreases as the children complete.
+	for _, id := range []string{"2", "3", "4"} {
+		tui.store.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: id, RootDispatchId: "1", ParentDispatchId: "1", Function: "child"})
+	}
+	tui.store.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "2"}, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_OK,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+	assert.Regexp(t, `parent .* Suspended \(waiting on 2 calls\)`, tui.View())
+
+	for _, id := range []string{"3", "4"} {
+		tui.store.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: id}, nil, nil, &sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+	}
+	assert.Regexp(t, `parent .* Suspended\s`, tui.View())
+	assert.NotContains(t, tui.View(), "waiting on")
+}
+
+func TestTUIIncompatibleState(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{}}
+	tui.store.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "function"})
+	tui.store.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "1"}, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_TEMPORARY_ERROR,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+	tui.store.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "function"})
+	tui.store.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "1"}, nil, nil, &sdkv1.RunResponse{
+		Status:    sdkv1.Status_STATUS_INCOMPATIBLE_STATE,
+		Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+	})
+	tui.store.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "function"})
+
+	// The function call starts over, but the previous requests are kept.
+	n := tui.store.calls["1"]
+	assert.Equal(t, 1, n.attempt())
+	assert.Len(t, n.timeline, 1)
+	if assert.Len(t, n.previousTimeline, 2) {
+		assert.Equal(t, sdkv1.Status_STATUS_INCOMPATIBLE_STATE, n.previousTimeline[1].response.proto.Status)
+	}
+
+	detail := renderDetail("1", n, now)
+	assert.Contains(t, detail, "Reset: Incompatible state, the function call restarted")
+	assert.Contains(t, detail, "Previous: 2 requests")
+	assert.Regexp(t, `\d{2}:\d{2}:\d{2}\.\d{3} Temporary error\n`, detail)
+	assert.Regexp(t, `\d{2}:\d{2}:\d{2}\.\d{3} Incompatible state\n`, detail)
+}
+
+func TestTUIAttemptWarning(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the default logger!
+	now := time.Now()
+
+	tui := &TUI{store: &callStore{attemptWarning: 2}}
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(tui, nil)))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	attemptWarning := func() bool {
+		var rows rowBuffer
+		tui.buildRows(now, "1", nil, &rows)
+		return rows.rows[0].attemptWarning
+	}
+
+	for i := 0; i < 4; i++ {
+		tui.store.ObserveRequest(now, &sdkv1.RunRequest{DispatchId: "1", RootDispatchId: "1", Function: "function"})
+		assert.Equal(t, i >= 2, attemptWarning(), "attempt %d", i+1)
+
+		tui.store.ObserveResponse(now, &sdkv1.RunRequest{DispatchId: "1"}, nil, nil, &sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_TEMPORARY_ERROR,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		})
+	}
+
+	// The warning is only logged once, when the threshold is exceeded.
+	logs := tui.logs.String()
+	assert.Equal(t, 1, strings.Count(logs, "function call exceeded the attempt threshold"))
+	assert.Contains(t, logs, "function=function dispatch_id=1 attempt=3")
+
+	// The attempt column is highlighted.
+	lipgloss.SetColorProfile(termenv.ANSI)
+	t.Cleanup(func() { lipgloss.SetColorProfile(termenv.Ascii) })
+	row := tui.tableRowView(&row{function: "function", attempt: 3, attemptWarning: true}, 10, 10)
+	assert.Contains(t, row, retryStyle.Render("3"))
+}
+
+func TestCallInspector(t *testing.T) {
+	now := time.Now()
+	out := &bytes.Buffer{}
+	inspector := &callInspector{id: "2", out: out, calls: &callStore{}}
+
+	for _, id := range []string{"1", "2"} {
+		req := &sdkv1.RunRequest{
+			Function:       "function_" + id,
+			DispatchId:     id,
+			RootDispatchId: id,
+		}
+		res := &sdkv1.RunResponse{
+			Status:    sdkv1.Status_STATUS_OK,
+			Directive: &sdkv1.RunResponse_Exit{Exit: &sdkv1.Exit{}},
+		}
+		inspector.ObserveRequest(now, req)
+		inspector.ObserveResponse(now, req, nil, nil, res)
+	}
+
+	assert.Contains(t, out.String(), "function_2")
+	assert.NotContains(t, out.String(), "function_1")
+}
@@ -1,8 +1,11 @@
 package cli
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
@@ -43,6 +46,19 @@ See the documentation for more information:
 		},
 		RunE: getKey,
 	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rotate",
+		Short: "Rotate the verification key interactively",
+		Long: `Rotate the verification key interactively.
+
+The rotate command creates a new verification key and walks you through
+deploying it to your application before retiring the previous key.`,
+		SilenceUsage: true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigFlow()
+		},
+		RunE: rotateKey,
+	})
 	return cmd
 }
 
@@ -63,28 +79,19 @@ type Key struct {
 
 // TODO: create better output for created signing key
 func rolloutKey(cmd *cobra.Command, args []string) error {
-	// TODO: instantiate the api in main?
-	api := &dispatchApi{client: http.DefaultClient, apiKey: DispatchApiKey}
-
-	fn := func() (tea.Msg, error) {
+	fn := func(api *dispatchApi) (tea.Msg, error) {
 		skey, err := api.CreateSigningKey()
 		if err != nil {
 			return "", fmt.Errorf("failed to create key: %w", err)
 		}
 		return fmt.Sprintf("New key:\n\n%s", skey.Key.AsymmetricKey.PublicKey), nil
 	}
-
-	p := tea.NewProgram(newSpinnerModel("Creating a new verification key", fn))
-	_, err := p.Run()
-	return err
+	return runKeyCommand(cmd, "Creating a new verification key", fn)
 }
 
 // TODO: build table from keys
 func getKey(cmd *cobra.Command, args []string) error {
-	// TODO: instantiate the api in main?
-	api := &dispatchApi{client: http.DefaultClient, apiKey: DispatchApiKey}
-
-	fn := func() (tea.Msg, error) {
+	fn := func(api *dispatchApi) (tea.Msg, error) {
 		skeys, err := api.ListSigningKeys()
 		if err != nil {
 			return "", fmt.Errorf("failed to list keys: %w", err)
@@ -94,8 +101,156 @@ func getKey(cmd *cobra.Command, args []string) error {
 		}
 		return skeys.Keys[0].AsymmetricKey.PublicKey, nil
 	}
+	return runKeyCommand(cmd, "Fetching active verification key", fn)
+}
+
+// runKeyCommand runs fn while displaying a spinner. If the API key is
+// rejected, the user is offered to login again and fn is retried. The
+// error of fn is displayed by the spinner, so it's returned silenced.
+func runKeyCommand(cmd *cobra.Command, hello string, fn func(*dispatchApi) (tea.Msg, error)) error {
+	for {
+		// TODO: instantiate the api in main?
+		api := &dispatchApi{client: http.DefaultClient, apiKey: DispatchApiKey}
+
+		var fnErr error
+		if err := withSpinner(cmd.OutOrStdout(), hello, func() (tea.Msg, error) {
+			msg, err := fn(api)
+			fnErr = err
+			return msg, err
+		}); err != nil {
+			return err
+		}
+		if !relogin(cmd, fnErr) {
+			return silenceError(cmd, fnErr)
+		}
+	}
+}
+
+// signingKeyAPI is the subset of the Dispatch API used to manage
+// verification keys.
+type signingKeyAPI interface {
+	ListSigningKeys() (*ListSigningKeys, error)
+	CreateSigningKey() (*SigningKey, error)
+	DeleteSigningKey(signingKeyID string) error
+}
+
+func rotateKey(cmd *cobra.Command, args []string) error {
+	// TODO: instantiate the api in main?
+	api := &dispatchApi{client: http.DefaultClient, apiKey: DispatchApiKey}
+
+	w := &rotateWizard{
+		api: api,
+		in:  bufio.NewReader(os.Stdin),
+		out: os.Stdout,
+		spin: func(hello string, fn func() error) error {
+			for {
+				err := spin(cmd, hello, fn)
+				if !relogin(cmd, err) {
+					return err
+				}
+				// Only retry the step that was rejected, so that the steps
+				// that already succeeded (e.g. creating the new key) aren't
+				// run again.
+				api.apiKey = DispatchApiKey
+			}
+		},
+	}
+	return w.run()
+}
+
+// spin runs fn while displaying a spinner, and returns the error
+// returned by fn, if any. The error is displayed by the spinner, so it's
+// returned silenced.
+func spin(cmd *cobra.Command, hello string, fn func() error) error {
+	var fnErr error
+	if err := withSpinner(cmd.OutOrStdout(), hello, func() (tea.Msg, error) {
+		fnErr = fn()
+		return nil, fnErr
+	}); err != nil {
+		return err
+	}
+	return silenceError(cmd, fnErr)
+}
+
+// rotateWizard walks the user through the rotation of a verification key.
+type rotateWizard struct {
+	api  signingKeyAPI
+	in   *bufio.Reader
+	out  io.Writer
+	spin func(hello string, fn func() error) error
+}
+
+func (w *rotateWizard) run() error {
+	var oldKeys []Key
+	if err := w.spin("Fetching active verification keys", func() error {
+		skeys, err := w.api.ListSigningKeys()
+		if err != nil {
+			return fmt.Errorf("failed to list keys: %w", err)
+		}
+		oldKeys = skeys.Keys
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	w.dialog(`This wizard creates a new verification key.
+
+The new key must be deployed to your application (for example,
+as the DISPATCH_VERIFICATION_KEY environment variable) before the
+previous key is removed, otherwise function calls will be rejected.`)
+
+	if !w.confirm("Create a new verification key?") {
+		fmt.Fprintln(w.out, "Verification key rotation aborted.")
+		return nil
+	}
+
+	var newKey *SigningKey
+	if err := w.spin("Creating a new verification key", func() error {
+		skey, err := w.api.CreateSigningKey()
+		if err != nil {
+			return fmt.Errorf("failed to create key: %w", err)
+		}
+		newKey = skey
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	w.dialog("New key:\n\n%s\n\nDeploy this key to your application now.", newKey.Key.AsymmetricKey.PublicKey)
+
+	if len(oldKeys) == 0 {
+		return nil
+	}
+
+	if !w.confirm("Have you deployed the new key? Remove the previous key now?") {
+		fmt.Fprintln(w.out, "The previous key was kept. Run `dispatch verification rotate` again to create")
+		fmt.Fprintln(w.out, "another key, or remove the previous key from the Dispatch Console once the")
+		fmt.Fprintln(w.out, "new key has been deployed.")
+		return nil
+	}
+
+	for _, key := range oldKeys {
+		if key.SigningKeyID == newKey.Key.SigningKeyID {
+			continue
+		}
+		if err := w.spin("Removing the previous verification key", func() error {
+			if err := w.api.DeleteSigningKey(key.SigningKeyID); err != nil {
+				return fmt.Errorf("failed to delete key %s: %w", key.SigningKeyID, err)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(w.out, successStyle.Render("Verification key rotated"))
+	return nil
+}
+
+func (w *rotateWizard) dialog(msg string, args ...interface{}) {
+	fmt.Fprintln(w.out, renderDialog(fmt.Sprintf(msg, args...)))
+}
 
-	p := tea.NewProgram(newSpinnerModel("Fetching active verification key", fn))
-	_, err := p.Run()
-	return err
+func (w *rotateWizard) confirm(prompt string) bool {
+	return confirm(w.in, w.out, prompt)
 }
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubSigningKeyAPI struct {
+	keys    []Key
+	deleted []string
+}
+
+func (s *stubSigningKeyAPI) ListSigningKeys() (*ListSigningKeys, error) {
+	return &ListSigningKeys{Keys: append([]Key(nil), s.keys...)}, nil
+}
+
+func (s *stubSigningKeyAPI) CreateSigningKey() (*SigningKey, error) {
+	var key Key
+	key.SigningKeyID = "new"
+	key.AsymmetricKey.PublicKey = "new-public-key"
+	s.keys = append([]Key{key}, s.keys...)
+	return &SigningKey{Key: key}, nil
+}
+
+func (s *stubSigningKeyAPI) DeleteSigningKey(signingKeyID string) error {
+	s.deleted = append(s.deleted, signingKeyID)
+	return nil
+}
+
+func TestRotateWizard(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+
+	oldKey := Key{SigningKeyID: "old"}
+	oldKey.AsymmetricKey.PublicKey = "old-public-key"
+
+	tcs := []struct {
+		name    string
+		keys    []Key
+		input   string
+		created bool
+		deleted []string
+	}{
+		{
+			name:  "Abort before creating a key",
+			keys:  []Key{oldKey},
+			input: "n\n",
+		},
+		{
+			name:    "Create first key",
+			input:   "y\n",
+			created: true,
+		},
+		{
+			name:    "Create key and keep previous key",
+			keys:    []Key{oldKey},
+			input:   "y\nn\n",
+			created: true,
+		},
+		{
+			name:    "Create key and remove previous key",
+			keys:    []Key{oldKey},
+			input:   "y\nyes\n",
+			created: true,
+			deleted: []string{"old"},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			api := &stubSigningKeyAPI{keys: tc.keys}
+			out := &bytes.Buffer{}
+			w := &rotateWizard{
+				api: api,
+				in:  bufio.NewReader(strings.NewReader(tc.input)),
+				out: out,
+				spin: func(hello string, fn func() error) error {
+					return fn()
+				},
+			}
+
+			if err := w.run(); err != nil {
+				t.Fatalf("Received unexpected error: %v", err)
+			}
+
+			if tc.created {
+				assert.Contains(t, out.String(), "new-public-key")
+			} else {
+				assert.NotContains(t, out.String(), "new-public-key")
+			}
+			assert.Equal(t, tc.deleted, api.deleted)
+		})
+	}
+}
+
+func TestSpinErrorDisplayedOnce(t *testing.T) {
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+
+	// Tests don't run in a terminal, so the spinner is not displayed.
+	err := spin(cmd, "Creating a new verification key", func() error {
+		return errors.New("failed to create key")
+	})
+	assert.EqualError(t, err, "failed to create key")
+	assert.True(t, cmd.SilenceErrors)
+	assert.Equal(t, "Creating a new verification key...\nError: failed to create key\n", out.String())
+}
+
+func TestGetKeyWithoutTerminal(t *testing.T) {
+	// Do not use t.Parallel() here as we are manipulating the API URL!
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/dispatch.v1.SigningKeyService/ListSigningKeys", r.URL.Path)
+		io.WriteString(w, `{"keys":[{"signingKeyId":"1","asymmetricKey":{"publicKey":"public-key"}}]}`)
+	}))
+	defer api.Close()
+
+	apiUrl := DispatchApiUrl
+	DispatchApiUrl = api.URL
+	t.Cleanup(func() { DispatchApiUrl = apiUrl })
+
+	// Tests don't run in a terminal, so the spinner is not displayed.
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+	if err := getKey(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Fetching active verification key...\npublic-key\n", out.String())
+}
+
+func TestValidateSpinnerStyle(t *testing.T) {
+	assert.NoError(t, validateSpinnerStyle("dot"))
+	assert.NoError(t, validateSpinnerStyle("none"))
+	assert.Error(t, validateSpinnerStyle("disco"))
+}
@@ -1,7 +1,10 @@
 package cli
 
 import (
+	"fmt"
+	"runtime"
 	"runtime/debug"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -35,3 +38,17 @@ func version() string {
 	}
 	return version
 }
+
+// UserAgent is the User-Agent header of the requests sent by the CLI. If
+// empty, userAgent returns a default that identifies the CLI version and
+// platform.
+var UserAgent string
+
+func userAgent() string {
+	if UserAgent != "" {
+		return UserAgent
+	}
+	// Omit the VCS revision that follows the version, if any.
+	version, _, _ := strings.Cut(version(), " ")
+	return fmt.Sprintf("dispatch-cli/%s (%s/%s)", version, runtime.GOOS, runtime.GOARCH)
+}
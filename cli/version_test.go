@@ -2,7 +2,11 @@ package cli
 
 import (
 	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os/exec"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -50,3 +54,25 @@ func TestVersionCommand(t *testing.T) {
 		assert.Equal(t, versionText+" "+version, stderr.String())
 	})
 }
+
+func TestUserAgent(t *testing.T) {
+	userAgents := make(chan string, 1)
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer bridge.Close()
+
+	// Do not use t.Parallel() here as we are manipulating global variables!
+	prevUserAgent := UserAgent
+	t.Cleanup(func() { UserAgent = prevUserAgent })
+
+	_, _, err := poll(context.Background(), http.DefaultClient, bridge.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "dispatch-cli/devel ("+runtime.GOOS+"/"+runtime.GOARCH+")", <-userAgents)
+
+	UserAgent = "my-agent/1.0"
+	_, _, err = poll(context.Background(), http.DefaultClient, bridge.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "my-agent/1.0", <-userAgents)
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"errors"
+	"os"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+)
+
+func viewCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "view <export.json>",
+		Short: "View the function calls of an exported session",
+		Long: `View the function calls of a session exported with 'dispatch run --export'.
+
+The function calls are displayed in a read-only version of the TUI, so that
+they can be explored without reproducing the session. When the output isn't
+a terminal, the function calls table is printed instead.`,
+		GroupID:      "dispatch",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			export, err := readSessionExport(args[0])
+			if err != nil {
+				return err
+			}
+			tui, err := export.tui()
+			if err != nil {
+				return err
+			}
+
+			if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+				writeFunctionsTable(cmd.OutOrStdout(), tui.store, tui.now())
+				return nil
+			}
+
+			if _, err := tea.NewProgram(tui).Run(); err != nil && !errors.Is(err, tea.ErrProgramKilled) {
+				return err
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewSessionExport(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	session := exportTestSession(now)
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := writeSessionExport(path, session, "session", now.Add(5*time.Second), false); err != nil {
+		t.Fatal(err)
+	}
+	export, err := readSessionExport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tui, err := export.tui()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tui.Init()
+	tui.Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+
+	// The durations of in-flight calls are rendered as of the export.
+	view := tui.View()
+	assert.Regexp(t, `parent\s+1\s+5s\s+• Running`, view)
+	assert.Regexp(t, `└─ child\s+2\s+3s\s+✔ OK`, view)
+	assert.Equal(t, "2 total function calls, 1 in-flight", statusBar(view))
+	assert.NotContains(t, view, "clear finished")
+	assert.NotContains(t, view, "copy resume command")
+
+	// Keys that only apply to a running session are ignored.
+	tui.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	assert.Len(t, tui.store.calls, 2)
+	assert.Equal(t, "2 total function calls, 1 in-flight", statusBar(tui.View()))
+
+	// Function calls can still be selected to view their details.
+	tui.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	tui.Update(focusSelectMsg{})
+	tui.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("2")})
+	tui.View() // the selection is resolved when rendering
+	tui.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, detailTab, tui.activeTab)
+	assert.Contains(t, tui.View(), `Output: "secret output"`)
+}
+
+func TestViewCommand(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	session := exportTestSession(now)
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := writeSessionExport(path, session, "session", now.Add(5*time.Second), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The output isn't a terminal, so the table is printed.
+	var out bytes.Buffer
+	cmd := viewCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{path})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ""+
+		"Function   Attempt   Duration • Status\n"+
+		"parent           1         5s • Running\n"+
+		"└─ child         2         3s ✔ OK\n", out.String())
+
+	cmd = viewCommand()
+	cmd.SetArgs([]string{filepath.Join(t.TempDir(), "missing.json")})
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	assert.ErrorIs(t, cmd.Execute(), os.ErrNotExist)
+}
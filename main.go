@@ -10,6 +10,6 @@ func main() {
 	if err := cli.Main(); err != nil {
 		// The error is logged by the CLI library.
 		// No need to log here too.
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }